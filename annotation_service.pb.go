@@ -0,0 +1,147 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: annotation_service.proto
+
+package tempo_databricks_gateway
+
+import (
+	_go "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	status "google.golang.org/genproto/googleapis/rpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// AnnotateStreamResponse is the result of annotating one message of an AnnotateStream.
+type AnnotateStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// message is the annotated message, or the message as it was sent when it could not be annotated.
+	Message *_go.TempoMessage `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	// status is why the message could not be annotated, it is not set when the message was annotated.
+	Status        *status.Status `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnnotateStreamResponse) Reset() {
+	*x = AnnotateStreamResponse{}
+	mi := &file_annotation_service_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnnotateStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnnotateStreamResponse) ProtoMessage() {}
+
+func (x *AnnotateStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_annotation_service_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnnotateStreamResponse.ProtoReflect.Descriptor instead.
+func (*AnnotateStreamResponse) Descriptor() ([]byte, []int) {
+	return file_annotation_service_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AnnotateStreamResponse) GetMessage() *_go.TempoMessage {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *AnnotateStreamResponse) GetStatus() *status.Status {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+var File_annotation_service_proto protoreflect.FileDescriptor
+
+const file_annotation_service_proto_rawDesc = "" +
+	"\n" +
+	"\x18annotation_service.proto\x12\x13oncokb.annotator.v1\x1a\x17google/rpc/status.proto\x1a\vtempo.proto\"s\n" +
+	"\x16AnnotateStreamResponse\x12-\n" +
+	"\amessage\x18\x01 \x01(\v2\x13.tempo.TempoMessageR\amessage\x12*\n" +
+	"\x06status\x18\x02 \x01(\v2\x12.google.rpc.StatusR\x06status2\xa8\x01\n" +
+	"\x11AnnotationService\x12;\n" +
+	"\x0fAnnotateMessage\x12\x13.tempo.TempoMessage\x1a\x13.tempo.TempoMessage\x12V\n" +
+	"\x0eAnnotateStream\x12\x13.tempo.TempoMessage\x1a+.oncokb.annotator.v1.AnnotateStreamResponse(\x010\x01BIZGgithub.mskcc.org/cdsi/tempo-databricks-gateway;tempo_databricks_gatewayb\x06proto3"
+
+var (
+	file_annotation_service_proto_rawDescOnce sync.Once
+	file_annotation_service_proto_rawDescData []byte
+)
+
+func file_annotation_service_proto_rawDescGZIP() []byte {
+	file_annotation_service_proto_rawDescOnce.Do(func() {
+		file_annotation_service_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_annotation_service_proto_rawDesc), len(file_annotation_service_proto_rawDesc)))
+	})
+	return file_annotation_service_proto_rawDescData
+}
+
+var file_annotation_service_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_annotation_service_proto_goTypes = []any{
+	(*AnnotateStreamResponse)(nil), // 0: oncokb.annotator.v1.AnnotateStreamResponse
+	(*_go.TempoMessage)(nil),       // 1: tempo.TempoMessage
+	(*status.Status)(nil),          // 2: google.rpc.Status
+}
+var file_annotation_service_proto_depIdxs = []int32{
+	1, // 0: oncokb.annotator.v1.AnnotateStreamResponse.message:type_name -> tempo.TempoMessage
+	2, // 1: oncokb.annotator.v1.AnnotateStreamResponse.status:type_name -> google.rpc.Status
+	1, // 2: oncokb.annotator.v1.AnnotationService.AnnotateMessage:input_type -> tempo.TempoMessage
+	1, // 3: oncokb.annotator.v1.AnnotationService.AnnotateStream:input_type -> tempo.TempoMessage
+	1, // 4: oncokb.annotator.v1.AnnotationService.AnnotateMessage:output_type -> tempo.TempoMessage
+	0, // 5: oncokb.annotator.v1.AnnotationService.AnnotateStream:output_type -> oncokb.annotator.v1.AnnotateStreamResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_annotation_service_proto_init() }
+func file_annotation_service_proto_init() {
+	if File_annotation_service_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_annotation_service_proto_rawDesc), len(file_annotation_service_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_annotation_service_proto_goTypes,
+		DependencyIndexes: file_annotation_service_proto_depIdxs,
+		MessageInfos:      file_annotation_service_proto_msgTypes,
+	}.Build()
+	File_annotation_service_proto = out.File
+	file_annotation_service_proto_goTypes = nil
+	file_annotation_service_proto_depIdxs = nil
+}
@@ -0,0 +1,175 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: annotation_service.proto
+
+package tempo_databricks_gateway
+
+import (
+	context "context"
+	_go "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	AnnotationService_AnnotateMessage_FullMethodName = "/oncokb.annotator.v1.AnnotationService/AnnotateMessage"
+	AnnotationService_AnnotateStream_FullMethodName  = "/oncokb.annotator.v1.AnnotationService/AnnotateStream"
+)
+
+// AnnotationServiceClient is the client API for AnnotationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AnnotationService fills in the OncoKB fields of the events of TempoMessages.
+//
+// The annotations to run can be chosen with the annotation-types request metadata, a comma separated list of
+// mutations, cna and sv.  Only mutations are annotated by default.
+type AnnotationServiceClient interface {
+	// AnnotateMessage annotates one message.
+	AnnotateMessage(ctx context.Context, in *_go.TempoMessage, opts ...grpc.CallOption) (*_go.TempoMessage, error)
+	// AnnotateStream annotates a stream of messages, returning them in the order they were sent.  Messages are
+	// batched across the stream for OncoKB, and the server stops reading while it has a window of messages
+	// waiting to be annotated, so fast clients are held back by flow control.  A message that cannot be annotated
+	// is returned with its status and the rest of the stream carries on.
+	AnnotateStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[_go.TempoMessage, AnnotateStreamResponse], error)
+}
+
+type annotationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnnotationServiceClient(cc grpc.ClientConnInterface) AnnotationServiceClient {
+	return &annotationServiceClient{cc}
+}
+
+func (c *annotationServiceClient) AnnotateMessage(ctx context.Context, in *_go.TempoMessage, opts ...grpc.CallOption) (*_go.TempoMessage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(_go.TempoMessage)
+	err := c.cc.Invoke(ctx, AnnotationService_AnnotateMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *annotationServiceClient) AnnotateStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[_go.TempoMessage, AnnotateStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AnnotationService_ServiceDesc.Streams[0], AnnotationService_AnnotateStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[_go.TempoMessage, AnnotateStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnnotationService_AnnotateStreamClient = grpc.BidiStreamingClient[_go.TempoMessage, AnnotateStreamResponse]
+
+// AnnotationServiceServer is the server API for AnnotationService service.
+// All implementations must embed UnimplementedAnnotationServiceServer
+// for forward compatibility.
+//
+// AnnotationService fills in the OncoKB fields of the events of TempoMessages.
+//
+// The annotations to run can be chosen with the annotation-types request metadata, a comma separated list of
+// mutations, cna and sv.  Only mutations are annotated by default.
+type AnnotationServiceServer interface {
+	// AnnotateMessage annotates one message.
+	AnnotateMessage(context.Context, *_go.TempoMessage) (*_go.TempoMessage, error)
+	// AnnotateStream annotates a stream of messages, returning them in the order they were sent.  Messages are
+	// batched across the stream for OncoKB, and the server stops reading while it has a window of messages
+	// waiting to be annotated, so fast clients are held back by flow control.  A message that cannot be annotated
+	// is returned with its status and the rest of the stream carries on.
+	AnnotateStream(grpc.BidiStreamingServer[_go.TempoMessage, AnnotateStreamResponse]) error
+	mustEmbedUnimplementedAnnotationServiceServer()
+}
+
+// UnimplementedAnnotationServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAnnotationServiceServer struct{}
+
+func (UnimplementedAnnotationServiceServer) AnnotateMessage(context.Context, *_go.TempoMessage) (*_go.TempoMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AnnotateMessage not implemented")
+}
+func (UnimplementedAnnotationServiceServer) AnnotateStream(grpc.BidiStreamingServer[_go.TempoMessage, AnnotateStreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method AnnotateStream not implemented")
+}
+func (UnimplementedAnnotationServiceServer) mustEmbedUnimplementedAnnotationServiceServer() {}
+func (UnimplementedAnnotationServiceServer) testEmbeddedByValue()                           {}
+
+// UnsafeAnnotationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnnotationServiceServer will
+// result in compilation errors.
+type UnsafeAnnotationServiceServer interface {
+	mustEmbedUnimplementedAnnotationServiceServer()
+}
+
+func RegisterAnnotationServiceServer(s grpc.ServiceRegistrar, srv AnnotationServiceServer) {
+	// If the following call pancis, it indicates UnimplementedAnnotationServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AnnotationService_ServiceDesc, srv)
+}
+
+func _AnnotationService_AnnotateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(_go.TempoMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnnotationServiceServer).AnnotateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnnotationService_AnnotateMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnnotationServiceServer).AnnotateMessage(ctx, req.(*_go.TempoMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnnotationService_AnnotateStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AnnotationServiceServer).AnnotateStream(&grpc.GenericServerStream[_go.TempoMessage, AnnotateStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnnotationService_AnnotateStreamServer = grpc.BidiStreamingServer[_go.TempoMessage, AnnotateStreamResponse]
+
+// AnnotationService_ServiceDesc is the grpc.ServiceDesc for AnnotationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AnnotationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "oncokb.annotator.v1.AnnotationService",
+	HandlerType: (*AnnotationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AnnotateMessage",
+			Handler:    _AnnotationService_AnnotateMessage_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AnnotateStream",
+			Handler:       _AnnotationService_AnnotateStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "annotation_service.proto",
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func runClinical(args []string) error {
+	flags := flag.NewFlagSet("clinical", flag.ContinueOnError)
+	input := flags.String("i", "", "input clinical sample file (required)")
+	output := flags.String("o", "", "output clinical sample file (required)")
+	mafs := flags.String("a", "", "comma separated OncoKB annotated MAF files, like the output of the maf command (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" || *output == "" || *mafs == "" {
+		flags.Usage()
+		return fmt.Errorf("Error: -i, -o and -a are required")
+	}
+
+	summaries := make(map[string]tdg.SampleSummary)
+	for _, mafFile := range strings.Split(*mafs, ",") {
+		f, err := os.Open(mafFile)
+		if err != nil {
+			return fmt.Errorf("Error opening MAF file: %v", err)
+		}
+		err = summarizeMAF(f, summaries)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("Error opening clinical sample file: %v", err)
+	}
+	defer in.Close()
+	out, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("Error creating output clinical sample file: %v", err)
+	}
+	if err := tdg.AnnotateClinicalSamples(in, out, summaries); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// summarizeMAF adds the annotated events of a MAF to the summaries of their samples.
+func summarizeMAF(in io.Reader, summaries map[string]tdg.SampleSummary) error {
+	reader, err := tdg.NewMAFReader(in, tdg.WithOncoKBColumns())
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		summary := summaries[record.SampleID]
+		summary.SampleID = record.SampleID
+		summary.AddEvents(record.Event)
+		summaries[record.SampleID] = summary
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func TestSummarizeMAF(t *testing.T) {
+	f, err := os.Open(mafFile)
+	if err != nil {
+		t.Fatalf("Failed to open MAF file: %v", err)
+	}
+	defer f.Close()
+	summaries := make(map[string]tdg.SampleSummary)
+	if err := summarizeMAF(f, summaries); err != nil {
+		t.Fatalf("Failed to summarize MAF: %v", err)
+	}
+	summary := summaries["P-0038798-T01-IM6"]
+	if summary.HighestLevel != "LEVEL_3A" || len(summary.OncogenicMutations) != 1 || summary.OncogenicMutations[0] != "BRCA2_H52Qfs*16" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
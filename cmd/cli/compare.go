@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	input := flags.String("i", "", "reference MAF annotated by MafAnnotator.py (required)")
+	output := flags.String("o", "", "concordance report file, defaults to stdout")
+	asJSON := flags.Bool("json", false, "write the concordance report as JSON")
+	clinical := flags.String("c", "", "clinical sample file with SAMPLE_ID and ONCOTREE_CODE columns")
+	conf := addConfigFlags(flags)
+	conf.add(flags, "t", "sample.tumor_type", "oncotree code of the samples missing from the clinical file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		flags.Usage()
+		return fmt.Errorf("Error: -i is required")
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	oncokbAnnotator, err := config.NewAnnotator(config.NewLogger(os.Stderr))
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+	oncotreeCodes := make(map[string]string)
+	if *clinical != "" {
+		if oncotreeCodes, err = readOncotreeCodes(*clinical); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("Error opening MAF file: %v", err)
+	}
+	defer in.Close()
+	report, err := compareMAF(context.Background(), oncokbAnnotator, in, oncotreeCodes, config.Sample)
+	if report == nil {
+		return err
+	}
+	if err != nil {
+		// samples that failed to annotate show up in the report as not annotated
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return fmt.Errorf("Error creating concordance report: %v", err)
+		}
+		defer out.Close()
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.Write(out)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing concordance report: %v", err)
+	}
+	return nil
+}
+
+// compareMAF annotates a reference MAF and compares every OncoKB column of it to ours.  A report is returned along
+// with the error when only some of the samples failed to annotate.
+func compareMAF(ctx context.Context, annotator tdg.Annotator, in io.Reader,
+	oncotreeCodes map[string]string, defaults tdg.SampleConfig) (*tdg.ConcordanceReport, error) {
+
+	reader, err := tdg.NewMAFReader(in)
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, c := range reader.Header() {
+		columns[c] = i
+	}
+	for _, c := range tdg.OncoKBMAFColumns {
+		if _, exists := columns[c]; !exists {
+			return nil, fmt.Errorf("Error: reference MAF is missing OncoKB column %q", c)
+		}
+	}
+
+	report := tdg.NewConcordanceReport()
+	expected := make([]string, len(tdg.OncoKBMAFColumns))
+	err = annotateMAFRecords(ctx, annotator, reader, oncotreeCodes, defaults, func(r *tdg.MAFRecord) error {
+		for i, c := range tdg.OncoKBMAFColumns {
+			expected[i] = getField(r.Fields, columns, c)
+		}
+		report.Add(r, expected, tdg.GetOncoKBMAFValues(r.Event))
+		return nil
+	})
+	return report, err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+	"github.mskcc.org/cdsi/tempo-databricks-gateway/oncokbtest"
+)
+
+// The synthetic fixtures were built from the OncoKB columns of the test MAF, so this checks how the comparison
+// reports mismatches, not the annotations themselves against MafAnnotator.py.
+func TestCompareMAF(t *testing.T) {
+	server := oncokbtest.NewServer()
+	defer server.Close()
+	if err := server.LoadFixtures(fixturesFile); err != nil {
+		t.Fatalf("Failed to load OncoKB fixtures: %v", err)
+	}
+	oncokbAnnotator, err := tdg.NewOncoKBAnnotatorService(tdg.StaticToken("test-token"), server.MutationsURL())
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	oncotreeCodes, err := readOncotreeCodes(clinicalFile)
+	if err != nil {
+		t.Fatalf("Failed to read clinical file: %v", err)
+	}
+	f, err := os.Open(mafFile)
+	if err != nil {
+		t.Fatalf("Failed to open MAF file: %v", err)
+	}
+	defer f.Close()
+
+	report, err := compareMAF(context.Background(), oncokbAnnotator, f, oncotreeCodes, tdg.DefaultConfig().Sample)
+	if err != nil {
+		t.Fatalf("Failed to compare MAF: %v", err)
+	}
+	// the four records without an HGVSp_Short are not sent to OncoKB, everything else matches the fixtures
+	if report.Records != 13 || report.DiscordantRecords != 4 {
+		t.Errorf("expected 4 of 13 records to be discordant but got %d of %d", report.DiscordantRecords, report.Records)
+	}
+	for _, c := range report.Columns {
+		for category, count := range c.Mismatches {
+			if category != tdg.MismatchNotAnnotated && count > 0 {
+				t.Errorf("column %q: unexpected %d %q mismatches: %+v", c.Column, count, category, c.Examples)
+			}
+		}
+	}
+}
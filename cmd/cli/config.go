@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+// configEnv names the config file when -config is not given.
+const configEnv = "ONCOKB_ANNOTATOR_CONFIG"
+
+// configFlags are the flags of a command that override the config file and environment.  Only the flags given on
+// the command line are applied, so an unset flag never hides a value from the config file.
+type configFlags struct {
+	path *string
+	keys map[string]string
+	set  []flagValue
+}
+
+type flagValue struct {
+	name, value string
+}
+
+// addConfigFlags adds -config and the flags every annotating command shares.
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	c := &configFlags{
+		path: flags.String("config", "", "YAML config file, defaults to $"+configEnv),
+		keys: make(map[string]string),
+	}
+	c.add(flags, "u", "oncokb.url", "OncoKB API base URL, or its annotate/mutations/byProteinChange or byGenomicChange endpoint")
+	c.add(flags, "mode", "oncokb.mode", "online to query the OncoKB API, offline to annotate from -snapshot")
+	c.add(flags, "snapshot", "oncokb.snapshot", "comma separated recorded OncoKB responses or allAnnotatedVariants.txt, for offline mode")
+	c.add(flags, "token-file", "oncokb.token_file", "file holding the OncoKB API token, best readable only by its owner, defaults to $ONCOKB_API_TOKEN")
+	c.add(flags, "token-cmd", "oncokb.token_command", `command printing the OncoKB API token, like a secret manager CLI, as a JSON array like ["vault","kv","get","-field=token","secret/oncokb"]`)
+	c.add(flags, "batch-size", "oncokb.batch_size", "events sent to OncoKB per request, 0 for all the events of a sample")
+	c.add(flags, "concurrency", "oncokb.concurrency", "OncoKB requests of a sample sent at once")
+	c.add(flags, "cache-dir", "cache.dir", "directory caching OncoKB responses across runs")
+	c.add(flags, "version-skew", "oncokb.version_skew", "fail or reannotate when OncoKB releases new data during a run")
+	c.add(flags, "log-level", "log.level", "debug, info, warn or error, debug logs every OncoKB request")
+	c.add(flags, "log-format", "log.format", "text or json log lines on stderr")
+	return c
+}
+
+// add adds a flag overriding a config key.  Defaults come from the config, so they are not shown as flag defaults.
+func (c *configFlags) add(flags *flag.FlagSet, name, key, usage string) {
+	c.keys[name] = key
+	flags.Func(name, usage+" (config "+key+")", func(value string) error {
+		c.set = append(c.set, flagValue{name, value})
+		return nil
+	})
+}
+
+// load layers the defaults, the config file, the environment and the flags given, and validates the result.
+func (c *configFlags) load() (tdg.Config, error) {
+	config := tdg.DefaultConfig()
+	path := *c.path
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	if path != "" {
+		var err error
+		if config, err = tdg.LoadConfig(path); err != nil {
+			return config, err
+		}
+	}
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+	// the flags are a layer of their own, giving at most one token source like the config file and the environment
+	tokenFlags := make(map[string]bool)
+	for _, f := range c.set {
+		if f.name == "token-file" || f.name == "token-cmd" {
+			tokenFlags[f.name] = true
+		}
+	}
+	if len(tokenFlags) > 1 {
+		return config, fmt.Errorf("Error: only one of -token-file and -token-cmd can be given")
+	}
+	for _, f := range c.set {
+		if err := config.Set(c.keys[f.name], f.value); err != nil {
+			return config, fmt.Errorf("Error: invalid -%s: %v", f.name, err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func runConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	conf := addConfigFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: oncokb-annotator config [flags]\n\nprints the effective config, with secrets redacted")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	return config.WriteYAML(os.Stdout)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "oncokb:\n  url: https://oncokb.example.org/api/v1\n  batch_size: 10\n  concurrency: 2\nserver:\n  addr: :9090\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv(configEnv, path)
+	t.Setenv("ONCOKB_BATCH_SIZE", "20")
+	t.Setenv("ONCOKB_CONCURRENCY", "4")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	conf := addConfigFlags(flags)
+	conf.add(flags, "addr", "server.addr", "address to listen on")
+	if err := flags.Parse([]string{"-batch-size", "30"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	loaded, err := conf.load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.OncoKB.BatchSize != 30 || loaded.OncoKB.Concurrency != 4 || loaded.OncoKB.URL != "https://oncokb.example.org/api/v1" {
+		t.Errorf("expected flags over the environment over the config file but got %+v", loaded.OncoKB)
+	}
+	if loaded.Server.Addr != ":9090" {
+		t.Errorf("expected an unset flag to keep the config file value but got %q", loaded.Server.Addr)
+	}
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	conf = addConfigFlags(flags)
+	if err := flags.Parse([]string{"-concurrency", "0"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if _, err := conf.load(); err == nil {
+		t.Errorf("expected a validation error for a concurrency of 0")
+	}
+
+	// a token source given by a flag replaces the token file of the config file, but a layer gives only one
+	if err := os.WriteFile(path, []byte("oncokb:\n  token_file: /run/secrets/oncokb\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	conf = addConfigFlags(flags)
+	if err := flags.Parse([]string{"-token-cmd", `["vault","read"]`}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if loaded, err := conf.load(); err != nil || loaded.OncoKB.TokenFile != "" || len(loaded.OncoKB.TokenCommand) != 2 {
+		t.Errorf("expected the token command flag over the token file but got %+v (%v)", loaded.OncoKB, err)
+	}
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	conf = addConfigFlags(flags)
+	if err := flags.Parse([]string{"-token-file", "token", "-token-cmd", `["vault","read"]`}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if _, err := conf.load(); err == nil {
+		t.Errorf("expected an error for a token file and a token command flag")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+// maxMessageEvents bounds the events annotated together, so memory stays constant on a sample with many mutations.
+const maxMessageEvents = 1000
+
+func runMAF(args []string) error {
+	flags := flag.NewFlagSet("maf", flag.ContinueOnError)
+	input := flags.String("i", "", "input MAF file (required)")
+	output := flags.String("o", "", "output MAF file (required)")
+	clinical := flags.String("c", "", "clinical sample file with SAMPLE_ID and ONCOTREE_CODE columns")
+	conf := addConfigFlags(flags)
+	conf.add(flags, "t", "sample.tumor_type", "oncotree code of the samples missing from the clinical file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" || *output == "" {
+		flags.Usage()
+		return fmt.Errorf("Error: -i and -o are required")
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	oncokbAnnotator, err := config.NewAnnotator(config.NewLogger(os.Stderr))
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+	oncotreeCodes := make(map[string]string)
+	if *clinical != "" {
+		if oncotreeCodes, err = readOncotreeCodes(*clinical); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("Error opening MAF file: %v", err)
+	}
+	defer in.Close()
+	out, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("Error creating output MAF file: %v", err)
+	}
+	if err := annotateMAF(context.Background(), oncokbAnnotator, in, out, oncotreeCodes, config.Sample); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// annotateMAF streams the rows of a MAF, annotating consecutive rows of the same sample together, and writes them
+// back out in their original order with the OncoKB columns filled in.  Rows that cannot be queried by protein change
+// are not sent to OncoKB and are written with ANNOTATED set to False.
+func annotateMAF(ctx context.Context, annotator tdg.Annotator, in io.Reader, out io.Writer,
+	oncotreeCodes map[string]string, defaults tdg.SampleConfig) error {
+
+	reader, err := tdg.NewMAFReader(in)
+	if err != nil {
+		return err
+	}
+	writer, err := tdg.NewMAFWriter(out, reader.Header(), reader.Comments())
+	if err != nil {
+		return err
+	}
+	err = annotateMAFRecords(ctx, annotator, reader, oncotreeCodes, defaults, writer.Write)
+	if flushErr := writer.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// annotateMAFRecords annotates the records of reader and passes them to emit in their original order.  Samples that
+// fail to annotate are reported on stderr and their records are still emitted, the error returned at the end lists them.
+func annotateMAFRecords(ctx context.Context, annotator tdg.Annotator, reader *tdg.MAFReader,
+	oncotreeCodes map[string]string, defaults tdg.SampleConfig, emit func(*tdg.MAFRecord) error) error {
+
+	var pending []*tdg.MAFRecord
+	var failed []string
+	// a sample is counted once even when its records are not contiguous or span several messages
+	samples := make(map[string]bool)
+	failedSamples := make(map[string]bool)
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := annotateRecords(ctx, annotator, pending, oncotreeCodes, defaults); err != nil {
+			fmt.Fprintf(os.Stderr, "Error annotating mutations of sample %q: %v\n", pending[0].SampleID, err)
+			if !failedSamples[pending[0].SampleID] {
+				failedSamples[pending[0].SampleID] = true
+				failed = append(failed, pending[0].SampleID)
+			}
+		}
+		for _, r := range pending {
+			if err := emit(r); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		samples[record.SampleID] = true
+		if len(pending) > 0 && (pending[0].SampleID != record.SampleID || len(pending) == maxMessageEvents) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		pending = append(pending, record)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Error annotating %d of %d samples: %s", len(failed), len(samples), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// annotateRecords annotates records of the same sample as one TempoMessage, using the default tumor type for samples
+// missing from oncotreeCodes.
+func annotateRecords(ctx context.Context, annotator tdg.Annotator, records []*tdg.MAFRecord,
+	oncotreeCodes map[string]string, defaults tdg.SampleConfig) error {
+
+	sample := records[0].SampleID
+	oncotreeCode := defaults.TumorType
+	if code, ok := oncotreeCodes[sample]; ok && code != "" {
+		oncotreeCode = code
+	}
+	events := make([]*tt.Event, len(records))
+	for i, r := range records {
+		events[i] = r.Event
+	}
+	return annotateEvents(ctx, annotator, sample, oncotreeCode, defaults, events)
+}
+
+// annotateEvents annotates the events of a sample as one TempoMessage.  The annotator queries OncoKB by protein change,
+// so events without an HGVSp_Short, gene or Variant_Classification are not sent and are marked as not annotated.
+// The pipeline version, and the build of events that do not name one, come from defaults.
+func annotateEvents(ctx context.Context, annotator tdg.Annotator, sample, oncotreeCode string, defaults tdg.SampleConfig,
+	events []*tt.Event) error {
+
+	tm := &tt.TempoMessage{
+		CmoSampleId:       sample,
+		NormalCmoSampleId: sample,
+		PipelineVersion:   defaults.PipelineVersion,
+		OncotreeCode:      oncotreeCode,
+	}
+	for _, e := range events {
+		if e.HgvspShort == "" || e.HugoSymbol == "" || e.VariantClassification == "" {
+			e.OncokbAnnotated = "false"
+			continue
+		}
+		if e.NcbiBuild == "" {
+			e.NcbiBuild = defaults.NcbiBuild
+		}
+		tm.Events = append(tm.Events, e)
+	}
+	if len(tm.Events) == 0 {
+		return nil
+	}
+	return annotator.AnnotateMutations(ctx, tm)
+}
+
+// getColumnIndexes maps the columns of a header to their index, and checks the required columns are there.
+func getColumnIndexes(header []string, required ...string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, c := range header {
+		columns[c] = i
+	}
+	for _, c := range required {
+		if _, exists := columns[c]; !exists {
+			return nil, fmt.Errorf("Error: missing required column %q", c)
+		}
+	}
+	return columns, nil
+}
+
+func getField(fields []string, columns map[string]int, column string) string {
+	if i, exists := columns[column]; exists && i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+func readOncotreeCodes(clinicalFile string) (map[string]string, error) {
+	f, err := os.Open(clinicalFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening clinical file: %v", err)
+	}
+	defer f.Close()
+	oncotreeCodes := make(map[string]string)
+	var columns map[string]int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if columns == nil {
+			if columns, err = getColumnIndexes(fields, "SAMPLE_ID", "ONCOTREE_CODE"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		oncotreeCodes[getField(fields, columns, "SAMPLE_ID")] = getField(fields, columns, "ONCOTREE_CODE")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading clinical file: %v", err)
+	}
+	return oncotreeCodes, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+	"github.mskcc.org/cdsi/tempo-databricks-gateway/oncokbtest"
+)
+
+const (
+	clinicalFile = "../../testdata/data_clinical_sample.oncokb.txt"
+	mafFile      = "../../testdata/data_mutations_extended.oncokb.txt"
+	fixturesFile = "../../testdata/oncokb_synthetic_fixtures.json"
+)
+
+// The test MAF already carries the MafAnnotator.py columns and the synthetic fixtures were built from them, so
+// annotating it again against the fake OncoKB server must reproduce the input.  This checks the MAF round trip,
+// not the annotations themselves against MafAnnotator.py.
+func TestAnnotateMAF(t *testing.T) {
+	server := oncokbtest.NewServer()
+	defer server.Close()
+	if err := server.LoadFixtures(fixturesFile); err != nil {
+		t.Fatalf("Failed to load OncoKB fixtures: %v", err)
+	}
+	oncokbAnnotator, err := tdg.NewOncoKBAnnotatorService(tdg.StaticToken("test-token"), server.MutationsURL())
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	oncotreeCodes, err := readOncotreeCodes(clinicalFile)
+	if err != nil {
+		t.Fatalf("Failed to read clinical file: %v", err)
+	}
+	expected, err := os.ReadFile(mafFile)
+	if err != nil {
+		t.Fatalf("Failed to read MAF file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := annotateMAF(context.Background(), oncokbAnnotator, bytes.NewReader(expected), &out, oncotreeCodes, tdg.DefaultConfig().Sample); err != nil {
+		t.Fatalf("Failed to annotate MAF: %v", err)
+	}
+
+	expectedLines := strings.Split(strings.TrimSpace(string(expected)), "\n")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(expectedLines) {
+		t.Fatalf("expected %d lines but got %d", len(expectedLines), len(lines))
+	}
+	header := strings.Split(lines[0], "\t")
+	hgvsp := indexOf(header, "HGVSp_Short")
+	annotated := indexOf(header, "ANNOTATED")
+	for i := range lines {
+		fields := strings.Split(lines[i], "\t")
+		if fields[hgvsp] == "" {
+			if fields[annotated] != "False" {
+				t.Errorf("line %d: expected a row without HGVSp_Short to not be annotated", i+1)
+			}
+			continue
+		}
+		if lines[i] != expectedLines[i] {
+			t.Errorf("line %d:\nexpected %q\n but got %q", i+1, expectedLines[i], lines[i])
+		}
+	}
+}
+
+func TestAnnotateMAFMissingColumn(t *testing.T) {
+	in := strings.NewReader("Hugo_Symbol\tHGVSp_Short\nBRAF\tp.V600E\n")
+	err := annotateMAF(context.Background(), nil, in, &bytes.Buffer{}, nil, tdg.DefaultConfig().Sample)
+	if err == nil || !strings.Contains(err.Error(), "Tumor_Sample_Barcode") {
+		t.Errorf("expected a missing Tumor_Sample_Barcode error but got %v", err)
+	}
+}
+
+func TestAnnotateMAFFailedSamples(t *testing.T) {
+	in := strings.NewReader("Hugo_Symbol\tTumor_Sample_Barcode\tVariant_Classification\tHGVSp_Short\n" +
+		"BRAF\ts-1\tMissense_Mutation\tp.V600E\n" +
+		"KRAS\ts-2\tMissense_Mutation\tp.G12D\n" +
+		"TP53\ts-1\tMissense_Mutation\tp.R273H\n")
+	err := annotateMAF(context.Background(), failingAnnotator{sampleID: "s-1"}, in, &bytes.Buffer{}, nil, tdg.DefaultConfig().Sample)
+	// s-1 is counted once even though its records are not contiguous
+	if err == nil || err.Error() != "Error annotating 1 of 2 samples: s-1" {
+		t.Errorf("expected s-1 to be the one failed sample of 2 but got %v", err)
+	}
+}
+
+// failingAnnotator fails the messages of sampleID and leaves the others unannotated.
+type failingAnnotator struct {
+	sampleID string
+}
+
+func (f failingAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	if message.CmoSampleId == f.sampleID {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (f failingAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return f.AnnotateMutations(ctx, message)
+}
+
+func (f failingAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return f.AnnotateMutations(ctx, message)
+}
+
+func indexOf(header []string, column string) int {
+	for i, c := range header {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
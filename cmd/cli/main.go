@@ -1,46 +1,58 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
-
-	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
-	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
 )
 
-func main() {
+const usage = `usage: oncokb-annotator <command> [flags]
 
-	ctx := context.Background()
+commands:
+  maf       annotate the mutations of a MAF file, like MafAnnotator.py
+  vcf       annotate the ALT alleles of a VEP or snpEff annotated VCF, writing OncoKB INFO fields
+  stream    annotate a stream of NDJSON or length-delimited protobuf TempoMessages
+  serve     serve POST /annotate over HTTP, and optionally gRPC, keeping the OncoKB token on the server
+  worker    annotate the TempoMessages of a Kafka topic into another topic, dead-lettering invalid messages
+  clinical  add sample-level OncoKB columns to a clinical sample file, like ClinicalDataAnnotator.py
+  compare   annotate a MAF annotated by MafAnnotator.py and report how our OncoKB columns compare
+  config    print the effective config, layered from the config file, environment and flags
 
-	oncokbAnnotator, err := tdg.NewOncoKBAnnotatorService("",
-		"https://www.oncokb.org/api/v1/annotate/mutations/byProteinChange")
-	if err != nil {
-		fmt.Errorf("Failed to create a OncoKBAnnotatorService: %v", err)
-		os.Exit(1)
-	}
+run oncokb-annotator <command> -h for the flags of a command.  Flags override the environment, which overrides the
+YAML config file given with -config or $ONCOKB_ANNOTATOR_CONFIG.`
 
-	tempoMessage := tt.TempoMessage{
-		CmoSampleId:       "P-0086668-T16-IH4",
-		NormalCmoSampleId: "P-0086668-T16-IH4",
-		PipelineVersion:   "v1.0",
-		OncotreeCode:      "AMLNPM1",
-		Events: []*tt.Event{
-			&tt.Event{
-				HgvspShort:            "p.W288Cfs*12",
-				VariantClassification: "Frame_Shift_Ins",
-				EntrezGeneId:          "4869",
-				HugoSymbol:            "NPM1",
-				StartPosition:         "170837543",
-				EndPosition:           "170837544",
-				NcbiBuild:             "GRCh37",
-			},
-		},
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	err = oncokbAnnotator.AnnotateMutations(ctx, &tempoMessage)
+	var err error
+	switch os.Args[1] {
+	case "maf":
+		err = runMAF(os.Args[2:])
+	case "vcf":
+		err = runVCF(os.Args[2:])
+	case "stream":
+		err = runStream(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "worker":
+		err = runWorker(os.Args[2:])
+	case "clinical":
+		err = runClinical(os.Args[2:])
+	case "compare":
+		err = runCompare(os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Println(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n%s\n", os.Args[1], usage)
+		os.Exit(2)
+	}
 	if err != nil {
-		fmt.Errorf("Error annotating mutations: %v", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
 	}
-	fmt.Println("Annotated Tempo Message\n%v", tempoMessage)
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+	"google.golang.org/grpc"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	conf := addConfigFlags(flags)
+	conf.add(flags, "addr", "server.addr", "address to listen on")
+	conf.add(flags, "grpc-addr", "server.grpc_addr", "address to serve the gRPC AnnotationService on, not served when empty")
+	conf.add(flags, "rate", "server.client_rate", "requests per second allowed per client, 0 for no limit")
+	conf.add(flags, "burst", "server.client_burst", "requests a client can make at once above its rate")
+	conf.add(flags, "max-request-size", "server.max_request_size", "largest TempoMessage accepted, in bytes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	if config.OncoKB.VersionSkew == "" {
+		// a service outlives OncoKB data releases, so it moves to the new data version rather than failing
+		config.OncoKB.VersionSkew = "reannotate"
+	}
+	logger := config.NewLogger(os.Stderr)
+	annotator, err := config.NewAnnotator(logger)
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+	opts := []tdg.ServerOption{
+		tdg.WithMaxRequestSize(config.Server.MaxRequestSize),
+		tdg.WithServerLogger(logger),
+		// not ready while OncoKB cannot be reached or rejects the token
+		tdg.WithReadinessCheck(func(ctx context.Context) error { return tdg.CheckReady(ctx, annotator) }),
+	}
+	if config.Server.ClientRate > 0 {
+		opts = append(opts, tdg.WithClientRateLimit(config.Server.ClientRate, config.Server.ClientBurst))
+	}
+	annotationServer := tdg.NewAnnotationServer(annotator, opts...)
+	server := &http.Server{
+		Addr:              config.Server.Addr,
+		Handler:           annotationServer,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 2)
+	go func() {
+		logger.Info("serving OncoKB annotation", "addr", config.Server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+	var grpcServer *grpc.Server
+	if config.Server.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", config.Server.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("Error listening on %s: %v", config.Server.GRPCAddr, err)
+		}
+		grpcServer = grpc.NewServer()
+		tdg.RegisterAnnotationServiceServer(grpcServer, tdg.NewGRPCAnnotationServer(annotator))
+		defer grpcServer.Stop()
+		go func() {
+			logger.Info("serving gRPC OncoKB annotation", "addr", config.Server.GRPCAddr)
+			if err := grpcServer.Serve(listener); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("Error serving: %v", err)
+	case <-ctx.Done():
+	}
+	// stop being ready first so load balancers drain the server before it stops accepting requests
+	annotationServer.SetReady(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("Error shutting down: %v", err)
+	}
+	if grpcServer != nil {
+		stopGRPCServer(shutdownCtx, grpcServer)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Error serving: %v", err)
+	}
+	return nil
+}
+
+// stopGRPCServer lets the calls of server finish, like http.Server.Shutdown, and closes the ones still running once
+// ctx is done.  Streams can stay open indefinitely, so GracefulStop alone might never return.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func runStream(args []string) error {
+	flags := flag.NewFlagSet("stream", flag.ContinueOnError)
+	output := flags.String("o", "-", "output file, - for stdout")
+	inFormat := flags.String("f", "ndjson", "input format, ndjson (protojson per line) or proto (length-delimited protobuf)")
+	parquetDir := flags.String("parquet", "", "also export the annotated events to this directory as Parquet, partitioned by data version and run date")
+	annotations := flags.String("a", "mutations", "comma separated annotations to run: mutations, cna, sv")
+	conf := addConfigFlags(flags)
+	conf.add(flags, "of", "output.format", "output format, defaults to the input format")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: oncokb-annotator stream [flags] [file ...]\n\nreads stdin when no files or - are given")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	readFormat, err := tdg.ParseMessageFormat(*inFormat)
+	if err != nil {
+		return err
+	}
+	writeFormat := readFormat
+	if config.Output.Format != "" {
+		if writeFormat, err = tdg.ParseMessageFormat(config.Output.Format); err != nil {
+			return err
+		}
+	}
+	annotate, err := tdg.ParseAnnotationTypes(*annotations)
+	if err != nil {
+		return err
+	}
+
+	oncokbAnnotator, err := config.NewAnnotator(config.NewLogger(os.Stderr))
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			return fmt.Errorf("Error creating output file: %v", err)
+		}
+		defer out.Close()
+	}
+	writer := tdg.NewMessageWriter(out, writeFormat)
+	var export *tdg.ParquetWriter
+	if *parquetDir != "" {
+		if export, err = tdg.NewParquetWriter(*parquetDir, time.Now()); err != nil {
+			return err
+		}
+		defer export.Close()
+	}
+
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	failed := 0
+	for _, input := range inputs {
+		in := os.Stdin
+		if input != "-" {
+			if in, err = os.Open(input); err != nil {
+				return fmt.Errorf("Error opening input file: %v", err)
+			}
+		}
+		n, err := annotateStream(context.Background(), oncokbAnnotator, annotate, tdg.NewMessageReader(in, readFormat), writer, export)
+		in.Close()
+		failed += n
+		if err != nil {
+			writer.Flush()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if export != nil {
+		if err := export.Close(); err != nil {
+			return err
+		}
+	}
+	// the deferred Close only covers the early returns, a failed close here can mean lost output
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("Error closing output file: %v", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Error annotating %d messages", failed)
+	}
+	return nil
+}
+
+// annotateStream annotates each message of reader and writes it to writer, returning how many failed to annotate.
+// Messages that fail are reported on stderr and still written, so the stream downstream stays complete.  When export
+// is not nil the messages are also exported to it.
+func annotateStream(ctx context.Context, annotator tdg.Annotator, annotate []tdg.AnnotateFunc,
+	reader *tdg.MessageReader, writer *tdg.MessageWriter, export *tdg.ParquetWriter) (int, error) {
+
+	failed := 0
+	for {
+		message, err := reader.Read()
+		if err == io.EOF {
+			return failed, nil
+		}
+		if err != nil {
+			return failed, err
+		}
+		for _, a := range annotate {
+			if err := a(annotator, ctx, message); err != nil {
+				fmt.Fprintf(os.Stderr, "Error annotating sample %q: %v\n", message.CmoSampleId, err)
+				failed++
+				break
+			}
+		}
+		if err := writer.Write(message); err != nil {
+			return failed, err
+		}
+		if export != nil {
+			if err := export.Write(message); err != nil {
+				return failed, err
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+	"github.mskcc.org/cdsi/tempo-databricks-gateway/oncokbtest"
+)
+
+func TestAnnotateStream(t *testing.T) {
+	server := oncokbtest.NewServer()
+	defer server.Close()
+	if err := server.LoadFixtures(fixturesFile); err != nil {
+		t.Fatalf("Failed to load OncoKB fixtures: %v", err)
+	}
+	oncokbAnnotator, err := tdg.NewOncoKBAnnotatorService(tdg.StaticToken("test-token"), server.MutationsURL())
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+
+	in := `{"cmoSampleId": "P-0041863-T01-IM6", "oncotreeCode": "CCRCC", "events": [{"hugoSymbol": "CHEK2", "entrezGeneId": "11200",` +
+		` "hgvspShort": "p.S428F", "variantClassification": "Missense_Mutation", "ncbiBuild": "GRCh37"}]}` + "\n" +
+		`{"cmoSampleId": "S2", "events": [{"hugoSymbol": "CHEK2", "variantClassification": "Missense_Mutation"}]}` + "\n"
+	var out bytes.Buffer
+	writer := tdg.NewMessageWriter(&out, tdg.MessageFormatProto)
+	failed, err := annotateStream(context.Background(), oncokbAnnotator,
+		[]tdg.AnnotateFunc{tdg.AnnotationTypes["mutations"]},
+		tdg.NewMessageReader(strings.NewReader(in), tdg.MessageFormatNDJSON), writer, nil)
+	if err != nil {
+		t.Fatalf("Failed to annotate stream: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush stream: %v", err)
+	}
+	if failed != 1 {
+		t.Errorf("expected the message without an HGVSp_Short to fail but got %d failures", failed)
+	}
+
+	reader := tdg.NewMessageReader(&out, tdg.MessageFormatProto)
+	annotated, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Failed to read annotated message: %v", err)
+	}
+	if e := annotated.Events[0]; e.OncokbOncogenic != "Likely Oncogenic" || e.OncokbHighestLevel != "LEVEL_3B" {
+		t.Errorf("expected CHEK2 S428F to be annotated from the fixtures but got %v", e)
+	}
+	if failedMessage, err := reader.Read(); err != nil || failedMessage.CmoSampleId != "S2" {
+		t.Errorf("expected the failed message to still be written but got %v, %v", failedMessage, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func runVCF(args []string) error {
+	flags := flag.NewFlagSet("vcf", flag.ContinueOnError)
+	input := flags.String("i", "", "input VCF annotated by VEP (CSQ) or snpEff (ANN) (required)")
+	output := flags.String("o", "", "output VCF (required)")
+	sample := flags.String("s", "", "sample ID, defaults to the first sample column of the VCF")
+	conf := addConfigFlags(flags)
+	conf.add(flags, "t", "sample.tumor_type", "oncotree code of the sample")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" || *output == "" {
+		flags.Usage()
+		return fmt.Errorf("Error: -i and -o are required")
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	oncokbAnnotator, err := config.NewAnnotator(config.NewLogger(os.Stderr))
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+	in, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("Error opening VCF file: %v", err)
+	}
+	defer in.Close()
+	out, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("Error creating output VCF file: %v", err)
+	}
+	if err := annotateVCF(context.Background(), oncokbAnnotator, in, out, *sample, config.Sample); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// annotateVCF streams the records of a VCF, annotating the events of up to maxMessageEvents ALT alleles together, and
+// writes them back out with the OncoKB results as INFO fields.  The oncotree code of the sample is the default tumor type.
+func annotateVCF(ctx context.Context, annotator tdg.Annotator, in io.Reader, out io.Writer, sample string, defaults tdg.SampleConfig) error {
+	reader, err := tdg.NewVCFReader(in)
+	if err != nil {
+		return err
+	}
+	if sample == "" && len(reader.Samples()) > 0 {
+		sample = reader.Samples()[0]
+	}
+	writer, err := tdg.NewVCFWriter(out, reader.Meta(), reader.Header())
+	if err != nil {
+		return err
+	}
+
+	var pending []*tdg.VCFRecord
+	var events []*tt.Event
+	failed := 0
+	flush := func() error {
+		if err := annotateEvents(ctx, annotator, sample, defaults.TumorType, defaults, events); err != nil {
+			fmt.Fprintf(os.Stderr, "Error annotating mutations: %v\n", err)
+			failed += len(events)
+		}
+		for _, r := range pending {
+			if err := writer.Write(r); err != nil {
+				return err
+			}
+		}
+		pending, events = pending[:0], events[:0]
+		return nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var recordEvents []*tt.Event
+		for _, e := range record.Events {
+			if e != nil {
+				recordEvents = append(recordEvents, e)
+			}
+		}
+		// the ALT alleles of a record are annotated together, flushing first if they would go over maxMessageEvents
+		if len(events) > 0 && len(events)+len(recordEvents) > maxMessageEvents {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		pending = append(pending, record)
+		events = append(events, recordEvents...)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("Error annotating %d ALT alleles", failed)
+	}
+	return nil
+}
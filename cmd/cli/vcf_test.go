@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+	"github.mskcc.org/cdsi/tempo-databricks-gateway/oncokbtest"
+)
+
+func TestAnnotateVCF(t *testing.T) {
+	server := oncokbtest.NewServer()
+	defer server.Close()
+	if err := server.LoadFixtures(fixturesFile); err != nil {
+		t.Fatalf("Failed to load OncoKB fixtures: %v", err)
+	}
+	oncokbAnnotator, err := tdg.NewOncoKBAnnotatorService(tdg.StaticToken("test-token"), server.MutationsURL())
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+
+	vcf := "##fileformat=VCFv4.2\n" +
+		"##INFO=<ID=CSQ,Number=.,Type=String,Description=\"Consequence annotations from Ensembl VEP. Format: Allele|Consequence|SYMBOL|HGVSp\">\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tP-0041863-T01-IM6\n" +
+		"22\t29091207\t.\tG\tA\t.\tPASS\tCSQ=A|missense_variant|CHEK2|ENSP00000372023.3:p.Ser428Phe\tGT\t0/1\n" +
+		"22\t29091300\t.\tG\tA\t.\tPASS\tCSQ=A|intron_variant|CHEK2|\tGT\t0/1\n"
+	var out bytes.Buffer
+	if err := annotateVCF(context.Background(), oncokbAnnotator, strings.NewReader(vcf), &out, "", tdg.SampleConfig{TumorType: "CCRCC", PipelineVersion: "v1.0", NcbiBuild: "GRCh37"}); err != nil {
+		t.Fatalf("Failed to annotate VCF: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	chek2 := strings.Split(lines[len(lines)-2], "\t")[7]
+	if !strings.Contains(chek2, "ONCOKB_ONCOGENIC=Likely%20Oncogenic") || !strings.Contains(chek2, "ONCOKB_HIGHEST_LEVEL=LEVEL_3B") {
+		t.Errorf("expected CHEK2 S428F to be annotated from the fixtures but got %q", chek2)
+	}
+	if intron := strings.Split(lines[len(lines)-1], "\t")[7]; !strings.Contains(intron, "ONCOKB_ANNOTATED=False") {
+		t.Errorf("expected the intron variant to not be annotated but got %q", intron)
+	}
+}
+
+func TestAnnotateVCFMessageLimit(t *testing.T) {
+	var vcf strings.Builder
+	vcf.WriteString("##fileformat=VCFv4.2\n" +
+		"##INFO=<ID=CSQ,Number=.,Type=String,Description=\"Consequence annotations from Ensembl VEP. Format: Allele|Consequence|SYMBOL|HGVSp\">\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+	for i := 0; i < maxMessageEvents-1; i++ {
+		vcf.WriteString("22\t29091207\t.\tG\tA\t.\tPASS\tCSQ=A|missense_variant|CHEK2|ENSP00000372023.3:p.Ser428Phe\n")
+	}
+	// the two ALT alleles of the last record would take the message one over the limit
+	vcf.WriteString("22\t29091207\t.\tG\tA,T\t.\tPASS\tCSQ=A|missense_variant|CHEK2|ENSP00000372023.3:p.Ser428Phe," +
+		"T|missense_variant|CHEK2|ENSP00000372023.3:p.Ser428Tyr\n")
+	annotator := &messageSizeRecorder{}
+	if err := annotateVCF(context.Background(), annotator, strings.NewReader(vcf.String()), &bytes.Buffer{}, "", tdg.SampleConfig{}); err != nil {
+		t.Fatalf("Failed to annotate VCF: %v", err)
+	}
+	if len(annotator.sizes) != 2 || annotator.sizes[0] != maxMessageEvents-1 || annotator.sizes[1] != 2 {
+		t.Errorf("expected messages of %d and 2 events but got %v", maxMessageEvents-1, annotator.sizes)
+	}
+}
+
+// messageSizeRecorder remembers the number of events of every message it annotates.
+type messageSizeRecorder struct {
+	failingAnnotator
+	sizes []int
+}
+
+func (m *messageSizeRecorder) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	m.sizes = append(m.sizes, len(message.Events))
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	tdg "github.mskcc.org/cdsi/tempo-databricks-gateway"
+)
+
+func runWorker(args []string) error {
+	flags := flag.NewFlagSet("worker", flag.ContinueOnError)
+	conf := addConfigFlags(flags)
+	conf.add(flags, "brokers", "worker.brokers", "comma separated Kafka bootstrap brokers, like localhost:9092")
+	conf.add(flags, "in", "worker.input_topic", "topic of the TempoMessages to annotate")
+	conf.add(flags, "out", "worker.output_topic", "topic the annotated TempoMessages are published to")
+	conf.add(flags, "dlq", "worker.dead_letter_topic", "topic of the messages that cannot be annotated, defaults to the input topic with a .dlq suffix")
+	conf.add(flags, "group", "worker.group", "Kafka consumer group, the workers of a group share the partitions of the input topic")
+	conf.add(flags, "f", "worker.format", "message format of the topics, ndjson (protojson) or proto (protobuf)")
+	conf.add(flags, "a", "worker.annotations", "comma separated annotations to run: mutations, cna, sv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := conf.load()
+	if err != nil {
+		return err
+	}
+	w := config.Worker
+	if len(w.Brokers) == 0 || w.InputTopic == "" || w.OutputTopic == "" {
+		return fmt.Errorf("Error: -brokers, -in and -out are needed")
+	}
+	if config.OncoKB.VersionSkew == "" {
+		// a worker outlives OncoKB data releases, so it moves to the new data version rather than failing
+		config.OncoKB.VersionSkew = "reannotate"
+	}
+	format, err := tdg.ParseMessageFormat(w.Format)
+	if err != nil {
+		return err
+	}
+	logger := config.NewLogger(os.Stderr)
+	annotator, err := config.NewAnnotator(logger)
+	if err != nil {
+		return fmt.Errorf("Failed to create the annotator: %v", err)
+	}
+	broker, err := tdg.NewKafkaBroker(w.Brokers...)
+	if err != nil {
+		return err
+	}
+
+	// the annotator already retries OncoKB outages as configured, so the worker stops once those retries run out
+	opts := []tdg.WorkerOption{
+		tdg.WithConsumerGroup(w.Group),
+		tdg.WithMessageFormat(format),
+		tdg.WithWorkerAnnotations(w.Annotations),
+		tdg.WithWorkerRetries(1, 0),
+		tdg.WithWorkerLogger(logger),
+	}
+	if w.DeadLetterTopic != "" {
+		opts = append(opts, tdg.WithDeadLetterTopic(w.DeadLetterTopic))
+	}
+	worker, err := tdg.NewWorker(broker, annotator, w.InputTopic, w.OutputTopic, opts...)
+	if err != nil {
+		broker.Close()
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("annotating Kafka topic", "brokers", w.Brokers, "input", w.InputTopic, "output", w.OutputTopic, "group", w.Group)
+	if err := worker.Run(ctx); err != nil {
+		broker.Close()
+		return err
+	}
+	if err := broker.Close(); err != nil {
+		return fmt.Errorf("Error closing the Kafka broker: %v", err)
+	}
+	return nil
+}
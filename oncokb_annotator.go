@@ -0,0 +1,377 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// Annotator fills in the OncoKB fields of the events of a TempoMessage.
+// OncoKBAnnotatorService and OfflineAnnotator implement it, and the decorators below wrap any Annotator.
+type Annotator interface {
+	AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error
+	AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error
+	AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error
+}
+
+var _ Annotator = OncoKBAnnotatorService{}
+var _ Annotator = (*OfflineAnnotator)(nil)
+
+// ReadinessChecker is implemented by annotators that depend on something that can fail, like an
+// OncoKBAnnotatorService on OncoKB and its token.  The decorators below pass the check on to the Annotator they wrap.
+type ReadinessChecker interface {
+	CheckReady(ctx context.Context) error
+}
+
+// CheckReady checks that annotator can annotate, when it is a ReadinessChecker, for WithReadinessCheck.
+func CheckReady(ctx context.Context, annotator Annotator) error {
+	if checker, ok := annotator.(ReadinessChecker); ok {
+		return checker.CheckReady(ctx)
+	}
+	return nil
+}
+
+// AnnotateFunc is one of the methods of Annotator, like Annotator.AnnotateMutations.
+type AnnotateFunc func(Annotator, context.Context, *tt.TempoMessage) error
+
+// AnnotationTypes names the kinds of annotation, the way the server, the worker and the command line take them,
+// and lets the decorators treat them the same way.
+var AnnotationTypes = map[string]AnnotateFunc{
+	"mutations": Annotator.AnnotateMutations,
+	"cna":       Annotator.AnnotateCopyNumberAlterations,
+	"sv":        Annotator.AnnotateStructuralVariants,
+}
+
+// ParseAnnotationTypes returns the annotations named by the comma separated types, like mutations,cna,sv.
+func ParseAnnotationTypes(types string) ([]AnnotateFunc, error) {
+	var annotate []AnnotateFunc
+	for _, t := range strings.Split(types, ",") {
+		f, ok := AnnotationTypes[strings.TrimSpace(t)]
+		if !ok {
+			return nil, fmt.Errorf("Error: unknown annotation type %q, expected mutations, cna or sv", t)
+		}
+		annotate = append(annotate, f)
+	}
+	return annotate, nil
+}
+
+// retryingAnnotator retries failed calls that could succeed the next time, with an exponential backoff.
+type retryingAnnotator struct {
+	next     Annotator
+	attempts int
+	backoff  time.Duration
+	logger   *slog.Logger
+}
+
+// RetryOption configures optional behavior of the Annotator returned by NewRetryingAnnotator.
+type RetryOption func(*retryingAnnotator)
+
+// WithRetryLogger logs every retried call to logger, with its correlation ID, attempt, wait and error.
+func WithRetryLogger(logger *slog.Logger) RetryOption {
+	return func(r *retryingAnnotator) {
+		r.logger = logger
+	}
+}
+
+// NewRetryingAnnotator makes up to attempts calls to next, waiting backoff, then twice as long, and so on, between them.
+// Only network errors, rate limiting and OncoKB server errors are retried.  Every attempt has the same correlation ID.
+func NewRetryingAnnotator(next Annotator, attempts int, backoff time.Duration, opts ...RetryOption) Annotator {
+	r := &retryingAnnotator{next: next, attempts: max(attempts, 1), backoff: backoff, logger: discardLogger}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.logger == nil {
+		r.logger = discardLogger
+	}
+	return r
+}
+
+func (r *retryingAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return r.retry(ctx, message, Annotator.AnnotateMutations)
+}
+
+func (r *retryingAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return r.retry(ctx, message, Annotator.AnnotateCopyNumberAlterations)
+}
+
+func (r *retryingAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return r.retry(ctx, message, Annotator.AnnotateStructuralVariants)
+}
+
+func (r *retryingAnnotator) CheckReady(ctx context.Context) error {
+	return CheckReady(ctx, r.next)
+}
+
+func (r *retryingAnnotator) retry(ctx context.Context, message *tt.TempoMessage, annotate AnnotateFunc) error {
+	ctx, id := ensureCorrelationID(ctx)
+	wait := r.backoff
+	var err error
+	for attempt := 1; attempt <= r.attempts; attempt++ {
+		if err = annotate(r.next, ctx, message); err == nil || !isRetryable(err) || attempt == r.attempts {
+			return err
+		}
+		attrs := []any{
+			slog.String("correlation_id", id),
+			slog.String("sample_id", message.CmoSampleId),
+			slog.Int("event_count", len(message.Events)),
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+			slog.String("error", err.Error()),
+		}
+		var apiErr *OncoKBAPIError
+		if errors.As(err, &apiErr) {
+			attrs = append(attrs, slog.Int("status", apiErr.StatusCode))
+		}
+		r.logger.WarnContext(ctx, "annotation retried", attrs...)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+	return err
+}
+
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *OncoKBAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == 429 || apiErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+type cachedEvent struct {
+	dataVersion string
+	fields      []string
+}
+
+// cachingAnnotator remembers the OncoKB fields of each annotated event and reuses them for identical events,
+// only passing the events it has not seen, for the data version last returned, to the next Annotator.
+type cachingAnnotator struct {
+	next        Annotator
+	lru         *lruCache[cachedEvent]
+	dataVersion atomic.Value
+}
+
+// NewCachingAnnotator caches up to size annotated events for ttl, a ttl of zero never expires them.
+func NewCachingAnnotator(next Annotator, size int, ttl time.Duration) Annotator {
+	return &cachingAnnotator{next: next, lru: newLRUCache[cachedEvent](size, ttl)}
+}
+
+func (c *cachingAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return c.annotate(ctx, message, "mutations")
+}
+
+func (c *cachingAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return c.annotate(ctx, message, "cna")
+}
+
+func (c *cachingAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return c.annotate(ctx, message, "sv")
+}
+
+func (c *cachingAnnotator) CheckReady(ctx context.Context) error {
+	return CheckReady(ctx, c.next)
+}
+
+// Stats returns the cache hits and misses, counted per event.
+func (c *cachingAnnotator) Stats() CacheStats {
+	return c.lru.stats()
+}
+
+func (c *cachingAnnotator) annotate(ctx context.Context, message *tt.TempoMessage, kind string) error {
+	dataVersion, _ := c.dataVersion.Load().(string)
+	var misses []*tt.Event
+	var missKeys []string
+	for _, e := range message.Events {
+		key := getEventKey(kind, message.OncotreeCode, e)
+		if cached, hit := c.lru.get(key); hit && len(dataVersion) > 0 && cached.dataVersion == dataVersion {
+			for i, f := range getOncoKBEventFields(e) {
+				*f = cached.fields[i]
+			}
+			message.OncokbDataVersion = cached.dataVersion
+			continue
+		}
+		misses = append(misses, e)
+		missKeys = append(missKeys, key)
+	}
+	if len(misses) == 0 {
+		return nil
+	}
+	toAnnotate := &tt.TempoMessage{
+		CmoSampleId:       message.CmoSampleId,
+		NormalCmoSampleId: message.NormalCmoSampleId,
+		PipelineVersion:   message.PipelineVersion,
+		OncotreeCode:      message.OncotreeCode,
+		Events:            misses,
+	}
+	if err := AnnotationTypes[kind](c.next, ctx, toAnnotate); err != nil {
+		return err
+	}
+	c.dataVersion.Store(toAnnotate.OncokbDataVersion)
+	if len(misses) < len(message.Events) && toAnnotate.OncokbDataVersion != dataVersion {
+		// OncoKB moved on to a new data version, the cached events are now from the old one, and the events are
+		// annotated again from scratch so nothing of either version is left behind
+		for _, e := range message.Events {
+			clearOncoKBEventFields(e)
+		}
+		return c.annotate(ctx, message, kind)
+	}
+	message.OncokbDataVersion = toAnnotate.OncokbDataVersion
+	for i, e := range misses {
+		if !strings.EqualFold(e.OncokbAnnotated, "true") {
+			continue
+		}
+		var fields []string
+		for _, f := range getOncoKBEventFields(e) {
+			fields = append(fields, *f)
+		}
+		c.lru.put(missKeys[i], cachedEvent{dataVersion: toAnnotate.OncokbDataVersion, fields: fields})
+	}
+	return nil
+}
+
+// getEventKey identifies an event by everything that goes into its OncoKB request.
+func getEventKey(kind, oncotreeCode string, e *tt.Event) string {
+	return strings.Join([]string{kind, strings.ToUpper(oncotreeCode), strings.ToUpper(e.HugoSymbol), e.EntrezGeneId,
+		e.HgvspShort, strings.ToLower(e.VariantClassification), e.NcbiBuild, e.StartPosition, e.EndPosition}, "|")
+}
+
+// getOncoKBEventFields returns every event field an Annotator fills in.
+func getOncoKBEventFields(e *tt.Event) []*string {
+	return []*string{
+		&e.OncokbAnnotated,
+		&e.OncokbKnownGene,
+		&e.OncokbKnownVariant,
+		&e.OncokbMutationEffect,
+		&e.OncokbMutationEffectCitations,
+		&e.OncokbOncogenic,
+		&e.OncokbLevel1,
+		&e.OncokbLevel2,
+		&e.OncokbLevel3A,
+		&e.OncokbLevel3B,
+		&e.OncokbLevel4,
+		&e.OncokbLevelR1,
+		&e.OncokbLevelR2,
+		&e.OncokbHighestLevel,
+		&e.OncokbHighestSensitivityLevel,
+		&e.OncokbHighestResistanceLevel,
+		&e.OncokbTxCitations,
+		&e.OncokbLevelDx1,
+		&e.OncokbLevelDx2,
+		&e.OncokbLevelDx3,
+		&e.OncokbHighestDxLevel,
+		&e.OncokbDxCitations,
+		&e.OncokbLevelPx1,
+		&e.OncokbLevelPx2,
+		&e.OncokbLevelPx3,
+		&e.OncokbHighestPxLevel,
+		&e.OncokbPxCitations,
+	}
+}
+
+// clearOncoKBEventFields removes every annotation of e, so it can be annotated again.
+func clearOncoKBEventFields(e *tt.Event) {
+	for _, f := range getOncoKBEventFields(e) {
+		*f = ""
+	}
+}
+
+// AnnotatorMetrics counts the calls made through an instrumented Annotator.  It is safe for concurrent use.
+type AnnotatorMetrics struct {
+	Calls    atomic.Uint64
+	Errors   atomic.Uint64
+	Events   atomic.Uint64
+	Duration atomic.Int64 // total nanoseconds spent annotating
+}
+
+type instrumentedAnnotator struct {
+	next    Annotator
+	metrics *AnnotatorMetrics
+}
+
+// NewInstrumentedAnnotator records the calls, errors, events and time spent in next into metrics.
+func NewInstrumentedAnnotator(next Annotator, metrics *AnnotatorMetrics) Annotator {
+	return &instrumentedAnnotator{next: next, metrics: metrics}
+}
+
+func (i *instrumentedAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return i.measure(ctx, message, Annotator.AnnotateMutations)
+}
+
+func (i *instrumentedAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return i.measure(ctx, message, Annotator.AnnotateCopyNumberAlterations)
+}
+
+func (i *instrumentedAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return i.measure(ctx, message, Annotator.AnnotateStructuralVariants)
+}
+
+func (i *instrumentedAnnotator) CheckReady(ctx context.Context) error {
+	return CheckReady(ctx, i.next)
+}
+
+func (i *instrumentedAnnotator) measure(ctx context.Context, message *tt.TempoMessage, annotate AnnotateFunc) error {
+	start := time.Now()
+	err := annotate(i.next, ctx, message)
+	i.metrics.Duration.Add(int64(time.Since(start)))
+	i.metrics.Calls.Add(1)
+	i.metrics.Events.Add(uint64(len(message.Events)))
+	if err != nil {
+		i.metrics.Errors.Add(1)
+	}
+	return err
+}
+
+type loggingAnnotator struct {
+	next   Annotator
+	logger *slog.Logger
+}
+
+// NewLoggingAnnotator logs every call to next, with its sample, event count, duration and error.
+func NewLoggingAnnotator(next Annotator, logger *slog.Logger) Annotator {
+	return &loggingAnnotator{next: next, logger: logger}
+}
+
+func (l *loggingAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return l.log(ctx, message, "mutations", Annotator.AnnotateMutations)
+}
+
+func (l *loggingAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return l.log(ctx, message, "cna", Annotator.AnnotateCopyNumberAlterations)
+}
+
+func (l *loggingAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return l.log(ctx, message, "sv", Annotator.AnnotateStructuralVariants)
+}
+
+func (l *loggingAnnotator) log(ctx context.Context, message *tt.TempoMessage, kind string, annotate AnnotateFunc) error {
+	ctx, id := ensureCorrelationID(ctx)
+	start := time.Now()
+	err := annotate(l.next, ctx, message)
+	attrs := []any{
+		slog.String("correlation_id", id),
+		slog.String("kind", kind),
+		slog.String("sample_id", message.CmoSampleId),
+		slog.Int("event_count", len(message.Events)),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		l.logger.ErrorContext(ctx, "annotation failed", append(attrs, slog.String("error", err.Error()))...)
+		return err
+	}
+	l.logger.InfoContext(ctx, "annotated", append(attrs, slog.String("oncokb_data_version", message.OncokbDataVersion))...)
+	return nil
+}
@@ -0,0 +1,140 @@
+package tempo_databricks_gateway
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnnotationCache stores OncoKB responses by normalized query and OncoKB data version.
+// Implementations must be safe for concurrent use.
+type AnnotationCache interface {
+	Get(dataVersion, key string) (OncoKBResponse, bool)
+	Put(dataVersion, key string, resp OncoKBResponse)
+}
+
+// CacheStats counts the lookups made against a cache.
+type CacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// getQueryKey normalizes the fields of a query that OncoKB uses to compute a response.
+// The query ID is left out since it only correlates a response to an event.
+func getQueryKey(q Query) string {
+	return fmt.Sprintf("%s|%d|%s|%s|%s|%s|%s|%d|%d|%s",
+		strings.ToUpper(q.HugoSymbol),
+		q.EntrezGeneID,
+		q.Alteration,
+		strings.ToUpper(q.AlterationType),
+		strings.ToLower(q.Consequence),
+		strings.ToUpper(q.TumorType),
+		strings.ToUpper(q.ReferenceGenome),
+		q.ProteinStart,
+		q.ProteinEnd,
+		strings.ToUpper(q.SvType))
+}
+
+// LRUCache is an in-memory AnnotationCache bounded by size, whose entries expire after a ttl.
+type LRUCache struct {
+	lru *lruCache[OncoKBResponse]
+}
+
+// NewLRUCache creates an LRUCache holding at most size responses.  A ttl of zero never expires responses.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{lru: newLRUCache[OncoKBResponse](size, ttl)}
+}
+
+func (c *LRUCache) Get(dataVersion, key string) (OncoKBResponse, bool) {
+	return c.lru.get(dataVersion + "|" + key)
+}
+
+func (c *LRUCache) Put(dataVersion, key string, resp OncoKBResponse) {
+	c.lru.put(dataVersion+"|"+key, resp)
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	return c.lru.stats()
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// lruCache is a size bounded, least recently used cache whose entries expire after ttl.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	counts  CacheStats
+	now     func() time.Time
+}
+
+func newLRUCache[V any](size int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var value V
+	el, exists := c.entries[key]
+	if !exists {
+		c.counts.Misses++
+		return value, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	if c.ttl > 0 && c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		c.counts.Expirations++
+		c.counts.Misses++
+		return value, false
+	}
+	c.order.MoveToFront(el)
+	c.counts.Hits++
+	return entry.value, true
+}
+
+func (c *lruCache[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	if el, exists := c.entries[key]; exists {
+		entry := el.Value.(*lruEntry[V])
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+		c.counts.Evictions++
+	}
+}
+
+func (c *lruCache[V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts
+}
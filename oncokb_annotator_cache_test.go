@@ -0,0 +1,127 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := NewLRUCache(2, time.Minute)
+	now := time.Now()
+	c.lru.now = func() time.Time { return now }
+
+	c.Put("v4.22", "KRAS|G12D", OncoKBResponse{Oncogenic: "Oncogenic"})
+	c.Put("v4.22", "BRAF|V600E", OncoKBResponse{Oncogenic: "Oncogenic"})
+	if _, hit := c.Get("v4.23", "KRAS|G12D"); hit {
+		t.Errorf("expected a miss for a different data version")
+	}
+	if _, hit := c.Get("v4.22", "KRAS|G12D"); !hit {
+		t.Errorf("expected a hit for KRAS|G12D")
+	}
+	// BRAF is now the least recently used
+	c.Put("v4.22", "TP53|R273H", OncoKBResponse{Oncogenic: "Oncogenic"})
+	if _, hit := c.Get("v4.22", "BRAF|V600E"); hit {
+		t.Errorf("expected BRAF|V600E to be evicted")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, hit := c.Get("v4.22", "TP53|R273H"); hit {
+		t.Errorf("expected TP53|R273H to be expired")
+	}
+
+	expected := CacheStats{Hits: 1, Misses: 3, Evictions: 1, Expirations: 1}
+	if stats := c.Stats(); stats != expected {
+		t.Errorf("expected %+v but got %+v", expected, stats)
+	}
+}
+
+func TestAnnotateMutationsWithCache(t *testing.T) {
+	var calls atomic.Int32
+	server := newVersionServer(t, func() string {
+		calls.Add(1)
+		return "v4.22"
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	cache := NewLRUCache(100, 0)
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithCache(cache))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		tm := newVersionTestMessage()
+		if err := o.AnnotateMutations(ctx, tm); err != nil {
+			t.Fatalf("Failed to annotate message: %v", err)
+		}
+		if tm.OncokbDataVersion != "v4.22" || tm.Events[1].OncokbAnnotated != "true" {
+			t.Errorf("message %d was not annotated from the cache", i)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call to OncoKB but got %d", calls.Load())
+	}
+	if stats := cache.Stats(); stats.Hits != 4 {
+		t.Errorf("expected 4 cache hits but got %+v", stats)
+	}
+}
+
+func TestCachedDataVersionRevalidation(t *testing.T) {
+	var version atomic.Value
+	version.Store("v4.22")
+	var infoCalls, annotateCalls atomic.Int32
+	annotate := newVersionServer(t, func() string {
+		annotateCalls.Add(1)
+		return version.Load().(string)
+	})
+	defer annotate.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/info", func(w http.ResponseWriter, r *http.Request) {
+		infoCalls.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"dataVersion": map[string]string{"version": version.Load().(string)}})
+	})
+	mux.Handle("/api/v1/annotate/", annotate.Config.Handler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	cache := NewLRUCache(100, 0)
+	// a warm cache from an earlier run is used once OncoKB confirms its data version
+	for _, e := range newVersionTestMessage().Events {
+		req, _ := getOncoKBRequests(true, nil, &tt.TempoMessage{OncotreeCode: "IDC", Events: []*tt.Event{e}})
+		cache.Put("v4.22", getQueryKey(req[0].query()), OncoKBResponse{DataVersion: "v4.22", LastUpdate: "01/01/2025", Oncogenic: "Oncogenic"})
+	}
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/api/v1/annotate/mutations/byProteinChange",
+		WithCache(cache), WithVersionSkewPolicy(VersionSkewReannotate))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	now := time.Now()
+	o.dataVersion.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if err := o.AnnotateMutations(ctx, newVersionTestMessage()); err != nil {
+			t.Fatalf("Failed to annotate message: %v", err)
+		}
+	}
+	if infoCalls.Load() != 1 || annotateCalls.Load() != 0 {
+		t.Errorf("expected 1 info call and no annotate calls but got %d and %d", infoCalls.Load(), annotateCalls.Load())
+	}
+
+	// once the pin expires a new OncoKB release is noticed even though every query is a cache hit
+	version.Store("v4.23")
+	now = now.Add(defaultDataVersionTTL)
+	tm := newVersionTestMessage()
+	if err := o.AnnotateMutations(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+	if tm.OncokbDataVersion != "v4.23" || infoCalls.Load() != 2 || annotateCalls.Load() != 1 {
+		t.Errorf("expected the message to be annotated by OncoKB with v4.23 but got %q after %d info and %d annotate calls",
+			tm.OncokbDataVersion, infoCalls.Load(), annotateCalls.Load())
+	}
+}
@@ -0,0 +1,129 @@
+package tempo_databricks_gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CitationSource is implemented by every section of an OncoKB response that carries pmids and abstracts.
+type CitationSource interface {
+	CitationPmids() []string
+	CitationAbstracts() []Abstracts
+}
+
+func (c Citations) CitationPmids() []string                     { return c.Pmids }
+func (c Citations) CitationAbstracts() []Abstracts              { return c.Abstracts }
+func (t Treatments) CitationPmids() []string                    { return t.Pmids }
+func (t Treatments) CitationAbstracts() []Abstracts             { return t.Abstracts }
+func (d DiagnosticImplications) CitationPmids() []string        { return d.Pmids }
+func (d DiagnosticImplications) CitationAbstracts() []Abstracts { return d.Abstracts }
+func (p PrognosticImplications) CitationPmids() []string        { return p.Pmids }
+func (p PrognosticImplications) CitationAbstracts() []Abstracts { return p.Abstracts }
+
+// Citation is a single pmid or abstract, exactly one of the fields is set.
+type Citation struct {
+	Pmid     string     `json:"pmid,omitempty"`
+	Abstract *Abstracts `json:"abstract,omitempty"`
+}
+
+// CitationFormatter renders the citations of one section (mutation effect, Tx, Dx or Px) into an event field.
+// Citations are passed in the order OncoKB returned them, pmids of an item before its abstracts.
+type CitationFormatter interface {
+	FormatCitations(citations []Citation) string
+}
+
+// PythonCitationFormatter produces "pmid;pmid;abstract(link)", the format of MafAnnotator.py.  This is the default.
+type PythonCitationFormatter struct{}
+
+func (PythonCitationFormatter) FormatCitations(citations []Citation) string {
+	formatted := make([]string, 0, len(citations))
+	for _, c := range citations {
+		if c.Abstract != nil {
+			formatted = append(formatted, fmt.Sprintf("%s(%s)", c.Abstract.Abstract, c.Abstract.Link))
+		} else {
+			formatted = append(formatted, c.Pmid)
+		}
+	}
+	return strings.Join(formatted, ";")
+}
+
+// PmidCitationFormatter produces "pmid;pmid" and drops abstracts.
+type PmidCitationFormatter struct{}
+
+func (PmidCitationFormatter) FormatCitations(citations []Citation) string {
+	pmids := make([]string, 0, len(citations))
+	for _, c := range citations {
+		if c.Abstract == nil {
+			pmids = append(pmids, c.Pmid)
+		}
+	}
+	return strings.Join(pmids, ";")
+}
+
+// JSONCitationFormatter produces a JSON array of Citation, or an empty string when there are no citations.
+type JSONCitationFormatter struct{}
+
+func (JSONCitationFormatter) FormatCitations(citations []Citation) string {
+	if len(citations) == 0 {
+		return ""
+	}
+	jsonData, err := json.Marshal(citations)
+	if err != nil {
+		// Citation only holds strings, this cannot happen
+		return ""
+	}
+	return string(jsonData)
+}
+
+// VancouverCitationFormatter produces a numbered, Vancouver style reference list:
+// "1. PubMed PMID: 15649950. 2. Dhawan et al. Abstract# 2527, ASCO 2017. Available from: https://..."
+type VancouverCitationFormatter struct{}
+
+func (VancouverCitationFormatter) FormatCitations(citations []Citation) string {
+	formatted := make([]string, 0, len(citations))
+	for i, c := range citations {
+		if c.Abstract != nil {
+			abstract := strings.TrimSuffix(strings.TrimSpace(c.Abstract.Abstract), ".")
+			formatted = append(formatted, fmt.Sprintf("%d. %s. Available from: %s", i+1, abstract, c.Abstract.Link))
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%d. PubMed PMID: %s.", i+1, c.Pmid))
+		}
+	}
+	return strings.Join(formatted, " ")
+}
+
+// citationSet tracks the citations already written so each is only written once.
+// Sharing one set across sections deduplicates across them.
+type citationSet struct {
+	pmids     map[string]bool
+	abstracts map[Abstracts]bool
+}
+
+func newCitationSet() *citationSet {
+	return &citationSet{pmids: make(map[string]bool), abstracts: make(map[Abstracts]bool)}
+}
+
+// getCitations formats the citations of items, skipping any already in seen.  A nil seen deduplicates within items only.
+func getCitations[T CitationSource](formatter CitationFormatter, seen *citationSet, items []T) string {
+	if seen == nil {
+		seen = newCitationSet()
+	}
+	var citations []Citation
+	for _, item := range items {
+		for _, pmid := range item.CitationPmids() {
+			if !seen.pmids[pmid] {
+				citations = append(citations, Citation{Pmid: pmid})
+				seen.pmids[pmid] = true
+			}
+		}
+		for _, a := range item.CitationAbstracts() {
+			if !seen.abstracts[a] {
+				abstract := a
+				citations = append(citations, Citation{Abstract: &abstract})
+				seen.abstracts[a] = true
+			}
+		}
+	}
+	return formatter.FormatCitations(citations)
+}
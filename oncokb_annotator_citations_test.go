@@ -0,0 +1,46 @@
+package tempo_databricks_gateway
+
+import (
+	"testing"
+)
+
+var testTreatments = []Treatments{
+	Treatments{
+		Pmids:     []string{"30110579", "36394867"},
+		Abstracts: []Abstracts{Abstracts{Abstract: "Dhawan et al. Abstract# 2527, ASCO 2017.", Link: "https://ascopubs.org/doi/abs/10.1200/JCO.2017.35.15_suppl.2527"}},
+	},
+	Treatments{
+		Pmids: []string{"36394867", "28578601"},
+	},
+}
+
+func TestGetCitations(t *testing.T) {
+	tests := []struct {
+		formatter CitationFormatter
+		expected  string
+	}{
+		{PythonCitationFormatter{}, "30110579;36394867;Dhawan et al. Abstract# 2527, ASCO 2017.(https://ascopubs.org/doi/abs/10.1200/JCO.2017.35.15_suppl.2527);28578601"},
+		{PmidCitationFormatter{}, "30110579;36394867;28578601"},
+		{JSONCitationFormatter{}, `[{"pmid":"30110579"},{"pmid":"36394867"},{"abstract":{"abstract":"Dhawan et al. Abstract# 2527, ASCO 2017.","link":"https://ascopubs.org/doi/abs/10.1200/JCO.2017.35.15_suppl.2527"}},{"pmid":"28578601"}]`},
+		{VancouverCitationFormatter{}, "1. PubMed PMID: 30110579. 2. PubMed PMID: 36394867. 3. Dhawan et al. Abstract# 2527, ASCO 2017. Available from: https://ascopubs.org/doi/abs/10.1200/JCO.2017.35.15_suppl.2527 4. PubMed PMID: 28578601."},
+	}
+	for _, test := range tests {
+		if got := getCitations(test.formatter, nil, testTreatments); got != test.expected {
+			t.Errorf("%T: expected %q but got %q", test.formatter, test.expected, got)
+		}
+	}
+}
+
+func TestGetCitationsDedupAcrossSections(t *testing.T) {
+	dx := []DiagnosticImplications{DiagnosticImplications{Pmids: []string{"28578601", "25873496"}}}
+
+	if got := getCitations(PythonCitationFormatter{}, nil, dx); got != "28578601;25873496" {
+		t.Errorf("expected %q but got %q", "28578601;25873496", got)
+	}
+
+	seen := newCitationSet()
+	getCitations(PythonCitationFormatter{}, seen, testTreatments)
+	if got := getCitations(PythonCitationFormatter{}, seen, dx); got != "25873496" {
+		t.Errorf("expected %q but got %q", "25873496", got)
+	}
+}
@@ -0,0 +1,152 @@
+package tempo_databricks_gateway
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OncoKBClinicalColumns are the sample-level columns ClinicalDataAnnotator.py adds to a cBioPortal clinical sample file.
+var OncoKBClinicalColumns = []string{
+	"HIGHEST_LEVEL",
+	"HIGHEST_SENSITIVE_LEVEL",
+	"HIGHEST_RESISTANCE_LEVEL",
+	"HIGHEST_DX_LEVEL",
+	"HIGHEST_PX_LEVEL",
+	"ONCOGENIC_MUTATIONS",
+	"#ONCOGENIC_MUTATIONS",
+	"RESISTANCE_MUTATIONS",
+	"#RESISTANCE_MUTATIONS",
+}
+
+// clinicalColumnMetadata are the four cBioPortal header lines (display name, description, datatype, priority)
+// of each of the OncoKBClinicalColumns.
+var clinicalColumnMetadata = map[string][4]string{
+	"HIGHEST_LEVEL":            {"Highest Level", "Highest OncoKB therapeutic level of the sample", "STRING", "1"},
+	"HIGHEST_SENSITIVE_LEVEL":  {"Highest Sensitive Level", "Highest OncoKB sensitive level of the sample", "STRING", "1"},
+	"HIGHEST_RESISTANCE_LEVEL": {"Highest Resistance Level", "Highest OncoKB resistance level of the sample", "STRING", "1"},
+	"HIGHEST_DX_LEVEL":         {"Highest Dx Level", "Highest OncoKB diagnostic level of the sample", "STRING", "1"},
+	"HIGHEST_PX_LEVEL":         {"Highest Px Level", "Highest OncoKB prognostic level of the sample", "STRING", "1"},
+	"ONCOGENIC_MUTATIONS":      {"Oncogenic Mutations", "Oncogenic and likely oncogenic mutations of the sample", "STRING", "1"},
+	"#ONCOGENIC_MUTATIONS":     {"# Oncogenic Mutations", "Number of oncogenic and likely oncogenic mutations", "NUMBER", "1"},
+	"RESISTANCE_MUTATIONS":     {"Resistance Mutations", "Mutations with an OncoKB resistance level", "STRING", "1"},
+	"#RESISTANCE_MUTATIONS":    {"# Resistance Mutations", "Number of mutations with an OncoKB resistance level", "NUMBER", "1"},
+}
+
+// GetOncoKBClinicalValues returns the sample-level values of a summary in the order of OncoKBClinicalColumns.
+func GetOncoKBClinicalValues(s SampleSummary) []string {
+	return []string{
+		s.HighestLevel,
+		s.HighestSensitiveLevel,
+		s.HighestResistanceLevel,
+		s.HighestDxLevel,
+		s.HighestPxLevel,
+		strings.Join(s.OncogenicMutations, ","),
+		strconv.Itoa(len(s.OncogenicMutations)),
+		strings.Join(s.ResistanceMutations, ","),
+		strconv.Itoa(len(s.ResistanceMutations)),
+	}
+}
+
+// AnnotateClinicalSamples copies a cBioPortal clinical sample file from in to out with the OncoKBClinicalColumns
+// of each sample filled in from summaries, which are keyed by sample ID.  Columns are resolved by the header names,
+// OncoKB columns the input already had are overwritten, and the cBioPortal # header lines are extended for the
+// appended columns.  Samples without a summary get empty levels and zero counts.
+func AnnotateClinicalSamples(in io.Reader, out io.Writer, summaries map[string]SampleSummary) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMAFLineSize)
+	w := bufio.NewWriter(out)
+
+	var comments [][]string
+	var header []string
+	var columns []int
+	width := 0
+	sampleColumn := -1
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(strings.TrimSuffix(scanner.Text(), "\r"), "\t")
+		switch {
+		case header == nil && strings.HasPrefix(fields[0], "#"):
+			comments = append(comments, fields)
+			continue
+		case header == nil:
+			header = fields
+			for i, c := range header {
+				if c == "SAMPLE_ID" {
+					sampleColumn = i
+				}
+			}
+			if sampleColumn < 0 {
+				return fmt.Errorf("Error reading clinical sample file: missing required column %q", "SAMPLE_ID")
+			}
+			var out []string
+			out, columns = getOutputColumns(header, OncoKBClinicalColumns)
+			width = len(out)
+			for i, comment := range comments {
+				w.WriteString(strings.Join(getClinicalComment(comment, header, out, i), "\t") + "\n")
+			}
+			w.WriteString(strings.Join(out, "\t") + "\n")
+			continue
+		case len(fields) == 1 && fields[0] == "":
+			continue
+		}
+		if len(fields) > len(header) {
+			return fmt.Errorf("Error reading clinical sample file line %d: %d fields but the header has %d", line, len(fields), len(header))
+		}
+		row := make([]string, width)
+		copy(row, fields)
+		var summary SampleSummary
+		if sampleColumn < len(fields) {
+			summary = summaries[fields[sampleColumn]]
+		}
+		for i, v := range GetOncoKBClinicalValues(summary) {
+			row[columns[i]] = v
+		}
+		w.WriteString(strings.Join(row, "\t") + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading clinical sample file: %v", err)
+	}
+	if header == nil {
+		return fmt.Errorf("Error reading clinical sample file: missing header")
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Error writing clinical sample file: %v", err)
+	}
+	return nil
+}
+
+// getOutputColumns returns the output header, header followed by the added columns it does not already have,
+// and the index of each added column in it.
+func getOutputColumns(header, added []string) ([]string, []int) {
+	existing := make(map[string]int, len(header))
+	for i, c := range header {
+		existing[c] = i
+	}
+	out := append([]string(nil), header...)
+	var columns []int
+	for _, c := range added {
+		if i, exists := existing[c]; exists {
+			columns = append(columns, i)
+			continue
+		}
+		columns = append(columns, len(out))
+		out = append(out, c)
+	}
+	return out, columns
+}
+
+// getClinicalComment extends the n-th cBioPortal header line with the metadata of the appended columns.
+// Comment lines that do not describe each column are left alone.
+func getClinicalComment(comment, header, out []string, n int) []string {
+	if len(comment) != len(header) || n >= 4 {
+		return comment
+	}
+	for _, c := range out[len(header):] {
+		comment = append(comment, clinicalColumnMetadata[c][n])
+	}
+	return comment
+}
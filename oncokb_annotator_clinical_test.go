@@ -0,0 +1,69 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAnnotateClinicalSamples(t *testing.T) {
+	clinical := "#Sample Identifier\tOncotree Code\tHighest Level\n" +
+		"#Sample Identifier\tOncotree Code\tHighest Level\n" +
+		"#STRING\tSTRING\tSTRING\n" +
+		"#1\t1\t1\n" +
+		"SAMPLE_ID\tONCOTREE_CODE\tHIGHEST_LEVEL\n" +
+		"S1\tIDC\tstale\n" +
+		"S2\tLUAD\n"
+	summaries := map[string]SampleSummary{
+		"S1": {
+			SampleID:               "S1",
+			HighestLevel:           "LEVEL_R1",
+			HighestSensitiveLevel:  "LEVEL_3A",
+			HighestResistanceLevel: "LEVEL_R1",
+			OncogenicMutations:     []string{"BRCA2_H52Qfs*16", "TP53_R248Q"},
+			ResistanceMutations:    []string{"BRCA2_H52Qfs*16"},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := AnnotateClinicalSamples(strings.NewReader(clinical), &out, summaries); err != nil {
+		t.Fatalf("Failed to annotate clinical samples: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected 7 lines but got %q", lines)
+	}
+	header := strings.Split(lines[4], "\t")
+	if len(header) != 2+len(OncoKBClinicalColumns) || header[2] != "HIGHEST_LEVEL" {
+		t.Fatalf("unexpected header %q", header)
+	}
+	for i := 0; i < 4; i++ {
+		if n := len(strings.Split(lines[i], "\t")); n != len(header) {
+			t.Errorf("expected cBioPortal header line %d to describe %d columns but got %d", i+1, len(header), n)
+		}
+	}
+	if !strings.HasSuffix(lines[2], "\tNUMBER\tSTRING\tNUMBER") {
+		t.Errorf("expected the count columns to be numbers but got %q", lines[2])
+	}
+
+	values := make(map[string]string)
+	for i, v := range strings.Split(lines[5], "\t") {
+		values[header[i]] = v
+	}
+	expected := map[string]string{
+		"SAMPLE_ID":             "S1",
+		"HIGHEST_LEVEL":         "LEVEL_R1",
+		"HIGHEST_DX_LEVEL":      "",
+		"ONCOGENIC_MUTATIONS":   "BRCA2_H52Qfs*16,TP53_R248Q",
+		"#ONCOGENIC_MUTATIONS":  "2",
+		"#RESISTANCE_MUTATIONS": "1",
+	}
+	for column, want := range expected {
+		if values[column] != want {
+			t.Errorf("column %q: expected %q but got %q", column, want, values[column])
+		}
+	}
+	if !strings.HasPrefix(lines[6], "S2\tLUAD\t\t") || !strings.Contains(lines[6], "\t0\t") {
+		t.Errorf("expected a sample without a summary to have empty levels and zero counts but got %q", lines[6])
+	}
+}
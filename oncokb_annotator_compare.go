@@ -0,0 +1,188 @@
+package tempo_databricks_gateway
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MismatchCategory classifies a difference between a reference OncoKB column, like the output of MafAnnotator.py,
+// and ours.
+type MismatchCategory string
+
+const (
+	// MismatchOrdering is the same list of values in a different order.
+	MismatchOrdering MismatchCategory = "ordering"
+	// MismatchCitationFormat is the same PMIDs with abstracts or separators written differently.
+	MismatchCitationFormat MismatchCategory = "citation format"
+	// MismatchLevel is a disagreement on an OncoKB level column.
+	MismatchLevel MismatchCategory = "level disagreement"
+	// MismatchNotAnnotated is a record the reference annotated but we did not.
+	MismatchNotAnnotated MismatchCategory = "not annotated"
+	// MismatchValue is any other difference.
+	MismatchValue MismatchCategory = "value"
+)
+
+var mismatchCategories = []MismatchCategory{
+	MismatchOrdering,
+	MismatchCitationFormat,
+	MismatchLevel,
+	MismatchNotAnnotated,
+	MismatchValue,
+}
+
+var pmidRegex = regexp.MustCompile(`^\d+$`)
+
+// maxMismatchExamples bounds the examples a ConcordanceReport keeps per column and category.
+const maxMismatchExamples = 5
+
+// Mismatch is one differing OncoKB column of a record.
+type Mismatch struct {
+	SampleID  string           `json:"sampleId"`
+	Gene      string           `json:"gene"`
+	Variant   string           `json:"variant"`
+	Column    string           `json:"column"`
+	Expected  string           `json:"expected"`
+	Got       string           `json:"got"`
+	Category  MismatchCategory `json:"category"`
+	RecordNum int              `json:"record"`
+}
+
+// ColumnConcordance counts the agreement of one OncoKB column.
+type ColumnConcordance struct {
+	Column     string                   `json:"column"`
+	Matches    int                      `json:"matches"`
+	Mismatches map[MismatchCategory]int `json:"mismatches"`
+	Examples   []Mismatch               `json:"examples,omitempty"`
+}
+
+// ConcordanceReport compares the OncoKB columns of reference records to our annotations of the same records.
+type ConcordanceReport struct {
+	Records           int                 `json:"records"`
+	DiscordantRecords int                 `json:"discordantRecords"`
+	Columns           []ColumnConcordance `json:"columns"`
+}
+
+// NewConcordanceReport returns an empty report over OncoKBMAFColumns.
+func NewConcordanceReport() *ConcordanceReport {
+	r := &ConcordanceReport{}
+	for _, c := range OncoKBMAFColumns {
+		r.Columns = append(r.Columns, ColumnConcordance{Column: c, Mismatches: make(map[MismatchCategory]int)})
+	}
+	return r
+}
+
+// Add compares a reference record to our annotation of it, both in the order of OncoKBMAFColumns.
+func (r *ConcordanceReport) Add(record *MAFRecord, expected, got []string) []Mismatch {
+	r.Records++
+	var mismatches []Mismatch
+	notAnnotated := strings.EqualFold(expected[0], "true") && !strings.EqualFold(got[0], "true")
+	for i := range r.Columns {
+		c := &r.Columns[i]
+		category, equal := CompareOncoKBColumn(c.Column, expected[i], got[i])
+		if equal {
+			c.Matches++
+			continue
+		}
+		if notAnnotated {
+			category = MismatchNotAnnotated
+		}
+		m := Mismatch{
+			SampleID:  record.SampleID,
+			Gene:      record.Event.HugoSymbol,
+			Variant:   record.Event.HgvspShort,
+			Column:    c.Column,
+			Expected:  expected[i],
+			Got:       got[i],
+			Category:  category,
+			RecordNum: r.Records,
+		}
+		c.Mismatches[category]++
+		if c.Mismatches[category] <= maxMismatchExamples {
+			c.Examples = append(c.Examples, m)
+		}
+		mismatches = append(mismatches, m)
+	}
+	if len(mismatches) > 0 {
+		r.DiscordantRecords++
+	}
+	return mismatches
+}
+
+// Concordance returns the fraction of records whose OncoKB columns all agree.
+func (r *ConcordanceReport) Concordance() float64 {
+	if r.Records == 0 {
+		return 1
+	}
+	return float64(r.Records-r.DiscordantRecords) / float64(r.Records)
+}
+
+// Write writes the report as text, one line per column followed by examples of each kind of mismatch.
+func (r *ConcordanceReport) Write(w io.Writer) error {
+	fmt.Fprintf(w, "records: %d\nconcordant records: %d (%.2f%%)\n\n", r.Records, r.Records-r.DiscordantRecords, 100*r.Concordance())
+	fmt.Fprintf(w, "%-26s %8s", "column", "matches")
+	for _, category := range mismatchCategories {
+		fmt.Fprintf(w, " %18s", category)
+	}
+	fmt.Fprintln(w)
+	for _, c := range r.Columns {
+		fmt.Fprintf(w, "%-26s %8d", c.Column, c.Matches)
+		for _, category := range mismatchCategories {
+			fmt.Fprintf(w, " %18d", c.Mismatches[category])
+		}
+		fmt.Fprintln(w)
+	}
+	for _, c := range r.Columns {
+		for _, m := range c.Examples {
+			fmt.Fprintf(w, "\n%s record %d %s %s %s (%s)\n  expected: %q\n       got: %q\n",
+				m.Column, m.RecordNum, m.SampleID, m.Gene, m.Variant, m.Category, m.Expected, m.Got)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// CompareOncoKBColumn compares a reference value of an OncoKB MAF column to ours, returning whether they agree and
+// if not, how they differ.  The boolean columns are compared ignoring case, like TestAnnotateMutations does.
+func CompareOncoKBColumn(column, expected, got string) (MismatchCategory, bool) {
+	if expected == got || (slices.Index(OncoKBMAFColumns, column) < 3 && strings.EqualFold(expected, got)) {
+		return "", true
+	}
+	if isSameList(expected, got) {
+		return MismatchOrdering, false
+	}
+	if strings.HasSuffix(column, "_CITATIONS") && len(getPmids(expected)) > 0 &&
+		slices.Equal(getPmids(expected), getPmids(got)) {
+		return MismatchCitationFormat, false
+	}
+	if strings.HasPrefix(column, "LEVEL_") || strings.HasPrefix(column, "HIGHEST_") {
+		return MismatchLevel, false
+	}
+	return MismatchValue, false
+}
+
+// isSameList reports whether two , or ; separated lists have the same items.
+func isSameList(a, b string) bool {
+	split := func(s string) []string {
+		items := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' })
+		sort.Strings(items)
+		return items
+	}
+	itemsA, itemsB := split(a), split(b)
+	return len(itemsA) > 1 && slices.Equal(itemsA, itemsB)
+}
+
+// getPmids returns the sorted PMIDs of a citations column, ignoring the abstracts.
+func getPmids(citations string) []string {
+	var pmids []string
+	for _, item := range strings.FieldsFunc(citations, func(r rune) bool { return r == ',' || r == ';' }) {
+		if item = strings.TrimSpace(item); pmidRegex.MatchString(item) {
+			pmids = append(pmids, item)
+		}
+	}
+	sort.Strings(pmids)
+	return pmids
+}
@@ -0,0 +1,70 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestCompareOncoKBColumn(t *testing.T) {
+	tests := []struct {
+		column   string
+		expected string
+		got      string
+		category MismatchCategory
+		equal    bool
+	}{
+		{"ANNOTATED", "True", "true", "", true},
+		{"ONCOGENIC", "Oncogenic", "Oncogenic", "", true},
+		{"ONCOGENIC", "Oncogenic", "oncogenic", MismatchValue, false},
+		{"LEVEL_3A", "Olaparib,Talazoparib", "Talazoparib,Olaparib", MismatchOrdering, false},
+		{"TX_CITATIONS", "1;2;Abstract A", "2;1;Abstract A", MismatchOrdering, false},
+		{"TX_CITATIONS", "1;2;Abstract A", "1;2;Abstract A (https://example.org)", MismatchCitationFormat, false},
+		{"TX_CITATIONS", "1;2", "1;3", MismatchValue, false},
+		{"HIGHEST_LEVEL", "LEVEL_3A", "LEVEL_1", MismatchLevel, false},
+		{"LEVEL_1", "Olaparib", "", MismatchLevel, false},
+	}
+	for _, test := range tests {
+		category, equal := CompareOncoKBColumn(test.column, test.expected, test.got)
+		if category != test.category || equal != test.equal {
+			t.Errorf("%s %q %q: expected (%q, %v) but got (%q, %v)",
+				test.column, test.expected, test.got, test.category, test.equal, category, equal)
+		}
+	}
+}
+
+func TestConcordanceReport(t *testing.T) {
+	report := NewConcordanceReport()
+	record := &MAFRecord{SampleID: "S1", Event: &tt.Event{HugoSymbol: "BRCA2", HgvspShort: "p.H52Qfs*16"}}
+	expected := make([]string, len(OncoKBMAFColumns))
+	expected[0] = "True"
+	got := append([]string(nil), expected...)
+	if mismatches := report.Add(record, expected, got); len(mismatches) != 0 {
+		t.Errorf("expected no mismatches but got %+v", mismatches)
+	}
+
+	got[13] = "LEVEL_1"
+	mismatches := report.Add(record, expected, got)
+	if len(mismatches) != 1 || mismatches[0].Column != "HIGHEST_LEVEL" || mismatches[0].Category != MismatchLevel {
+		t.Errorf("expected a HIGHEST_LEVEL level disagreement but got %+v", mismatches)
+	}
+
+	got[0] = "False"
+	mismatches = report.Add(record, expected, got)
+	if len(mismatches) != 2 || mismatches[0].Category != MismatchNotAnnotated {
+		t.Errorf("expected the mismatches of an unannotated record to be not annotated but got %+v", mismatches)
+	}
+
+	if report.Records != 3 || report.DiscordantRecords != 2 {
+		t.Errorf("expected 2 of 3 records to be discordant but got %d of %d", report.DiscordantRecords, report.Records)
+	}
+	var out bytes.Buffer
+	if err := report.Write(&out); err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	if !strings.Contains(out.String(), "concordant records: 1 (33.33%)") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
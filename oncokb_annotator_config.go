@@ -0,0 +1,488 @@
+package tempo_databricks_gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AnnotatorModeOnline  = "online"
+	AnnotatorModeOffline = "offline"
+
+	redactedConfigValue = "REDACTED"
+)
+
+// Config configures the annotator CLI and services.  It is layered: DefaultConfig, then a YAML file read by
+// LoadConfig, then the environment variables of the env tags applied by ApplyEnv, and then the command line flags.
+// A layer sets at most one of the token sources, oncokb.token, oncokb.token_file and oncokb.token_command, and the
+// one it sets replaces the token source of the layers below.  Fields tagged secret are redacted by Redacted.
+type Config struct {
+	OncoKB OncoKBConfig `yaml:"oncokb"`
+	Sample SampleConfig `yaml:"sample"`
+	Cache  CacheConfig  `yaml:"cache"`
+	Server ServerConfig `yaml:"server"`
+	Worker WorkerConfig `yaml:"worker"`
+	Output OutputConfig `yaml:"output"`
+	Log    LogConfig    `yaml:"log"`
+}
+
+// OncoKBConfig configures how events are annotated.
+type OncoKBConfig struct {
+	// URL is the OncoKB API base URL, like https://www.oncokb.org/api/v1, or its annotate mutations endpoint
+	URL string `yaml:"url" env:"ONCOKB_API_URL"`
+	// Mode is online to query the OncoKB API or offline to annotate from the Snapshot files
+	Mode string `yaml:"mode" env:"ONCOKB_MODE"`
+	// Snapshot lists files of recorded OncoKB responses, or allAnnotatedVariants.txt downloads, for offline mode
+	Snapshot []string `yaml:"snapshot" env:"ONCOKB_SNAPSHOT"`
+	// only one of Token, TokenFile and TokenCommand can be set, see Config.  When none is, the token is read from the
+	// ONCOKB_API_TOKEN environment variable, which is not a config key so it never conflicts with them
+	Token     string `yaml:"token" secret:"true"`
+	TokenFile string `yaml:"token_file" env:"ONCOKB_API_TOKEN_FILE"`
+	// TokenCommand is the command and its arguments, run without a shell
+	TokenCommand []string `yaml:"token_command" env:"ONCOKB_API_TOKEN_COMMAND"`
+	// BatchSize bounds the events of a request, 0 sends all the events of a message together
+	BatchSize    int     `yaml:"batch_size" env:"ONCOKB_BATCH_SIZE"`
+	Concurrency  int     `yaml:"concurrency" env:"ONCOKB_CONCURRENCY"`
+	RequestRate  float64 `yaml:"request_rate" env:"ONCOKB_REQUEST_RATE"`
+	RequestBurst int     `yaml:"request_burst" env:"ONCOKB_REQUEST_BURST"`
+	// Retries is the number of attempts at a call that fails with a network, rate limit or OncoKB server error
+	Retries      int           `yaml:"retries" env:"ONCOKB_RETRIES"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"ONCOKB_RETRY_BACKOFF"`
+	// ConsequenceOverrides maps variant classifications to OncoKB consequences, see WithConsequenceOverrides
+	ConsequenceOverrides map[string][]string `yaml:"consequence_overrides"`
+	// VersionSkew is fail or reannotate, see VersionSkewPolicy.  Empty is fail for the commands annotating files,
+	// so a run never mixes OncoKB data versions, and reannotate for the long running serve and worker commands.
+	VersionSkew string `yaml:"version_skew" env:"ONCOKB_VERSION_SKEW"`
+	// DataVersionTTL is how long cached responses are used before OncoKB is asked for its data version again
+	DataVersionTTL time.Duration `yaml:"data_version_ttl" env:"ONCOKB_DATA_VERSION_TTL"`
+}
+
+// SampleConfig holds the sample fields of the TempoMessages built from MAF and VCF files.
+type SampleConfig struct {
+	PipelineVersion string `yaml:"pipeline_version" env:"ONCOKB_PIPELINE_VERSION"`
+	// NcbiBuild is the reference genome of events that do not name one
+	NcbiBuild string `yaml:"ncbi_build" env:"ONCOKB_NCBI_BUILD"`
+	// TumorType is the oncotree code of samples missing from the clinical file
+	TumorType string `yaml:"tumor_type" env:"ONCOKB_TUMOR_TYPE"`
+}
+
+// CacheConfig configures the OncoKB response cache, on disk when Dir is set, otherwise in memory when Size is set.
+type CacheConfig struct {
+	Dir  string        `yaml:"dir" env:"ONCOKB_CACHE_DIR"`
+	Size int           `yaml:"size" env:"ONCOKB_CACHE_SIZE"`
+	TTL  time.Duration `yaml:"ttl" env:"ONCOKB_CACHE_TTL"`
+}
+
+// ServerConfig configures the serve command.
+type ServerConfig struct {
+	Addr           string  `yaml:"addr" env:"ONCOKB_SERVER_ADDR"`
+	GRPCAddr       string  `yaml:"grpc_addr" env:"ONCOKB_SERVER_GRPC_ADDR"`
+	ClientRate     float64 `yaml:"client_rate" env:"ONCOKB_SERVER_CLIENT_RATE"`
+	ClientBurst    int     `yaml:"client_burst" env:"ONCOKB_SERVER_CLIENT_BURST"`
+	MaxRequestSize int64   `yaml:"max_request_size" env:"ONCOKB_SERVER_MAX_REQUEST_SIZE"`
+}
+
+// WorkerConfig configures the worker command.
+type WorkerConfig struct {
+	// Brokers are the Kafka bootstrap brokers, like localhost:9092
+	Brokers     []string `yaml:"brokers" env:"ONCOKB_WORKER_BROKERS"`
+	InputTopic  string   `yaml:"input_topic" env:"ONCOKB_WORKER_INPUT_TOPIC"`
+	OutputTopic string   `yaml:"output_topic" env:"ONCOKB_WORKER_OUTPUT_TOPIC"`
+	// DeadLetterTopic defaults to the input topic with a .dlq suffix
+	DeadLetterTopic string `yaml:"dead_letter_topic" env:"ONCOKB_WORKER_DEAD_LETTER_TOPIC"`
+	Group           string `yaml:"group" env:"ONCOKB_WORKER_GROUP"`
+	// Format is ndjson or proto, how the TempoMessages of the topics are serialized
+	Format string `yaml:"format" env:"ONCOKB_WORKER_FORMAT"`
+	// Annotations is a comma separated list of mutations, cna and sv
+	Annotations string `yaml:"annotations" env:"ONCOKB_WORKER_ANNOTATIONS"`
+}
+
+// OutputConfig configures how annotated TempoMessages are written.
+type OutputConfig struct {
+	// Format is ndjson or proto, empty writes messages in the format they were read in
+	Format string `yaml:"format" env:"ONCOKB_OUTPUT_FORMAT"`
+}
+
+// LogConfig configures the structured log written to stderr.
+type LogConfig struct {
+	// Level is debug, info, warn or error, debug logs every OncoKB request
+	Level string `yaml:"level" env:"ONCOKB_LOG_LEVEL"`
+	// Format is text or json
+	Format string `yaml:"format" env:"ONCOKB_LOG_FORMAT"`
+}
+
+// DefaultConfig returns the configuration used when nothing is set.
+func DefaultConfig() Config {
+	return Config{
+		OncoKB: OncoKBConfig{
+			URL:            "https://www.oncokb.org/api/v1",
+			Mode:           AnnotatorModeOnline,
+			Concurrency:    1,
+			RequestBurst:   1,
+			Retries:        3,
+			RetryBackoff:   500 * time.Millisecond,
+			DataVersionTTL: defaultDataVersionTTL,
+		},
+		Sample: SampleConfig{PipelineVersion: "v1.0", NcbiBuild: "GRCh37"},
+		Server: ServerConfig{Addr: ":8080", ClientRate: 5, ClientBurst: 10, MaxRequestSize: defaultMaxRequestSize},
+		Worker: WorkerConfig{Group: "oncokb-annotator", Format: "proto", Annotations: "mutations"},
+		Log:    LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// LoadConfig reads a YAML configuration file over DefaultConfig.  Unknown keys are an error, to catch typos.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	f, err := os.Open(path)
+	if err != nil {
+		return config, fmt.Errorf("Error opening config file: %v", err)
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return config, fmt.Errorf("Error reading config file %q: %v", path, err)
+	}
+	if config.OncoKB.tokenSources() > 1 {
+		return config, fmt.Errorf("Error reading config file %q: only one of oncokb.token, oncokb.token_file and oncokb.token_command can be set", path)
+	}
+	return config, nil
+}
+
+// ApplyEnv sets the fields whose env variable lookup finds, like os.LookupEnv.  List fields are comma separated, or
+// a JSON array of strings for items that hold commas or spaces, like the arguments of oncokb.token_command.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	// the token source of the environment, if it has one, replaces the one of the config file
+	lower := c.OncoKB
+	c.OncoKB.clearTokenSources()
+	err := walkConfig(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.StructField, v reflect.Value) error {
+		name := field.Tag.Get("env")
+		if name == "" {
+			return nil
+		}
+		value, ok := lookup(name)
+		if !ok {
+			return nil
+		}
+		if err := setConfigValue(v, value); err != nil {
+			// the value is left out in case it is a secret
+			return fmt.Errorf("Error reading %s: %v", name, err)
+		}
+		return nil
+	})
+	switch sources := c.OncoKB.tokenSources(); {
+	case sources == 0:
+		c.OncoKB.Token, c.OncoKB.TokenFile, c.OncoKB.TokenCommand = lower.Token, lower.TokenFile, lower.TokenCommand
+	case sources > 1 && err == nil:
+		err = fmt.Errorf("Error: only one of ONCOKB_API_TOKEN_FILE and ONCOKB_API_TOKEN_COMMAND can be set")
+	}
+	return err
+}
+
+// Set sets the field of a YAML key, like oncokb.batch_size, parsing value as ApplyEnv does.  Setting a token source
+// replaces the token source set before.
+func (c *Config) Set(key, value string) error {
+	if tokenSourceKeys[key] {
+		c.OncoKB.clearTokenSources()
+	}
+	found := false
+	err := walkConfig(reflect.ValueOf(c).Elem(), "", func(k string, field reflect.StructField, v reflect.Value) error {
+		if k != key {
+			return nil
+		}
+		found = true
+		if err := setConfigValue(v, value); err != nil {
+			return fmt.Errorf("Error setting %s: %v", key, err)
+		}
+		return nil
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("Error: unknown config key %q", key)
+	}
+	return err
+}
+
+// tokenSourceKeys are the keys of the token sources of OncoKBConfig.
+var tokenSourceKeys = map[string]bool{"oncokb.token": true, "oncokb.token_file": true, "oncokb.token_command": true}
+
+// tokenSources counts the token sources that are set.
+func (o OncoKBConfig) tokenSources() int {
+	sources := 0
+	for _, set := range []bool{o.Token != "", o.TokenFile != "", len(o.TokenCommand) > 0} {
+		if set {
+			sources++
+		}
+	}
+	return sources
+}
+
+func (o *OncoKBConfig) clearTokenSources() {
+	o.Token, o.TokenFile, o.TokenCommand = "", "", nil
+}
+
+func setConfigValue(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case []string:
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var list []string
+			if err := json.Unmarshal([]byte(value), &list); err != nil {
+				return fmt.Errorf("expected a JSON array of strings")
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case int, int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		v.SetInt(n)
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		v.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 500ms or 1m")
+		}
+		v.SetInt(int64(d))
+	default:
+		return fmt.Errorf("cannot be set from a string")
+	}
+	return nil
+}
+
+// walkConfig visits the fields of a Config with their YAML key.
+func walkConfig(v reflect.Value, prefix string, visit func(string, reflect.StructField, reflect.Value) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Struct {
+			if err := walkConfig(v.Field(i), key+".", visit); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := visit(key, field, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting, so they can all be fixed at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("Error: "+format, args...))
+		}
+	}
+
+	o := c.OncoKB
+	check(o.Mode == AnnotatorModeOnline || o.Mode == AnnotatorModeOffline, "oncokb.mode %q is not online or offline", o.Mode)
+	if o.Mode == AnnotatorModeOnline {
+		u, err := url.Parse(o.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "oncokb.url %q is not an http or https URL", o.URL)
+		check(err != nil || !strings.Contains(u.Path, "/annotate/") || strings.Contains(u.Path, mutationsEndpoint),
+			"oncokb.url %q is an OncoKB endpoint, it should be the API base URL like https://www.oncokb.org/api/v1", o.URL)
+		check(o.tokenSources() <= 1, "only one of oncokb.token, oncokb.token_file and oncokb.token_command can be set")
+	} else {
+		check(len(o.Snapshot) > 0, "oncokb.snapshot is needed in offline mode")
+	}
+	check(o.BatchSize >= 0, "oncokb.batch_size must not be negative")
+	check(o.Concurrency >= 1, "oncokb.concurrency must be at least 1")
+	check(o.RequestRate >= 0, "oncokb.request_rate must not be negative")
+	check(o.RequestBurst >= 1, "oncokb.request_burst must be at least 1")
+	check(o.Retries >= 1, "oncokb.retries must be at least 1")
+	check(o.RetryBackoff >= 0, "oncokb.retry_backoff must not be negative")
+	if o.VersionSkew != "" {
+		_, err := ParseVersionSkewPolicy(o.VersionSkew)
+		check(err == nil, "oncokb.version_skew %q is not fail or reannotate", o.VersionSkew)
+	}
+	check(o.DataVersionTTL >= 0, "oncokb.data_version_ttl must not be negative")
+	for variantClass, consequences := range o.ConsequenceOverrides {
+		check(len(consequences) > 0, "oncokb.consequence_overrides %q has no consequences", variantClass)
+	}
+
+	check(c.Sample.NcbiBuild == "GRCh37" || c.Sample.NcbiBuild == "GRCh38", "sample.ncbi_build %q is not GRCh37 or GRCh38", c.Sample.NcbiBuild)
+	check(c.Cache.Size >= 0, "cache.size must not be negative")
+	check(c.Cache.TTL >= 0, "cache.ttl must not be negative")
+	check(c.Server.ClientRate >= 0, "server.client_rate must not be negative")
+	check(c.Server.ClientBurst >= 1, "server.client_burst must be at least 1")
+	check(c.Server.MaxRequestSize > 0, "server.max_request_size must be positive")
+	_, err := ParseMessageFormat(c.Worker.Format)
+	check(err == nil, "worker.format %q is not ndjson or proto", c.Worker.Format)
+	_, err = ParseAnnotationTypes(c.Worker.Annotations)
+	check(err == nil, "worker.annotations %q is not a list of mutations, cna and sv", c.Worker.Annotations)
+	if c.Output.Format != "" {
+		_, err := ParseMessageFormat(c.Output.Format)
+		check(err == nil, "output.format %q is not ndjson or proto", c.Output.Format)
+	}
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level %q is not debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format %q is not text or json", c.Log.Format)
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration with its secrets replaced, for printing.
+func (c Config) Redacted() Config {
+	walkConfig(reflect.ValueOf(&c).Elem(), "", func(key string, field reflect.StructField, v reflect.Value) error {
+		if field.Tag.Get("secret") == "true" && v.String() != "" {
+			v.SetString(redactedConfigValue)
+		}
+		return nil
+	})
+	return c
+}
+
+// WriteYAML writes the configuration, with its secrets redacted, in the format LoadConfig reads.
+func (c Config) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("Error writing config: %v", err)
+	}
+	return encoder.Close()
+}
+
+// MutationsURL is the OncoKB annotate mutations endpoint of oncokb.url.  The URL is the API base URL, like
+// https://www.oncokb.org/api/v1, whose byProteinChange endpoint is used, or already an annotate mutations endpoint,
+// which is used as it is.
+func (c Config) MutationsURL() string {
+	base := strings.TrimSuffix(c.OncoKB.URL, "/")
+	if strings.Contains(base, mutationsEndpoint) {
+		return base
+	}
+	return base + mutationsEndpoint + "byProteinChange"
+}
+
+const mutationsEndpoint = "/annotate/mutations/"
+
+// TokenEnv is the environment variable the token is read from when the config does not set one.
+const TokenEnv = "ONCOKB_API_TOKEN"
+
+// TokenProvider returns the provider of the configured token, logging its warnings to logger.  The token file,
+// command or token of the config come first, and the TokenEnv environment variable is the fallback.
+func (c Config) TokenProvider(logger *slog.Logger) (TokenProvider, error) {
+	o := c.OncoKB
+	switch {
+	case o.TokenFile != "":
+		return NewFileTokenProvider(o.TokenFile, WithFileTokenLogger(logger)), nil
+	case len(o.TokenCommand) > 0:
+		return NewExecTokenProvider(15*time.Minute, o.TokenCommand[0], o.TokenCommand[1:]...), nil
+	case o.Token != "":
+		return StaticToken(o.Token), nil
+	case os.Getenv(TokenEnv) != "":
+		return EnvTokenProvider{Name: TokenEnv}, nil
+	}
+	return nil, fmt.Errorf("Error: no OncoKB token, set %s, oncokb.token_file or oncokb.token_command", TokenEnv)
+}
+
+// NewLogger returns a logger writing to w at the configured level and in the configured format.
+func (c Config) NewLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Log.Level))
+	options := &slog.HandlerOptions{Level: level}
+	if c.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// NewAnnotator returns the Annotator the configuration describes: an OncoKBAnnotatorService, or an OfflineAnnotator
+// in offline mode, with the configured cache, batching, concurrency and rate limit, retrying as configured.
+// OncoKB requests and retries are logged to logger.
+func (c Config) NewAnnotator(logger *slog.Logger) (Annotator, error) {
+	o := c.OncoKB
+	opts := []Option{WithBatchSize(o.BatchSize), WithConcurrency(o.Concurrency), WithLogger(logger), WithDataVersionTTL(o.DataVersionTTL)}
+	if o.VersionSkew != "" {
+		policy, err := ParseVersionSkewPolicy(o.VersionSkew)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithVersionSkewPolicy(policy))
+	}
+	if len(o.ConsequenceOverrides) > 0 {
+		opts = append(opts, WithConsequenceOverrides(o.ConsequenceOverrides))
+	}
+	if c.Cache.Dir != "" {
+		cache, err := NewDiskCache(c.Cache.Dir, WithDiskCacheLogger(logger))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCache(cache))
+	} else if c.Cache.Size > 0 {
+		opts = append(opts, WithCache(NewLRUCache(c.Cache.Size, c.Cache.TTL)))
+	}
+
+	var annotator Annotator
+	if o.Mode == AnnotatorModeOffline {
+		snapshot, err := loadSnapshot(o.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if annotator, err = NewOfflineAnnotator(snapshot, opts...); err != nil {
+			return nil, err
+		}
+	} else {
+		tokens, err := c.TokenProvider(logger)
+		if err != nil {
+			return nil, err
+		}
+		if o.RequestRate > 0 {
+			opts = append(opts, WithRequestRate(o.RequestRate, o.RequestBurst))
+		}
+		if annotator, err = NewOncoKBAnnotatorService(tokens, c.MutationsURL(), opts...); err != nil {
+			return nil, err
+		}
+	}
+	if o.Retries > 1 {
+		annotator = NewRetryingAnnotator(annotator, o.Retries, o.RetryBackoff, WithRetryLogger(logger))
+	}
+	return annotator, nil
+}
+
+// loadSnapshot reads allAnnotatedVariants.txt downloads, files ending in .txt, and recorded OncoKB responses.
+func loadSnapshot(paths []string) (*Snapshot, error) {
+	snapshot := NewSnapshot()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening snapshot file: %v", err)
+		}
+		if strings.HasSuffix(path, ".txt") {
+			err = snapshot.LoadAnnotatedVariants(f)
+		} else {
+			err = snapshot.LoadResponses(f)
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading snapshot file %q: %v", path, err)
+		}
+	}
+	return snapshot, nil
+}
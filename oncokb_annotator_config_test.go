@@ -0,0 +1,208 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+oncokb:
+  url: https://oncokb.example.org/api/v1
+  token: file-token
+  batch_size: 50
+  retry_backoff: 2s
+  consequence_overrides:
+    Splice_Region: [splice_region_variant]
+sample:
+  ncbi_build: GRCh38
+`
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	env := map[string]string{"ONCOKB_BATCH_SIZE": "100", "ONCOKB_SNAPSHOT": "a.json, b.txt"}
+	if err := config.ApplyEnv(func(name string) (string, bool) { v, ok := env[name]; return v, ok }); err != nil {
+		t.Fatalf("Failed to apply environment: %v", err)
+	}
+	if err := config.Set("oncokb.concurrency", "4"); err != nil {
+		t.Fatalf("Failed to set concurrency: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Failed to validate config: %v", err)
+	}
+
+	o := config.OncoKB
+	if o.URL != "https://oncokb.example.org/api/v1" || o.BatchSize != 100 || o.Concurrency != 4 || o.RetryBackoff != 2*time.Second {
+		t.Errorf("expected the file, environment and set values to be layered but got %+v", o)
+	}
+	if strings.Join(o.Snapshot, "|") != "a.json|b.txt" || len(o.ConsequenceOverrides["Splice_Region"]) != 1 {
+		t.Errorf("expected the snapshot and consequence overrides but got %v and %v", o.Snapshot, o.ConsequenceOverrides)
+	}
+	if config.Sample.NcbiBuild != "GRCh38" || config.Sample.PipelineVersion != "v1.0" || o.Retries != 3 {
+		t.Errorf("expected the defaults of the keys not in the file but got %+v and %d retries", config.Sample, o.Retries)
+	}
+	if config.MutationsURL() != "https://oncokb.example.org/api/v1/annotate/mutations/byProteinChange" {
+		t.Errorf("expected the mutations endpoint of the base URL but got %s", config.MutationsURL())
+	}
+
+	// a full endpoint URL is used as it is rather than getting the endpoint path twice
+	for _, endpoint := range []string{"byProteinChange", "byGenomicChange"} {
+		endpointConfig := DefaultConfig()
+		endpointConfig.OncoKB.URL = "https://oncokb.example.org/api/v1/annotate/mutations/" + endpoint
+		if got := endpointConfig.MutationsURL(); got != endpointConfig.OncoKB.URL {
+			t.Errorf("expected the %s endpoint as it is but got %s", endpoint, got)
+		}
+	}
+
+	command := DefaultConfig()
+	if err := command.Set("oncokb.token_command", `["get-secret", "--name", "oncokb token"]`); err != nil {
+		t.Fatalf("Failed to set token command: %v", err)
+	}
+	if strings.Join(command.OncoKB.TokenCommand, "|") != "get-secret|--name|oncokb token" {
+		t.Errorf("expected the token command arguments as they were given but got %q", command.OncoKB.TokenCommand)
+	}
+
+	var out bytes.Buffer
+	if err := config.WriteYAML(&out); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if strings.Contains(out.String(), "file-token") || !strings.Contains(out.String(), "token: "+redactedConfigValue) {
+		t.Errorf("expected the token to be redacted but got\n%s", out.String())
+	}
+	if config.OncoKB.Token != "file-token" {
+		t.Errorf("expected redacting to leave the config alone")
+	}
+
+	if err := os.WriteFile(path, []byte("oncokb:\n  batchsize: 10\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "batchsize") {
+		t.Errorf("expected an error for an unknown key but got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.OncoKB.URL = "oncokb.org"
+	config.OncoKB.Concurrency = 0
+	config.OncoKB.TokenFile = "token"
+	config.OncoKB.TokenCommand = []string{"vault", "read"}
+	config.Sample.NcbiBuild = "hg19"
+	config.Output.Format = "xml"
+	config.Worker.Annotations = "mutations,expression"
+	config.Log.Level = "verbose"
+	config.OncoKB.VersionSkew = "ignore"
+	err := config.Validate()
+	if err == nil {
+		t.Fatalf("expected the config to be invalid")
+	}
+	for _, key := range []string{"oncokb.url", "oncokb.concurrency", "oncokb.token_command", "sample.ncbi_build", "output.format", "worker.annotations", "log.level", "oncokb.version_skew"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error for %s but got %v", key, err)
+		}
+	}
+
+	config = DefaultConfig()
+	config.OncoKB.URL = "https://oncokb.example.org/api/v1/annotate/copyNumberAlterations"
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "API base URL") {
+		t.Errorf("expected an error for an endpoint that does not annotate mutations but got %v", err)
+	}
+
+	config = DefaultConfig()
+	config.OncoKB.Mode = AnnotatorModeOffline
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "oncokb.snapshot") {
+		t.Errorf("expected an error for offline mode without a snapshot but got %v", err)
+	}
+	if err := config.Set("oncokb.batch_size", "many"); err == nil {
+		t.Errorf("expected an error for a batch size that is not a number")
+	}
+	if err := config.Set("oncokb.batchsize", "1"); err == nil {
+		t.Errorf("expected an error for an unknown key")
+	}
+	if err := config.ApplyEnv(func(string) (string, bool) { return "secret-token", true }); err == nil || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("expected an error without the value but got %v", err)
+	}
+}
+
+func TestConfigTokenProvider(t *testing.T) {
+	t.Setenv(TokenEnv, "env-token")
+	config := DefaultConfig()
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		t.Fatalf("Failed to apply environment: %v", err)
+	}
+	config.OncoKB.TokenFile = "token"
+	// the environment token is a fallback, it does not conflict with a token file
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected a token file with the token environment variable set to be valid but got %v", err)
+	}
+	if tokens, err := config.TokenProvider(nil); err != nil {
+		t.Errorf("Failed to get the token provider: %v", err)
+	} else if _, ok := tokens.(*FileTokenProvider); !ok {
+		t.Errorf("expected the token file to come before the environment but got %T", tokens)
+	}
+
+	config.OncoKB.TokenFile = ""
+	if tokens, err := config.TokenProvider(nil); err != nil {
+		t.Errorf("Failed to get the token provider: %v", err)
+	} else if token, err := tokens.Token(context.Background()); err != nil || token != "env-token" {
+		t.Errorf("expected the environment token but got %q (%v)", token, err)
+	}
+}
+
+func TestConfigTokenSourceLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("oncokb:\n  token_file: /run/secrets/oncokb\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	// the token command of the environment replaces the token file of the config file
+	env := map[string]string{"ONCOKB_API_TOKEN_COMMAND": `["vault", "read"]`}
+	if err := config.ApplyEnv(func(name string) (string, bool) { v, ok := env[name]; return v, ok }); err != nil {
+		t.Fatalf("Failed to apply environment: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected a token command over a token file to be valid but got %v", err)
+	}
+	if config.OncoKB.TokenFile != "" || strings.Join(config.OncoKB.TokenCommand, "|") != "vault|read" {
+		t.Errorf("expected only the token command of the environment but got %+v", config.OncoKB)
+	}
+	// and a token source set afterwards replaces it in turn
+	if err := config.Set("oncokb.token", "flag-token"); err != nil {
+		t.Fatalf("Failed to set token: %v", err)
+	}
+	if config.OncoKB.Token != "flag-token" || len(config.OncoKB.TokenCommand) > 0 {
+		t.Errorf("expected only the token set last but got %+v", config.OncoKB)
+	}
+
+	// a layer without a token source keeps the one below
+	config, _ = LoadConfig(path)
+	if err := config.ApplyEnv(func(string) (string, bool) { return "", false }); err != nil || config.OncoKB.TokenFile != "/run/secrets/oncokb" {
+		t.Errorf("expected the token file of the config file but got %q (%v)", config.OncoKB.TokenFile, err)
+	}
+
+	// two token sources in the same layer are an error
+	env["ONCOKB_API_TOKEN_FILE"] = "token"
+	if err := config.ApplyEnv(func(name string) (string, bool) { v, ok := env[name]; return v, ok }); err == nil {
+		t.Errorf("expected an error for a token file and a token command in the environment")
+	}
+	if err := os.WriteFile(path, []byte("oncokb:\n  token: secret\n  token_file: token\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("expected an error for a token and a token file in the config file but got %v", err)
+	}
+}
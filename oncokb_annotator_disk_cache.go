@@ -0,0 +1,215 @@
+package tempo_databricks_gateway
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	diskCacheVersionFile      = "DATA_VERSION"
+	diskCacheVersionDirPrefix = "v_"
+)
+
+// DiskCache is an AnnotationCache that keeps the OncoKB response JSON of each normalized query in a file,
+// so responses can be reused by later runs and by other processes on the same machine.
+//
+// Responses are stored in a directory per data version, and DATA_VERSION names the newest version stored.
+// When a response for a newer data version is stored, the directories of the older versions are removed.  Responses
+// for a version older than the newest are not stored, and nothing newer than the version being stored is ever removed,
+// so processes sharing the cache across an OncoKB data release do not delete each other's responses.
+// Files are written to a temporary name and renamed into place, so concurrent readers never see a partial response.
+type DiskCache struct {
+	dir    string
+	logger *slog.Logger
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// DiskCacheOption configures optional behavior of a DiskCache.
+type DiskCacheOption func(*DiskCache)
+
+// WithDiskCacheLogger logs the responses that could not be stored, and the old versions that could not be removed.
+func WithDiskCacheLogger(logger *slog.Logger) DiskCacheOption {
+	return func(c *DiskCache) {
+		c.logger = logger
+	}
+}
+
+// NewDiskCache creates a DiskCache in dir, reusing any responses already there.
+func NewDiskCache(dir string, opts ...DiskCacheOption) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Error creating cache directory %q: %s", dir, err)
+	}
+	c := &DiskCache{dir: dir, logger: discardLogger}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.logger == nil {
+		c.logger = discardLogger
+	}
+	return c, nil
+}
+
+func (c *DiskCache) Get(dataVersion, key string) (OncoKBResponse, bool) {
+	if len(dataVersion) == 0 {
+		c.misses.Add(1)
+		return OncoKBResponse{}, false
+	}
+	jsonData, err := os.ReadFile(c.responsePath(dataVersion, key))
+	if err != nil {
+		c.misses.Add(1)
+		return OncoKBResponse{}, false
+	}
+	resp, err := unMarshal[OncoKBResponse](string(jsonData))
+	if err != nil {
+		c.misses.Add(1)
+		return OncoKBResponse{}, false
+	}
+	c.hits.Add(1)
+	return resp, true
+}
+
+// Put stores resp.  A failure to store is logged and only means the response will not be cached.
+func (c *DiskCache) Put(dataVersion, key string, resp OncoKBResponse) {
+	if err := c.put(dataVersion, key, resp); err != nil {
+		c.logger.Warn("oncokb response not cached", slog.String("dir", c.dir), slog.String("oncokb_data_version", dataVersion),
+			slog.String("error", err.Error()))
+	}
+}
+
+func (c *DiskCache) put(dataVersion, key string, resp OncoKBResponse) error {
+	if len(dataVersion) == 0 {
+		return nil
+	}
+	switch newest := c.newestVersion(); {
+	case newest == "" || compareDataVersions(dataVersion, newest) > 0:
+		if err := c.advance(dataVersion); err != nil {
+			return err
+		}
+	case compareDataVersions(dataVersion, newest) < 0:
+		// a process that has not seen the newer release yet, its responses are already stale
+		return nil
+	}
+	jsonData, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	path := c.responsePath(dataVersion, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, jsonData)
+}
+
+func (c *DiskCache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+func (c *DiskCache) newestVersion() string {
+	version, err := os.ReadFile(filepath.Join(c.dir, diskCacheVersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(version))
+}
+
+// advance records dataVersion as the newest version and removes the responses of the versions older than it.
+func (c *DiskCache) advance(dataVersion string) error {
+	if err := writeFileAtomic(filepath.Join(c.dir, diskCacheVersionFile), []byte(dataVersion)); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	current := strings.TrimPrefix(getVersionDir(dataVersion), diskCacheVersionDirPrefix)
+	for _, e := range entries {
+		version, ok := strings.CutPrefix(e.Name(), diskCacheVersionDirPrefix)
+		if !e.IsDir() || !ok || compareDataVersions(version, current) >= 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, e.Name())); err != nil {
+			c.logger.Warn("old oncokb responses not removed", slog.String("dir", filepath.Join(c.dir, e.Name())),
+				slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+// compareDataVersions orders OncoKB data versions like v4.9 and v4.22 by their numbers, and anything else by text.
+func compareDataVersions(a, b string) int {
+	an, bn := splitVersionNumbers(a), splitVersionNumbers(b)
+	if an == nil || bn == nil {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < len(an) && i < len(bn); i++ {
+		if an[i] != bn[i] {
+			return cmp.Compare(an[i], bn[i])
+		}
+	}
+	return cmp.Compare(len(an), len(bn))
+}
+
+// splitVersionNumbers returns the numbers of a version like v4.22, or nil when it is not made of numbers.
+func splitVersionNumbers(version string) []int {
+	parts := strings.FieldsFunc(strings.TrimPrefix(strings.ToLower(version), "v"), func(r rune) bool { return r == '.' || r == '_' })
+	numbers := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil
+		}
+		numbers[i] = n
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+	return numbers
+}
+
+func (c *DiskCache) responsePath(dataVersion, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	// spread the responses out so no single directory gets too big
+	return filepath.Join(c.dir, getVersionDir(dataVersion), name[:2], name+".json")
+}
+
+// getVersionDir turns a data version into a safe directory name.
+func getVersionDir(dataVersion string) string {
+	return diskCacheVersionDirPrefix + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, dataVersion)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
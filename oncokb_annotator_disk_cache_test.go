@@ -0,0 +1,47 @@
+package tempo_databricks_gateway
+
+import (
+	"testing"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("Failed to create a DiskCache: %v", err)
+	}
+	c.Put("v4.22", "KRAS|G12D", OncoKBResponse{Oncogenic: "Oncogenic", DataVersion: "v4.22"})
+
+	// a second cache on the same directory, like another process, sees the response
+	other, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("Failed to create a DiskCache: %v", err)
+	}
+	resp, hit := other.Get("v4.22", "KRAS|G12D")
+	if !hit || resp.Oncogenic != "Oncogenic" {
+		t.Errorf("expected a cached Oncogenic response but got %v, %+v", hit, resp)
+	}
+
+	c.Put("v4.23", "BRAF|V600E", OncoKBResponse{Oncogenic: "Oncogenic", DataVersion: "v4.23"})
+	if _, hit := other.Get("v4.22", "KRAS|G12D"); hit {
+		t.Errorf("expected the v4.22 responses to be invalidated by v4.23")
+	}
+	if _, hit := other.Get("v4.23", "BRAF|V600E"); !hit {
+		t.Errorf("expected a cached v4.23 response")
+	}
+	if stats := other.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// a process still on the old version neither deletes the newer responses nor stores its stale ones
+	other.Put("v4.22", "KRAS|G12D", OncoKBResponse{Oncogenic: "Oncogenic", DataVersion: "v4.22"})
+	if _, hit := c.Get("v4.23", "BRAF|V600E"); !hit {
+		t.Errorf("expected the v4.23 responses to survive a v4.22 write")
+	}
+	if _, hit := c.Get("v4.22", "KRAS|G12D"); hit || c.newestVersion() != "v4.23" {
+		t.Errorf("expected v4.23 to stay the newest version but got %s", c.newestVersion())
+	}
+	if compareDataVersions("v4.9", "v4.22") >= 0 || compareDataVersions("v5.0", "v4.22") <= 0 {
+		t.Errorf("expected data versions to be ordered by their numbers")
+	}
+}
@@ -0,0 +1,60 @@
+package tempo_databricks_gateway
+
+import "fmt"
+
+// UnmatchedResponseError is returned when OncoKB answers with a query ID that was not in the request.
+type UnmatchedResponseError struct {
+	QueryID string
+}
+
+func (e *UnmatchedResponseError) Error() string {
+	return fmt.Sprintf("OncoKB response with query id %q does not match any event", e.QueryID)
+}
+
+// MissingResponseError is returned when OncoKB does not answer one of the requested events.
+type MissingResponseError struct {
+	QueryID    string
+	HugoSymbol string
+	Alteration string
+}
+
+func (e *MissingResponseError) Error() string {
+	return fmt.Sprintf("OncoKB did not return a response for query id %q (%s %s)", e.QueryID, e.HugoSymbol, e.Alteration)
+}
+
+// DuplicateResponseError is returned when OncoKB answers the same query ID more than once.
+// Only the first response is mapped onto the event.
+type DuplicateResponseError struct {
+	QueryID string
+}
+
+func (e *DuplicateResponseError) Error() string {
+	return fmt.Sprintf("OncoKB returned more than one response for query id %q", e.QueryID)
+}
+
+// OncoKBAPIError is returned when OncoKB answers with a status other than 200.
+type OncoKBAPIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *OncoKBAPIError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("Error making OncoKB API request: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Error making OncoKB API request: %s", e.Message)
+}
+
+// InvalidMessageError is returned when the events of a message cannot be turned into OncoKB requests, like an event
+// with an unknown variant classification or without an HGVSp_Short.  Sending the same message again will not help.
+type InvalidMessageError struct {
+	Err error
+}
+
+func (e *InvalidMessageError) Error() string {
+	return fmt.Sprintf("Error creating OncoKB request body %s", e.Err)
+}
+
+func (e *InvalidMessageError) Unwrap() error {
+	return e.Err
+}
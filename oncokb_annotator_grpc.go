@@ -0,0 +1,253 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// annotation_service.pb.go and annotation_service_grpc.pb.go are generated from proto/annotation_service.proto.
+// TEMPO_PROTO_DIR is the directory of tempo.proto in cdsi-protobuf, and GOOGLEAPIS_DIR the root of googleapis for
+// google/rpc/status.proto.
+//go:generate protoc -I proto -I ${TEMPO_PROTO_DIR} -I ${GOOGLEAPIS_DIR} --go_out=. --go_opt=module=github.mskcc.org/cdsi/tempo-databricks-gateway,Mtempo.proto=github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go --go-grpc_out=. --go-grpc_opt=module=github.mskcc.org/cdsi/tempo-databricks-gateway,Mtempo.proto=github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go proto/annotation_service.proto
+
+// AnnotationTypesMetadataKey selects the annotations to run, like the types query parameter of AnnotationServer.
+const AnnotationTypesMetadataKey = "annotation-types"
+
+const (
+	defaultStreamWindow      = 16
+	defaultStreamBatchEvents = 500
+)
+
+// GRPCAnnotationServer implements AnnotationServiceServer with an Annotator.
+type GRPCAnnotationServer struct {
+	UnimplementedAnnotationServiceServer
+	annotator         Annotator
+	streamWindow      int
+	streamBatchEvents int
+}
+
+var _ AnnotationServiceServer = (*GRPCAnnotationServer)(nil)
+
+// GRPCServerOption configures a GRPCAnnotationServer.
+type GRPCServerOption func(*GRPCAnnotationServer)
+
+// WithStreamWindow sets how many messages of a stream can wait to be annotated before the server stops reading it,
+// 16 by default.
+func WithStreamWindow(messages int) GRPCServerOption {
+	return func(s *GRPCAnnotationServer) {
+		s.streamWindow = max(messages, 1)
+	}
+}
+
+// WithStreamBatchEvents sets how many events of waiting messages are annotated together, 500 by default.
+// A message with more events than this is annotated on its own.
+func WithStreamBatchEvents(events int) GRPCServerOption {
+	return func(s *GRPCAnnotationServer) {
+		s.streamBatchEvents = max(events, 1)
+	}
+}
+
+// NewGRPCAnnotationServer returns an AnnotationService annotating with annotator.
+func NewGRPCAnnotationServer(annotator Annotator, opts ...GRPCServerOption) *GRPCAnnotationServer {
+	s := &GRPCAnnotationServer{annotator: annotator, streamWindow: defaultStreamWindow, streamBatchEvents: defaultStreamBatchEvents}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *GRPCAnnotationServer) AnnotateMessage(ctx context.Context, message *tt.TempoMessage) (*tt.TempoMessage, error) {
+	ctx = getGRPCCorrelationID(ctx)
+	annotate, err := getGRPCAnnotations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range annotate {
+		if err := a(s.annotator, ctx, message); err != nil {
+			return nil, getGRPCStatus(message, err)
+		}
+	}
+	return message, nil
+}
+
+func (s *GRPCAnnotationServer) AnnotateStream(stream grpc.BidiStreamingServer[tt.TempoMessage, AnnotateStreamResponse]) error {
+	ctx := getGRPCCorrelationID(stream.Context())
+	annotate, err := getGRPCAnnotations(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the receiving goroutine blocks once the window is full, which stops reading the stream and lets
+	// flow control hold the client back until the annotated messages have been sent
+	waiting := make(chan *tt.TempoMessage, s.streamWindow)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(waiting)
+		for {
+			message, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					recvErr <- err
+				}
+				return
+			}
+			select {
+			case waiting <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for message := range waiting {
+		batch := []*tt.TempoMessage{message}
+		events := len(message.Events)
+	fill:
+		for events < s.streamBatchEvents {
+			select {
+			case next, ok := <-waiting:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+				events += len(next.Events)
+			default:
+				break fill
+			}
+		}
+		errs := s.annotateBatch(ctx, annotate, batch)
+		for i, m := range batch {
+			resp := &AnnotateStreamResponse{Message: m}
+			if errs[i] != nil {
+				resp.Status = status.Convert(getGRPCStatus(m, errs[i])).Proto()
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+		return ctx.Err()
+	}
+}
+
+// annotateBatch annotates the events of messages with the same oncotree code and pipeline version together, as the
+// OncoKB queries take their tumor type from the message, and returns the error of each message, nil when it was
+// annotated.  When a group has a message whose events cannot be sent to OncoKB, its messages are annotated one by
+// one so only that message fails.  Any other error, like OncoKB being unavailable, fails the whole group rather than
+// calling OncoKB again for each of its messages.
+func (s *GRPCAnnotationServer) annotateBatch(ctx context.Context, annotate []AnnotateFunc, batch []*tt.TempoMessage) []error {
+	type groupKey struct {
+		oncotreeCode    string
+		pipelineVersion string
+	}
+	var groups [][]int
+	byKey := make(map[groupKey]int)
+	for i, m := range batch {
+		key := groupKey{m.OncotreeCode, m.PipelineVersion}
+		g, exists := byKey[key]
+		if !exists {
+			g = len(groups)
+			byKey[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	errs := make([]error, len(batch))
+	for _, group := range groups {
+		first := batch[group[0]]
+		combined := &tt.TempoMessage{
+			CmoSampleId:       first.CmoSampleId,
+			NormalCmoSampleId: first.NormalCmoSampleId,
+			PipelineVersion:   first.PipelineVersion,
+			OncotreeCode:      first.OncotreeCode,
+		}
+		for _, i := range group {
+			combined.Events = append(combined.Events, batch[i].Events...)
+		}
+		err := s.annotateMessage(ctx, annotate, combined)
+		var invalid *InvalidMessageError
+		switch {
+		case err == nil:
+			for _, i := range group {
+				batch[i].OncokbDataVersion = combined.OncokbDataVersion
+			}
+		case len(group) > 1 && errors.As(err, &invalid):
+			// the annotations that went through on the combined message are run again for each message, on
+			// events that are cleared first so nothing is annotated twice
+			for _, i := range group {
+				for _, e := range batch[i].Events {
+					clearOncoKBEventFields(e)
+				}
+				errs[i] = s.annotateMessage(ctx, annotate, batch[i])
+			}
+		default:
+			for _, i := range group {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+func (s *GRPCAnnotationServer) annotateMessage(ctx context.Context, annotate []AnnotateFunc, message *tt.TempoMessage) error {
+	for _, a := range annotate {
+		if err := a(s.annotator, ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getGRPCCorrelationID returns ctx with the correlation ID of the metadata of the call, when it has one.
+func getGRPCCorrelationID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(CorrelationIDMetadataKey)) > 0 {
+		return WithCorrelationID(ctx, md.Get(CorrelationIDMetadataKey)[0])
+	}
+	return ctx
+}
+
+// getGRPCAnnotations returns the annotations named by the annotation-types metadata of the call.
+func getGRPCAnnotations(ctx context.Context) ([]AnnotateFunc, error) {
+	types := "mutations"
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(AnnotationTypesMetadataKey)) > 0 {
+		types = strings.Join(md.Get(AnnotationTypesMetadataKey), ",")
+	}
+	annotate, err := ParseAnnotationTypes(types)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return annotate, nil
+}
+
+// getGRPCStatus maps an annotation error to a gRPC status the way AnnotationServer maps it to an HTTP status.
+func getGRPCStatus(message *tt.TempoMessage, err error) error {
+	code := codes.Internal
+	switch getErrorStatus(err) {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+		if errors.Is(err, context.Canceled) {
+			code = codes.Canceled
+		}
+	case http.StatusServiceUnavailable:
+		code = codes.ResourceExhausted
+	case http.StatusBadGateway:
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, "Error annotating sample %q: %v", message.CmoSampleId, err)
+}
@@ -0,0 +1,236 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, annotator Annotator, opts ...GRPCServerOption) AnnotationServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	RegisterAnnotationServiceServer(server, NewGRPCAnnotationServer(annotator, opts...))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewAnnotationServiceClient(conn)
+}
+
+func TestGRPCAnnotateMessage(t *testing.T) {
+	annotator := &fakeAnnotator{}
+	client := newTestGRPCClient(t, annotator)
+	message := &tt.TempoMessage{CmoSampleId: "S1", Events: []*tt.Event{{HugoSymbol: "BRAF"}}}
+
+	annotated, err := client.AnnotateMessage(context.Background(), message)
+	if err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+	if annotated.Events[0].OncokbOncogenic != "Oncogenic BRAF" || annotated.OncokbDataVersion != "v4.22" {
+		t.Errorf("expected an annotated message but got %v", annotated)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AnnotationTypesMetadataKey, "expression")
+	if _, err := client.AnnotateMessage(ctx, message); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an unknown annotation type to be an invalid argument but got %v", err)
+	}
+
+	annotator.err = &OncoKBAPIError{StatusCode: 500}
+	annotator.failures = annotator.calls + 1
+	if _, err := client.AnnotateMessage(context.Background(), message); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected an OncoKB error to be unavailable but got %v", err)
+	}
+}
+
+// blockingAnnotator holds the first call until release is closed.
+type blockingAnnotator struct {
+	*fakeAnnotator
+	release chan struct{}
+}
+
+func (b *blockingAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	<-b.release
+	return b.fakeAnnotator.AnnotateMutations(ctx, message)
+}
+
+func TestGRPCAnnotateStream(t *testing.T) {
+	annotator := &fakeAnnotator{}
+	release := make(chan struct{})
+	// a window larger than the stream lets the server queue every message while the first is being annotated
+	client := newTestGRPCClient(t, &blockingAnnotator{annotator, release}, WithStreamWindow(32), WithStreamBatchEvents(1000))
+	stream, err := client.AnnotateStream(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	const messages = 20
+	for i := 0; i < messages; i++ {
+		message := &tt.TempoMessage{
+			CmoSampleId:  fmt.Sprintf("S%d", i),
+			OncotreeCode: []string{"IDC", "LUAD"}[i%2],
+			Events:       []*tt.Event{{HugoSymbol: fmt.Sprintf("GENE%d", i)}},
+		}
+		if err := stream.Send(message); err != nil {
+			t.Fatalf("Failed to send message %d: %v", i, err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("Failed to close stream: %v", err)
+	}
+	close(release)
+
+	for i := 0; ; i++ {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			if i != messages {
+				t.Errorf("expected %d messages but got %d", messages, i)
+			}
+			break
+		}
+		if err != nil || resp.Status != nil {
+			t.Fatalf("Failed to receive message %d: %v %v", i, err, resp.GetStatus())
+		}
+		annotated := resp.Message
+		if annotated.CmoSampleId != fmt.Sprintf("S%d", i) {
+			t.Errorf("expected messages in the order they were sent but got %q at %d", annotated.CmoSampleId, i)
+		}
+		if annotated.Events[0].OncokbOncogenic != fmt.Sprintf("Oncogenic GENE%d", i) || annotated.OncokbDataVersion != "v4.22" {
+			t.Errorf("expected message %d to be annotated but got %v", i, annotated)
+		}
+	}
+	if annotator.events != messages || annotator.calls >= messages {
+		t.Errorf("expected %d events annotated in fewer than %d calls but got %d events in %d calls",
+			messages, messages, annotator.events, annotator.calls)
+	}
+}
+
+// invalidEventAnnotator fails messages with an Exotic event the way OncoKBAnnotatorService does, and holds the first
+// call until release is closed.
+type invalidEventAnnotator struct {
+	*blockingAnnotator
+	once sync.Once
+}
+
+func (a *invalidEventAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	a.once.Do(func() { <-a.release })
+	for _, e := range message.Events {
+		if e.VariantClassification == "Exotic" {
+			return &InvalidMessageError{Err: errors.New("An unknown variant classification has been encountered: Exotic")}
+		}
+	}
+	return a.fakeAnnotator.AnnotateMutations(ctx, message)
+}
+
+func TestGRPCAnnotateStreamStatus(t *testing.T) {
+	annotate := func(annotator *fakeAnnotator, classifications ...string) []*AnnotateStreamResponse {
+		// the first call is held until the others are waiting, so they are batched together
+		release := make(chan struct{})
+		client := newTestGRPCClient(t, &invalidEventAnnotator{blockingAnnotator: &blockingAnnotator{annotator, release}},
+			WithStreamWindow(32), WithStreamBatchEvents(1000))
+		stream, err := client.AnnotateStream(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		for i, vc := range classifications {
+			message := &tt.TempoMessage{CmoSampleId: fmt.Sprintf("S%d", i), OncotreeCode: "IDC",
+				Events: []*tt.Event{{HugoSymbol: "BRAF", VariantClassification: vc}}}
+			if err := stream.Send(message); err != nil {
+				t.Fatalf("Failed to send message %d: %v", i, err)
+			}
+		}
+		stream.CloseSend()
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		var responses []*AnnotateStreamResponse
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return responses
+			}
+			if err != nil {
+				t.Fatalf("Failed to receive a message: %v", err)
+			}
+			responses = append(responses, resp)
+		}
+	}
+
+	responses := annotate(&fakeAnnotator{}, "Missense_Mutation", "Missense_Mutation", "Exotic", "Missense_Mutation")
+	if len(responses) != 4 {
+		t.Fatalf("expected 4 responses but got %d", len(responses))
+	}
+	for i, resp := range responses {
+		want := codes.OK
+		if i == 2 {
+			want = codes.InvalidArgument
+		}
+		if got := status.FromProto(resp.Status).Code(); got != want || resp.Message.CmoSampleId != fmt.Sprintf("S%d", i) {
+			t.Errorf("message %d: expected %v but got %v for %q", i, want, got, resp.Message.CmoSampleId)
+		}
+	}
+
+	// an OncoKB outage fails every message of the batch without a call per message
+	annotator := &fakeAnnotator{failures: 100, err: &OncoKBAPIError{StatusCode: 503}}
+	for _, resp := range annotate(annotator, "Missense_Mutation", "Missense_Mutation", "Missense_Mutation") {
+		if got := status.FromProto(resp.Status).Code(); got != codes.Unavailable {
+			t.Errorf("expected an OncoKB outage to be unavailable but got %v", got)
+		}
+	}
+	if annotator.calls > 2 {
+		t.Errorf("expected at most a call for the first message and one for the others but got %d", annotator.calls)
+	}
+}
+
+// invalidCNAAnnotator annotates mutations as versionedAnnotator does, and fails the copy number alterations of
+// messages with an Exotic event.
+type invalidCNAAnnotator struct {
+	versionedAnnotator
+}
+
+func (a *invalidCNAAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	for _, e := range message.Events {
+		if e.VariantClassification == "Exotic" {
+			return &InvalidMessageError{Err: errors.New("An unknown variant classification has been encountered: Exotic")}
+		}
+	}
+	return nil
+}
+
+func TestGRPCAnnotateBatchFallback(t *testing.T) {
+	annotate, err := ParseAnnotationTypes("mutations,cna")
+	if err != nil {
+		t.Fatalf("Failed to parse annotation types: %v", err)
+	}
+	s := NewGRPCAnnotationServer(&invalidCNAAnnotator{versionedAnnotator{version: "v4.22"}})
+	batch := []*tt.TempoMessage{
+		{CmoSampleId: "S0", OncotreeCode: "IDC", Events: []*tt.Event{{HugoSymbol: "BRAF", VariantClassification: "Missense_Mutation"}}},
+		{CmoSampleId: "S1", OncotreeCode: "IDC", Events: []*tt.Event{{HugoSymbol: "BRAF", VariantClassification: "Exotic"}}},
+	}
+	errs := s.annotateBatch(context.Background(), annotate, batch)
+	var invalid *InvalidMessageError
+	if errs[0] != nil || !errors.As(errs[1], &invalid) {
+		t.Fatalf("expected only the second message to be invalid but got %v", errs)
+	}
+	// the mutations went through on the combined message before the copy number alterations failed it
+	if got := batch[0].Events[0].OncokbLevel1; got != "Drug v4.22" {
+		t.Errorf("expected the mutations to be annotated once but got %q", got)
+	}
+}
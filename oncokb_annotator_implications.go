@@ -0,0 +1,158 @@
+package tempo_databricks_gateway
+
+import (
+	"encoding/json"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// Implication is a single diagnostic or prognostic implication, as written by WithDetailedImplications.
+type Implication struct {
+	Level         string   `json:"level"`
+	TumorTypeCode string   `json:"tumorTypeCode,omitempty"`
+	TumorTypeName string   `json:"tumorTypeName,omitempty"`
+	MainType      string   `json:"mainType,omitempty"`
+	Alterations   []string `json:"alterations,omitempty"`
+	Description   string   `json:"description,omitempty"`
+	// ExcludedTumorTypes are the codes, or main types, of the tumor types the implication does not apply to
+	ExcludedTumorTypes []string `json:"excludedTumorTypes,omitempty"`
+}
+
+// implicationSource is implemented by DiagnosticImplications and PrognosticImplications.
+type implicationSource interface {
+	CitationSource
+	implication() Implication
+	excludedTumorTypes() []TumorType
+}
+
+func (d DiagnosticImplications) implication() Implication {
+	return newImplication(d.LevelOfEvidence, d.TumorType, d.ExcludedTumorTypes, d.Alterations, d.Description)
+}
+
+func (d DiagnosticImplications) excludedTumorTypes() []TumorType {
+	return d.ExcludedTumorTypes
+}
+
+func (p PrognosticImplications) implication() Implication {
+	return newImplication(p.LevelOfEvidence, p.TumorType, p.ExcludedTumorTypes, p.Alterations, p.Description)
+}
+
+func (p PrognosticImplications) excludedTumorTypes() []TumorType {
+	return p.ExcludedTumorTypes
+}
+
+func newImplication(level string, tumorType TumorType, excluded []TumorType, alterations []string, description string) Implication {
+	i := Implication{
+		Level:         level,
+		TumorTypeCode: tumorType.Code,
+		TumorTypeName: tumorType.Name,
+		MainType:      tumorType.MainType.Name,
+		Alterations:   alterations,
+		Description:   description,
+	}
+	for _, t := range excluded {
+		if len(t.Code) > 0 {
+			i.ExcludedTumorTypes = append(i.ExcludedTumorTypes, t.Code)
+		} else {
+			i.ExcludedTumorTypes = append(i.ExcludedTumorTypes, t.MainType.Name)
+		}
+	}
+	return i
+}
+
+// filterImplications keeps only the implications for oncotreeCode or one of its ancestors in oncotree, and drops the
+// ones whose excluded tumor types match them.  Without an oncotree the implications are kept as OncoKB returned them,
+// and when oncotreeCode is not in the oncotree, only oncotreeCode itself can match the excluded tumor types.
+func filterImplications[T implicationSource](oncotree *Oncotree, oncotreeCode string, items []T) []T {
+	if oncotree == nil {
+		return items
+	}
+	known := oncotree.Contains(oncotreeCode)
+	ancestors := []OncotreeNode{{Code: oncotreeCode}}
+	if known {
+		ancestors = oncotree.Ancestors(oncotreeCode)
+	}
+	var filtered []T
+	for _, item := range items {
+		i := item.implication()
+		excluded := false
+		for _, t := range item.excludedTumorTypes() {
+			if matchesTumorType(t.Code, t.MainType.Name, ancestors) {
+				excluded = true
+				break
+			}
+		}
+		// nothing else is filtered when we cannot tell what matches
+		if !excluded && (!known || matchesTumorType(i.TumorTypeCode, i.MainType, ancestors)) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// matchesTumorType reports whether the tumor type with code, or the main type of tumor types without a code, is one
+// of nodes.
+func matchesTumorType(code, mainType string, nodes []OncotreeNode) bool {
+	for _, n := range nodes {
+		// implications for a whole main type do not come with a tumor type code
+		if (len(code) > 0 && strings.EqualFold(code, n.Code)) ||
+			(len(code) == 0 && len(mainType) > 0 && strings.EqualFold(mainType, n.MainType)) {
+			return true
+		}
+	}
+	return false
+}
+
+func setDiagnosticLevels(e *tt.Event, diagnosticImplications []DiagnosticImplications, detailed bool) {
+	// diagnostic levels [Dx1, Dx2, Dx3]
+	e.OncokbLevelDx1 = getImplicationLevel("LEVEL_Dx1", diagnosticImplications, detailed)
+	e.OncokbLevelDx2 = getImplicationLevel("LEVEL_Dx2", diagnosticImplications, detailed)
+	e.OncokbLevelDx3 = getImplicationLevel("LEVEL_Dx3", diagnosticImplications, detailed)
+}
+
+func setPrognosticLevels(e *tt.Event, prognosticImplications []PrognosticImplications, detailed bool) {
+	// prognostic levels [Px1, Px2, Px3]
+	e.OncokbLevelPx1 = getImplicationLevel("LEVEL_Px1", prognosticImplications, detailed)
+	e.OncokbLevelPx2 = getImplicationLevel("LEVEL_Px2", prognosticImplications, detailed)
+	e.OncokbLevelPx3 = getImplicationLevel("LEVEL_Px3", prognosticImplications, detailed)
+}
+
+// getImplicationLevel returns the tumor types of the implications at level, joined by commas,
+// or the implications themselves as a JSON array when detailed is set.
+func getImplicationLevel[T implicationSource](level string, items []T, detailed bool) string {
+	var implications []Implication
+	var tumorTypes []string
+	for _, item := range items {
+		i := item.implication()
+		if i.Level != level {
+			continue
+		}
+		implications = append(implications, i)
+		tumorType := i.TumorTypeCode
+		if len(tumorType) == 0 {
+			tumorType = i.MainType
+		}
+		tumorTypes = append(tumorTypes, tumorType)
+	}
+	if !detailed {
+		return strings.Join(tumorTypes, ",")
+	}
+	if len(implications) == 0 {
+		return ""
+	}
+	jsonData, err := json.Marshal(implications)
+	if err != nil {
+		// Implication only holds strings, this cannot happen
+		return ""
+	}
+	return string(jsonData)
+}
+
+func getHighestImplicationLevel[T implicationSource](levels []string, items []T) string {
+	var highest string
+	for _, item := range items {
+		highest = getHigherLevel(levels, highest, item.implication().Level)
+	}
+	return highest
+}
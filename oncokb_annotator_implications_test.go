@@ -0,0 +1,93 @@
+package tempo_databricks_gateway
+
+import (
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+const testOncotreeJSON = `[
+	{"code": "TISSUE", "name": "Tissue", "mainType": "", "parent": ""},
+	{"code": "MYELOID", "name": "Myeloid", "mainType": "", "parent": "TISSUE"},
+	{"code": "MPN", "name": "Myeloproliferative Neoplasms", "mainType": "Myeloproliferative Neoplasms", "parent": "MYELOID"},
+	{"code": "PMF", "name": "Primary Myelofibrosis", "mainType": "Myeloproliferative Neoplasms", "parent": "MPN"},
+	{"code": "ET", "name": "Essential Thrombocythemia", "mainType": "Myeloproliferative Neoplasms", "parent": "MPN"},
+	{"code": "AML", "name": "Acute Myeloid Leukemia", "mainType": "Leukemia", "parent": "MYELOID"}
+]`
+
+var testPrognosticImplications = []PrognosticImplications{
+	PrognosticImplications{LevelOfEvidence: "LEVEL_Px1", TumorType: TumorType{Code: "AML", Name: "Acute Myeloid Leukemia"}, Pmids: []string{"25412851"}},
+	PrognosticImplications{LevelOfEvidence: "LEVEL_Px1", TumorType: TumorType{Code: "ET", Name: "Essential Thrombocythemia"}, Pmids: []string{"25860933"}},
+	PrognosticImplications{LevelOfEvidence: "LEVEL_Px2", TumorType: TumorType{MainType: MainType{Name: "Myeloproliferative Neoplasms"}}, Pmids: []string{"22186996"}},
+	PrognosticImplications{LevelOfEvidence: "LEVEL_Px3", TumorType: TumorType{Code: "MPN", Name: "Myeloproliferative Neoplasms"}, Alterations: []string{"Oncogenic Mutations"}, Description: "desc"},
+}
+
+func TestSetPrognosticLevels(t *testing.T) {
+	e := &tt.Event{}
+	setPrognosticLevels(e, testPrognosticImplications, false)
+	if e.OncokbLevelPx1 != "AML,ET" || e.OncokbLevelPx2 != "Myeloproliferative Neoplasms" || e.OncokbLevelPx3 != "MPN" {
+		t.Errorf("unexpected prognostic levels %q, %q, %q", e.OncokbLevelPx1, e.OncokbLevelPx2, e.OncokbLevelPx3)
+	}
+
+	setPrognosticLevels(e, testPrognosticImplications, true)
+	expected := `[{"level":"LEVEL_Px3","tumorTypeCode":"MPN","tumorTypeName":"Myeloproliferative Neoplasms","alterations":["Oncogenic Mutations"],"description":"desc"}]`
+	if e.OncokbLevelPx3 != expected {
+		t.Errorf("expected %q but got %q", expected, e.OncokbLevelPx3)
+	}
+}
+
+func TestFilterImplications(t *testing.T) {
+	oncotree, err := LoadOncotree(strings.NewReader(testOncotreeJSON))
+	if err != nil {
+		t.Fatalf("Failed to load oncotree: %v", err)
+	}
+
+	filtered := filterImplications(oncotree, "PMF", testPrognosticImplications)
+	e := &tt.Event{}
+	setPrognosticLevels(e, filtered, false)
+	if e.OncokbLevelPx1 != "" || e.OncokbLevelPx2 != "Myeloproliferative Neoplasms" || e.OncokbLevelPx3 != "MPN" {
+		t.Errorf("unexpected prognostic levels %q, %q, %q", e.OncokbLevelPx1, e.OncokbLevelPx2, e.OncokbLevelPx3)
+	}
+	if highest := getHighestImplicationLevel(prognosticLevels[:], filtered); highest != "LEVEL_Px2" {
+		t.Errorf("expected %q but got %q", "LEVEL_Px2", highest)
+	}
+
+	if unknown := filterImplications(oncotree, "LUAD", testPrognosticImplications); len(unknown) != len(testPrognosticImplications) {
+		t.Errorf("expected unknown oncotree code to keep all %d implications but got %d", len(testPrognosticImplications), len(unknown))
+	}
+}
+
+func TestFilterExcludedTumorTypes(t *testing.T) {
+	oncotree, err := LoadOncotree(strings.NewReader(testOncotreeJSON))
+	if err != nil {
+		t.Fatalf("Failed to load oncotree: %v", err)
+	}
+	implications := []DiagnosticImplications{
+		DiagnosticImplications{LevelOfEvidence: "LEVEL_Dx1", TumorType: TumorType{MainType: MainType{Name: "Myeloproliferative Neoplasms"}},
+			ExcludedTumorTypes: []TumorType{TumorType{Code: "ET"}}},
+		DiagnosticImplications{LevelOfEvidence: "LEVEL_Dx2", TumorType: TumorType{Code: "MPN"}},
+	}
+
+	for code, expected := range map[string]string{"ET": "", "PMF": "Myeloproliferative Neoplasms"} {
+		e := &tt.Event{}
+		setDiagnosticLevels(e, filterImplications(oncotree, code, implications), false)
+		if e.OncokbLevelDx1 != expected || e.OncokbLevelDx2 != "MPN" {
+			t.Errorf("%s: expected Dx1 %q and Dx2 MPN but got %q and %q", code, expected, e.OncokbLevelDx1, e.OncokbLevelDx2)
+		}
+	}
+	// the exclusion is honored for a code missing from the oncotree, and nothing is filtered without an oncotree
+	if filtered := filterImplications(oncotree, "XYZ", []DiagnosticImplications{{LevelOfEvidence: "LEVEL_Dx1",
+		ExcludedTumorTypes: []TumorType{{Code: "XYZ"}}}}); len(filtered) != 0 {
+		t.Errorf("expected the implication excluding XYZ to be dropped but got %v", filtered)
+	}
+	if filtered := filterImplications(nil, "ET", implications); len(filtered) != len(implications) {
+		t.Errorf("expected every implication without an oncotree but got %v", filtered)
+	}
+
+	e := &tt.Event{}
+	setDiagnosticLevels(e, implications, true)
+	if !strings.Contains(e.OncokbLevelDx1, `"excludedTumorTypes":["ET"]`) {
+		t.Errorf("expected the excluded tumor types in the detailed output but got %s", e.OncokbLevelDx1)
+	}
+}
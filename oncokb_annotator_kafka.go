@@ -0,0 +1,87 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaBroker is a Broker on a Kafka cluster.  A subscription joins the consumer group of the topic, so the
+// partitions of the topic are shared by the workers of the group, each resuming from the offsets the group committed.
+// Messages are published to the partition of their key, keeping the messages of a sample in order.
+type KafkaBroker struct {
+	brokers []string
+	writer  *kafka.Writer
+}
+
+var _ Broker = (*KafkaBroker)(nil)
+
+// NewKafkaBroker returns a KafkaBroker connecting to the bootstrap brokers, like localhost:9092.
+func NewKafkaBroker(brokers ...string) (*KafkaBroker, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("Error: no Kafka brokers")
+	}
+	return &KafkaBroker{
+		brokers: brokers,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+func (b *KafkaBroker) Publish(ctx context.Context, topic string, msg BrokerMessage) error {
+	m := kafka.Message{Topic: topic, Key: msg.Key, Value: msg.Value}
+	for k, v := range msg.Headers {
+		m.Headers = append(m.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return b.writer.WriteMessages(ctx, m)
+}
+
+func (b *KafkaBroker) Subscribe(ctx context.Context, topic, group string) (Subscription, error) {
+	if group == "" {
+		return nil, fmt.Errorf("Error: a Kafka subscription needs a consumer group")
+	}
+	// offsets are committed when Commit is called rather than in the background, so a worker that stops never
+	// skips a message it did not publish
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: b.brokers, Topic: topic, GroupID: group, CommitInterval: 0})
+	return &kafkaSubscription{reader: reader}, nil
+}
+
+// Close flushes the messages being published and closes the connections of the broker.
+func (b *KafkaBroker) Close() error {
+	return b.writer.Close()
+}
+
+type kafkaSubscription struct {
+	reader *kafka.Reader
+}
+
+func (s *kafkaSubscription) Fetch(ctx context.Context) (*BrokerMessage, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Error fetching from Kafka: %v", err)
+	}
+	msg := &BrokerMessage{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Key: m.Key, Value: m.Value}
+	if len(m.Headers) > 0 {
+		msg.Headers = make(map[string]string, len(m.Headers))
+		for _, h := range m.Headers {
+			msg.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return msg, nil
+}
+
+func (s *kafkaSubscription) Commit(ctx context.Context, msg *BrokerMessage) error {
+	return s.reader.CommitMessages(ctx, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
+
+func (s *kafkaSubscription) Close() error {
+	return s.reader.Close()
+}
@@ -0,0 +1,84 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// CorrelationIDHeader carries the correlation ID of an annotation, on the requests sent to OncoKB and on the requests
+// and responses of AnnotationServer, so one annotation can be followed across our logs and OncoKB's.
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// CorrelationIDMetadataKey carries the correlation ID in the metadata of AnnotationService calls and in the headers
+// of the broker messages of a Worker.
+const CorrelationIDMetadataKey = "x-correlation-id"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose annotations are logged, and sent to OncoKB, with the correlation ID id.
+// Annotations without one get a new correlation ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of ctx, or "" when it has none.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// ensureCorrelationID returns ctx with a correlation ID, adding a new one when it has none.
+func ensureCorrelationID(ctx context.Context) (context.Context, string) {
+	if id := CorrelationID(ctx); id != "" {
+		return ctx, id
+	}
+	id := newCorrelationID()
+	return WithCorrelationID(ctx, id), id
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLog is what the service knows about the OncoKB request being made, for its log events.
+type requestLog struct {
+	sampleID   string
+	batchIndex int
+}
+
+type requestLogKey struct{}
+
+func withRequestLog(ctx context.Context, r requestLog) context.Context {
+	return context.WithValue(ctx, requestLogKey{}, r)
+}
+
+// withBatchIndex returns ctx for the requests of batch i of the sample being annotated.
+func withBatchIndex(ctx context.Context, i int) context.Context {
+	r, _ := ctx.Value(requestLogKey{}).(requestLog)
+	r.batchIndex = i
+	return withRequestLog(ctx, r)
+}
+
+// getRequestAttrs returns the attributes every log event of an OncoKB request carries.
+func getRequestAttrs(ctx context.Context) []any {
+	r, _ := ctx.Value(requestLogKey{}).(requestLog)
+	return []any{
+		slog.String("correlation_id", CorrelationID(ctx)),
+		slog.String("sample_id", r.sampleID),
+		slog.Int("batch_index", r.batchIndex),
+	}
+}
+
+// discardHandler drops every record, it is the handler of the logger used when none is given.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
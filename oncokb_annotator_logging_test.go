@@ -0,0 +1,134 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// readLogRecords decodes the JSON log lines written to buf.
+func readLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to decode log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestServiceLogging(t *testing.T) {
+	var mu sync.Mutex
+	var correlationIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		correlationIDs = append(correlationIDs, r.Header.Get(CorrelationIDHeader))
+		mu.Unlock()
+		var requests []OncoKBMutationRequest
+		json.NewDecoder(r.Body).Decode(&requests)
+		var resp []OncoKBResponse
+		for _, req := range requests {
+			resp = append(resp, OncoKBResponse{Query: Query{ID: req.ID}, DataVersion: "v4.22"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithBatchSize(1), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	message := newVersionTestMessage()
+	message.CmoSampleId = "s-1"
+	if err := o.AnnotateMutations(WithCorrelationID(context.Background(), "run-1"), message); err != nil {
+		t.Fatalf("Failed to annotate mutations: %v", err)
+	}
+	if len(correlationIDs) != 2 || correlationIDs[0] != "run-1" || correlationIDs[1] != "run-1" {
+		t.Errorf("expected the correlation ID to be sent with both batches but got %v", correlationIDs)
+	}
+
+	batches := make(map[float64]bool)
+	messages := make(map[string]int)
+	for _, record := range readLogRecords(t, &buf) {
+		msg := record["msg"].(string)
+		messages[msg]++
+		if record["correlation_id"] != "run-1" || record["sample_id"] != "s-1" {
+			t.Errorf("expected the correlation ID and sample of every event but got %v", record)
+		}
+		if msg == "oncokb request sent" {
+			batches[record["batch_index"].(float64)] = true
+			if record["status"] != float64(http.StatusOK) || record["event_count"] != float64(1) || record["latency"] == nil {
+				t.Errorf("expected the status, event count and latency of the batch but got %v", record)
+			}
+		}
+	}
+	if messages["oncokb request built"] != 1 || messages["oncokb request sent"] != 2 || messages["oncokb response decoded"] != 2 {
+		t.Errorf("expected a build, and a send and decode per batch, but got %v", messages)
+	}
+	if !batches[0] || !batches[1] {
+		t.Errorf("expected the events of batches 0 and 1 but got %v", batches)
+	}
+
+	// a retried call without a correlation ID is given one
+	buf.Reset()
+	fake := &fakeAnnotator{failures: 1, err: &OncoKBAPIError{StatusCode: 503}}
+	if err := NewRetryingAnnotator(fake, 2, 0, WithRetryLogger(logger)).AnnotateMutations(context.Background(), newVersionTestMessage()); err != nil {
+		t.Fatalf("Failed to annotate mutations: %v", err)
+	}
+	records := readLogRecords(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "annotation retried" || records[0]["status"] != float64(503) ||
+		records[0]["attempt"] != float64(1) || records[0]["correlation_id"] == "" {
+		t.Errorf("expected the retry to be logged with its status, attempt and correlation ID but got %v", records)
+	}
+}
+
+func TestServerCorrelationID(t *testing.T) {
+	annotator := &correlationRecorder{}
+	server := httptest.NewServer(NewAnnotationServer(annotator))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/annotate", strings.NewReader(`{"cmoSampleId": "s-1"}`))
+	req.Header.Set(CorrelationIDHeader, "client-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if got := annotator.correlationID; got != "client-1" || resp.Header.Get(CorrelationIDHeader) != "client-1" {
+		t.Errorf("expected the client correlation ID to be annotated with and returned but got %q and %q",
+			annotator.correlationID, resp.Header.Get(CorrelationIDHeader))
+	}
+
+	resp, err = http.Post(server.URL+"/annotate", jsonContentType, strings.NewReader(`{"cmoSampleId": "s-1"}`))
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if got := annotator.correlationID; got == "" || got == "client-1" || resp.Header.Get(CorrelationIDHeader) != got {
+		t.Errorf("expected a new correlation ID to be returned but got %q and %q", annotator.correlationID, resp.Header.Get(CorrelationIDHeader))
+	}
+}
+
+// correlationRecorder remembers the correlation ID of the last mutations it annotated.
+type correlationRecorder struct {
+	fakeAnnotator
+	correlationID string
+}
+
+func (c *correlationRecorder) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	c.correlationID = CorrelationID(ctx)
+	return c.fakeAnnotator.AnnotateMutations(ctx, message)
+}
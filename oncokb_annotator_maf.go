@@ -0,0 +1,227 @@
+package tempo_databricks_gateway
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// maxMAFLineSize bounds the memory used for a single MAF line, long citation columns can go well past bufio's default.
+const maxMAFLineSize = 16 * 1024 * 1024
+
+// OncoKBMAFColumns are the columns MafAnnotator.py appends to a MAF, in the order it writes them.
+var OncoKBMAFColumns = []string{
+	"ANNOTATED",
+	"GENE_IN_ONCOKB",
+	"VARIANT_IN_ONCOKB",
+	"MUTATION_EFFECT",
+	"MUTATION_EFFECT_CITATIONS",
+	"ONCOGENIC",
+	"LEVEL_1",
+	"LEVEL_2",
+	"LEVEL_3A",
+	"LEVEL_3B",
+	"LEVEL_4",
+	"LEVEL_R1",
+	"LEVEL_R2",
+	"HIGHEST_LEVEL",
+	"HIGHEST_SENSITIVE_LEVEL",
+	"HIGHEST_RESISTANCE_LEVEL",
+	"TX_CITATIONS",
+	"LEVEL_Dx1",
+	"LEVEL_Dx2",
+	"LEVEL_Dx3",
+	"HIGHEST_DX_LEVEL",
+	"DX_CITATIONS",
+	"LEVEL_Px1",
+	"LEVEL_Px2",
+	"LEVEL_Px3",
+	"HIGHEST_PX_LEVEL",
+	"PX_CITATIONS",
+}
+
+// GetOncoKBMAFValues returns the OncoKB fields of an annotated event in the order of OncoKBMAFColumns.
+// Booleans are written the way Python prints them, True or False.
+func GetOncoKBMAFValues(e *tt.Event) []string {
+	fields := getOncoKBEventFields(e)
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = *f
+	}
+	for i := 0; i < 3; i++ {
+		if values[i] == "true" || values[i] == "false" {
+			values[i] = strings.ToUpper(values[i][:1]) + values[i][1:]
+		}
+	}
+	return values
+}
+
+// MAFRecord is a data line of a MAF, with the event built from its columns.
+type MAFRecord struct {
+	Fields   []string
+	SampleID string
+	Event    *tt.Event
+}
+
+// MAFReader streams the records of a MAF, resolving columns by the header names so their order does not matter.
+// Lines starting with # before the header are kept as comments.
+type MAFReader struct {
+	scanner  *bufio.Scanner
+	comments []string
+	header   []string
+	columns  map[string]int
+	line     int
+	oncokb   bool
+}
+
+// MAFReaderOption configures a MAFReader.
+type MAFReaderOption func(*MAFReader)
+
+// WithOncoKBColumns fills the OncoKB fields of the events from the OncoKB columns of an already annotated MAF.
+func WithOncoKBColumns() MAFReaderOption {
+	return func(m *MAFReader) {
+		m.oncokb = true
+	}
+}
+
+// NewMAFReader reads the comments and header of a MAF and checks the columns needed to build events are there.
+func NewMAFReader(r io.Reader, opts ...MAFReaderOption) (*MAFReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMAFLineSize)
+	m := &MAFReader{scanner: scanner}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for m.header == nil && scanner.Scan() {
+		m.line++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "#") {
+			m.comments = append(m.comments, line)
+			continue
+		}
+		m.header = strings.Split(line, "\t")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading MAF: %v", err)
+	}
+	if m.header == nil {
+		return nil, fmt.Errorf("Error reading MAF: missing header")
+	}
+	m.columns = make(map[string]int, len(m.header))
+	for i, c := range m.header {
+		m.columns[c] = i
+	}
+	for _, c := range []string{"Hugo_Symbol", "Tumor_Sample_Barcode"} {
+		if _, exists := m.columns[c]; !exists {
+			return nil, fmt.Errorf("Error reading MAF: missing required column %q", c)
+		}
+	}
+	return m, nil
+}
+
+// Header returns the column names of the MAF.
+func (m *MAFReader) Header() []string {
+	return m.header
+}
+
+// Comments returns the # lines that came before the header.
+func (m *MAFReader) Comments() []string {
+	return m.comments
+}
+
+// Read returns the next record of the MAF, or io.EOF when there are no more.  Blank lines are skipped.
+func (m *MAFReader) Read() (*MAFRecord, error) {
+	for m.scanner.Scan() {
+		m.line++
+		line := strings.TrimSuffix(m.scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) > len(m.header) {
+			return nil, fmt.Errorf("Error reading MAF line %d: %d fields but the header has %d", m.line, len(fields), len(m.header))
+		}
+		record := &MAFRecord{
+			Fields:   fields,
+			SampleID: m.get(fields, "Tumor_Sample_Barcode"),
+			Event: &tt.Event{
+				HgvspShort:            m.get(fields, "HGVSp_Short"),
+				VariantClassification: m.get(fields, "Variant_Classification"),
+				EntrezGeneId:          m.get(fields, "Entrez_Gene_Id"),
+				HugoSymbol:            m.get(fields, "Hugo_Symbol"),
+				StartPosition:         m.get(fields, "Start_Position"),
+				EndPosition:           m.get(fields, "End_Position"),
+				NcbiBuild:             m.get(fields, "NCBI_Build"),
+			},
+		}
+		if m.oncokb {
+			for i, f := range getOncoKBEventFields(record.Event) {
+				*f = m.get(fields, OncoKBMAFColumns[i])
+				if i < 3 {
+					*f = strings.ToLower(*f)
+				}
+			}
+		}
+		return record, nil
+	}
+	if err := m.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading MAF line %d: %v", m.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+func (m *MAFReader) get(fields []string, column string) string {
+	if i, exists := m.columns[column]; exists && i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+// MAFWriter writes MAF records with their OncoKB columns.  The original columns are kept in place, OncoKB columns
+// the input already had are overwritten and the others are appended in the order of OncoKBMAFColumns.
+type MAFWriter struct {
+	w       *bufio.Writer
+	columns []int
+	row     []string
+}
+
+// NewMAFWriter writes the comments and the header, the input header followed by any missing OncoKB columns.
+func NewMAFWriter(w io.Writer, header, comments []string) (*MAFWriter, error) {
+	m := &MAFWriter{w: bufio.NewWriter(w)}
+	var out []string
+	out, m.columns = getOutputColumns(header, OncoKBMAFColumns)
+	m.row = make([]string, len(out))
+	for _, c := range comments {
+		m.w.WriteString(c + "\n")
+	}
+	if _, err := m.w.WriteString(strings.Join(out, "\t") + "\n"); err != nil {
+		return nil, fmt.Errorf("Error writing MAF: %v", err)
+	}
+	return m, nil
+}
+
+// Write writes a record, filling its OncoKB columns from the annotated event.
+func (m *MAFWriter) Write(record *MAFRecord) error {
+	for i := range m.row {
+		m.row[i] = ""
+	}
+	copy(m.row, record.Fields)
+	for i, v := range GetOncoKBMAFValues(record.Event) {
+		m.row[m.columns[i]] = v
+	}
+	if _, err := m.w.WriteString(strings.Join(m.row, "\t") + "\n"); err != nil {
+		return fmt.Errorf("Error writing MAF: %v", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (m *MAFWriter) Flush() error {
+	if err := m.w.Flush(); err != nil {
+		return fmt.Errorf("Error writing MAF: %v", err)
+	}
+	return nil
+}
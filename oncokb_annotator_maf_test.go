@@ -0,0 +1,125 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestGetOncoKBMAFValues(t *testing.T) {
+	e := &tt.Event{
+		OncokbAnnotated:    "true",
+		OncokbKnownGene:    "true",
+		OncokbKnownVariant: "false",
+		OncokbOncogenic:    "Likely Oncogenic",
+		OncokbPxCitations:  "12345",
+	}
+	values := GetOncoKBMAFValues(e)
+	if len(values) != len(OncoKBMAFColumns) {
+		t.Fatalf("expected %d values but got %d", len(OncoKBMAFColumns), len(values))
+	}
+	expected := map[string]string{
+		"ANNOTATED":         "True",
+		"GENE_IN_ONCOKB":    "True",
+		"VARIANT_IN_ONCOKB": "False",
+		"ONCOGENIC":         "Likely Oncogenic",
+		"PX_CITATIONS":      "12345",
+		"LEVEL_1":           "",
+	}
+	for i, column := range OncoKBMAFColumns {
+		if want, ok := expected[column]; ok && values[i] != want {
+			t.Errorf("column %q: expected %q but got %q", column, want, values[i])
+		}
+	}
+}
+
+func TestMAFReaderAndWriter(t *testing.T) {
+	maf := "#version 2.4\r\n" +
+		"Tumor_Sample_Barcode\tHugo_Symbol\tHGVSp_Short\tONCOGENIC\tNCBI_Build\r\n" +
+		"S1\tBRAF\tp.V600E\tstale\tGRCh37\r\n" +
+		"\r\n" +
+		"S2\tKRAS\tp.G12D\r\n"
+
+	reader, err := NewMAFReader(strings.NewReader(maf))
+	if err != nil {
+		t.Fatalf("Failed to create MAFReader: %v", err)
+	}
+	if len(reader.Comments()) != 1 || reader.Comments()[0] != "#version 2.4" {
+		t.Errorf("expected the version comment but got %q", reader.Comments())
+	}
+
+	var out bytes.Buffer
+	writer, err := NewMAFWriter(&out, reader.Header(), reader.Comments())
+	if err != nil {
+		t.Fatalf("Failed to create MAFWriter: %v", err)
+	}
+	var records []*MAFRecord
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read MAF: %v", err)
+		}
+		record.Event.OncokbOncogenic = "Oncogenic"
+		if err := writer.Write(record); err != nil {
+			t.Fatalf("Failed to write MAF: %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush MAF: %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records but got %d", len(records))
+	}
+	if records[0].SampleID != "S1" || records[0].Event.HugoSymbol != "BRAF" || records[0].Event.HgvspShort != "p.V600E" ||
+		records[0].Event.NcbiBuild != "GRCh37" {
+		t.Errorf("unexpected first record %+v %+v", records[0], records[0].Event)
+	}
+	if records[1].Event.NcbiBuild != "" {
+		t.Errorf("expected a missing trailing column to be empty but got %q", records[1].Event.NcbiBuild)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 || lines[0] != "#version 2.4" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	header := strings.Split(lines[1], "\t")
+	if strings.Join(header[:5], "\t") != "Tumor_Sample_Barcode\tHugo_Symbol\tHGVSp_Short\tONCOGENIC\tNCBI_Build" {
+		t.Errorf("expected the original columns to be kept in place but got %q", header[:5])
+	}
+	if len(header) != 5+len(OncoKBMAFColumns)-1 {
+		t.Errorf("expected the missing OncoKB columns to be appended once but got %d columns", len(header))
+	}
+	for _, line := range lines[2:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(header) {
+			t.Errorf("expected %d fields but got %d in %q", len(header), len(fields), line)
+		}
+		if fields[3] != "Oncogenic" {
+			t.Errorf("expected the existing ONCOGENIC column to be overwritten but got %q", fields[3])
+		}
+	}
+}
+
+func TestMAFReaderErrors(t *testing.T) {
+	if _, err := NewMAFReader(strings.NewReader("#only a comment\n")); err == nil {
+		t.Errorf("expected an error for a MAF without a header")
+	}
+	if _, err := NewMAFReader(strings.NewReader("Hugo_Symbol\tHGVSp_Short\n")); err == nil {
+		t.Errorf("expected an error for a MAF without Tumor_Sample_Barcode")
+	}
+	reader, err := NewMAFReader(strings.NewReader("Hugo_Symbol\tTumor_Sample_Barcode\nBRAF\tS1\textra\n"))
+	if err != nil {
+		t.Fatalf("Failed to create MAFReader: %v", err)
+	}
+	if _, err := reader.Read(); err == nil {
+		t.Errorf("expected an error for a line with more fields than the header")
+	}
+}
@@ -0,0 +1,235 @@
+package tempo_databricks_gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// Snapshot is a local copy of OncoKB data used to annotate without reaching the OncoKB API.
+// It is made from recorded OncoKB responses, which carry everything, and from the
+// allAnnotatedVariants.txt OncoKB data download, which only carries oncogenicity and mutation effect.
+//
+// A query is answered by, in order of preference: a recorded response for the same normalized query,
+// a recorded response for the same gene, alteration and tumor type, the tumor type independent part of any
+// recorded response or annotated variant for the same gene and alteration, and finally a response that only
+// says whether the gene is known.
+type Snapshot struct {
+	dataVersion  string
+	lastUpdate   string
+	byQuery      map[string]OncoKBResponse
+	byTumorType  map[string]OncoKBResponse
+	byAlteration map[string]OncoKBResponse
+	genes        map[string]bool
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		byQuery:      make(map[string]OncoKBResponse),
+		byTumorType:  make(map[string]OncoKBResponse),
+		byAlteration: make(map[string]OncoKBResponse),
+		genes:        make(map[string]bool),
+	}
+}
+
+// SetDataVersion sets the data version reported for every answer.  By default each recorded
+// response keeps the version it was recorded with.
+func (s *Snapshot) SetDataVersion(dataVersion, lastUpdate string) {
+	s.dataVersion = dataVersion
+	s.lastUpdate = lastUpdate
+}
+
+// AddResponse records an OncoKB response, which is looked up by the query it carries.
+func (s *Snapshot) AddResponse(r OncoKBResponse) {
+	q := r.Query
+	s.byQuery[getQueryKey(q)] = r
+	s.byTumorType[getSnapshotKey(q.HugoSymbol, q.Alteration, q.TumorType)] = r
+	s.byAlteration[getSnapshotKey(q.HugoSymbol, q.Alteration)] = getTumorTypeIndependentResponse(r)
+	if r.GeneExist {
+		s.genes[strings.ToUpper(q.HugoSymbol)] = true
+	}
+}
+
+// LoadResponses reads recorded OncoKB responses, either as JSON arrays, like the body of an
+// OncoKB annotate response, or as one JSON response per line.
+func (s *Snapshot) LoadResponses(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Error reading OncoKB responses: %s", err)
+		}
+		var resp []OncoKBResponse
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			if err := json.Unmarshal(raw, &resp); err != nil {
+				return fmt.Errorf("Error reading OncoKB responses: %s", err)
+			}
+		} else {
+			var single OncoKBResponse
+			if err := json.Unmarshal(raw, &single); err != nil {
+				return fmt.Errorf("Error reading OncoKB responses: %s", err)
+			}
+			resp = append(resp, single)
+		}
+		for _, oncoKBResponse := range resp {
+			s.AddResponse(oncoKBResponse)
+		}
+	}
+}
+
+// LoadAnnotatedVariants reads the tab separated allAnnotatedVariants.txt OncoKB data download.
+// Annotated variants never replace a recorded response for the same gene and alteration.
+func (s *Snapshot) LoadAnnotatedVariants(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var header map[string]int
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if header == nil {
+			header = make(map[string]int, len(fields))
+			for i, f := range fields {
+				header[strings.TrimSpace(f)] = i
+			}
+			for _, required := range []string{"Hugo Symbol", "Alteration", "Oncogenicity", "Mutation Effect"} {
+				if _, exists := header[required]; !exists {
+					return fmt.Errorf("Annotated variants file is missing column %q", required)
+				}
+			}
+			continue
+		}
+		get := func(column string) string {
+			if i, exists := header[column]; exists && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		gene, alteration := get("Hugo Symbol"), get("Alteration")
+		s.genes[strings.ToUpper(gene)] = true
+		key := getSnapshotKey(gene, alteration)
+		if _, exists := s.byAlteration[key]; exists {
+			continue
+		}
+		var pmids []string
+		for _, pmid := range strings.Split(get("PMIDs for Mutation Effect"), ",") {
+			if pmid = strings.TrimSpace(pmid); len(pmid) > 0 {
+				pmids = append(pmids, pmid)
+			}
+		}
+		s.byAlteration[key] = OncoKBResponse{
+			GeneExist:      true,
+			VariantExist:   true,
+			Oncogenic:      get("Oncogenicity"),
+			MutationEffect: MutationEffect{KnownEffect: get("Mutation Effect"), Citations: Citations{Pmids: pmids}},
+			Query:          Query{HugoSymbol: gene, Alteration: alteration},
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading annotated variants: %s", err)
+	}
+	return nil
+}
+
+// Respond answers requests like the OncoKB annotate mutations endpoint would.
+func (s *Snapshot) Respond(ctx context.Context, requests []OncoKBMutationRequest) ([]OncoKBResponse, error) {
+	return s.respond(ctx, "", toOncoKBRequests(requests))
+}
+
+func (s *Snapshot) respond(ctx context.Context, _ string, requests []oncoKBRequest) ([]OncoKBResponse, error) {
+	oncoKBResponse := make([]OncoKBResponse, 0, len(requests))
+	for _, req := range requests {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		q := req.query()
+		resp := s.lookup(q)
+		resp.Query.ID = q.ID
+		if len(s.dataVersion) > 0 {
+			resp.DataVersion = s.dataVersion
+			resp.LastUpdate = s.lastUpdate
+		}
+		oncoKBResponse = append(oncoKBResponse, resp)
+	}
+	return oncoKBResponse, nil
+}
+
+func (s *Snapshot) lookup(q Query) OncoKBResponse {
+	if resp, exists := s.byQuery[getQueryKey(q)]; exists {
+		return resp
+	}
+	if resp, exists := s.byTumorType[getSnapshotKey(q.HugoSymbol, q.Alteration, q.TumorType)]; exists {
+		return resp
+	}
+	if resp, exists := s.byAlteration[getSnapshotKey(q.HugoSymbol, q.Alteration)]; exists {
+		return resp
+	}
+	return OncoKBResponse{
+		GeneExist:      s.genes[strings.ToUpper(q.HugoSymbol)],
+		Oncogenic:      "Unknown",
+		MutationEffect: MutationEffect{KnownEffect: "Unknown"},
+		Query:          q,
+	}
+}
+
+func getSnapshotKey(fields ...string) string {
+	return strings.ToUpper(strings.Join(fields, "|"))
+}
+
+// getTumorTypeIndependentResponse drops everything from a response that depends on the tumor type it was asked for.
+func getTumorTypeIndependentResponse(r OncoKBResponse) OncoKBResponse {
+	return OncoKBResponse{
+		AlleleExist:    r.AlleleExist,
+		DataVersion:    r.DataVersion,
+		GeneExist:      r.GeneExist,
+		GeneSummary:    r.GeneSummary,
+		Hotspot:        r.Hotspot,
+		LastUpdate:     r.LastUpdate,
+		MutationEffect: r.MutationEffect,
+		Oncogenic:      r.Oncogenic,
+		Query:          r.Query,
+		VariantExist:   r.VariantExist,
+		VariantSummary: r.VariantSummary,
+		Vus:            r.Vus,
+	}
+}
+
+// OfflineAnnotator annotates from a Snapshot instead of the OncoKB API.  It fills in the same event fields,
+// the same way, as OncoKBAnnotatorService and takes the same options.
+type OfflineAnnotator struct {
+	service *OncoKBAnnotatorService
+}
+
+func NewOfflineAnnotator(snapshot *Snapshot, opts ...Option) (*OfflineAnnotator, error) {
+	if snapshot == nil {
+		return nil, fmt.Errorf("A snapshot is needed to annotate offline")
+	}
+	o := &OncoKBAnnotatorService{
+		// this is the query type used when annotating the nightly clinical IMPACT files
+		byProteinChange:   true,
+		responder:         snapshot.respond,
+		citationFormatter: PythonCitationFormatter{},
+		dataVersion:       newDataVersionTracker(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &OfflineAnnotator{service: o}, nil
+}
+
+func (a *OfflineAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return a.service.AnnotateMutations(ctx, message)
+}
+
+func (a *OfflineAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return a.service.AnnotateCopyNumberAlterations(ctx, message)
+}
+
+func (a *OfflineAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return a.service.AnnotateStructuralVariants(ctx, message)
+}
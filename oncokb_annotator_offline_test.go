@@ -0,0 +1,102 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+const testRecordedResponse = `{
+	"query": {"id": "0", "hugoSymbol": "CHEK2", "alteration": "S428F", "consequence": "missense_variant", "tumorType": "CCRCC", "referenceGenome": "GRCh37"},
+	"dataVersion": "v4.22", "lastUpdate": "01/01/2025",
+	"geneExist": true, "variantExist": true, "oncogenic": "Likely Oncogenic",
+	"mutationEffect": {"knownEffect": "Likely Loss-of-function", "citations": {"pmids": ["15649950", "16998506"]}},
+	"treatments": [
+		{"level": "LEVEL_3B", "drugs": [{"drugName": "Olaparib"}], "pmids": ["32343890"]},
+		{"level": "LEVEL_3B", "drugs": [{"drugName": "Talazoparib"}, {"drugName": "Enzalutamide"}], "pmids": ["37285865"]}
+	],
+	"highestSensitiveLevel": "LEVEL_3B"
+}`
+
+const testAnnotatedVariants = "Isoform\tRefSeq\tEntrez Gene ID\tHugo Symbol\tAlteration\tProtein Change\tOncogenicity\tMutation Effect\tPMIDs for Mutation Effect\tAbstracts for Mutation Effect\n" +
+	"ENST00000288602\tNM_004333.4\t673\tBRAF\tV600E\tV600E\tOncogenic\tGain-of-function\t12068308, 19251651\t\n"
+
+func getTestEventFields(e *tt.Event) []string {
+	return []string{e.OncokbAnnotated, e.OncokbKnownGene, e.OncokbKnownVariant, e.OncokbMutationEffect,
+		e.OncokbMutationEffectCitations, e.OncokbOncogenic, e.OncokbLevel3B, e.OncokbHighestLevel,
+		e.OncokbHighestSensitivityLevel, e.OncokbTxCitations}
+}
+
+func TestOfflineAnnotatorMatchesService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[" + testRecordedResponse + "]"))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	newMessage := func() *tt.TempoMessage {
+		return &tt.TempoMessage{
+			OncotreeCode: "CCRCC",
+			Events: []*tt.Event{
+				&tt.Event{HugoSymbol: "CHEK2", HgvspShort: "p.S428F", VariantClassification: "Missense_Mutation", NcbiBuild: "GRCh37"},
+			},
+		}
+	}
+
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange")
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	online := newMessage()
+	if err := o.AnnotateMutations(ctx, online); err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+
+	snapshot := NewSnapshot()
+	if err := snapshot.LoadResponses(strings.NewReader(testRecordedResponse)); err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	a, err := NewOfflineAnnotator(snapshot)
+	if err != nil {
+		t.Fatalf("Failed to create an OfflineAnnotator: %v", err)
+	}
+	offline := newMessage()
+	if err := a.AnnotateMutations(ctx, offline); err != nil {
+		t.Fatalf("Failed to annotate message offline: %v", err)
+	}
+
+	expected, _ := json.Marshal(getTestEventFields(online.Events[0]))
+	got, _ := json.Marshal(getTestEventFields(offline.Events[0]))
+	if string(expected) != string(got) || online.OncokbDataVersion != offline.OncokbDataVersion {
+		t.Errorf("expected %s but got %s", expected, got)
+	}
+}
+
+func TestSnapshotAnnotatedVariants(t *testing.T) {
+	snapshot := NewSnapshot()
+	if err := snapshot.LoadAnnotatedVariants(strings.NewReader(testAnnotatedVariants)); err != nil {
+		t.Fatalf("Failed to load annotated variants: %v", err)
+	}
+	resp, err := snapshot.Respond(context.Background(), []OncoKBMutationRequest{
+		OncoKBMutationRequest{ID: "0", Gene: Gene{HugoSymbol: "BRAF"}, Alteration: "V600E", TumorType: "MEL"},
+		OncoKBMutationRequest{ID: "1", Gene: Gene{HugoSymbol: "BRAF"}, Alteration: "X1_splice", TumorType: "MEL"},
+		OncoKBMutationRequest{ID: "2", Gene: Gene{HugoSymbol: "RP11-66N11.8"}, Alteration: "X1_splice", TumorType: "LUAD"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to respond: %v", err)
+	}
+	if resp[0].Oncogenic != "Oncogenic" || resp[0].MutationEffect.KnownEffect != "Gain-of-function" || len(resp[0].MutationEffect.Citations.Pmids) != 2 {
+		t.Errorf("unexpected response for BRAF V600E: %+v", resp[0])
+	}
+	if !resp[1].GeneExist || resp[1].VariantExist || resp[1].Oncogenic != "Unknown" || resp[1].Query.ID != "1" {
+		t.Errorf("unexpected response for unknown BRAF variant: %+v", resp[1])
+	}
+	if resp[2].GeneExist {
+		t.Errorf("unexpected response for unknown gene: %+v", resp[2])
+	}
+}
@@ -0,0 +1,57 @@
+package tempo_databricks_gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OncotreeNode is a tumor type as returned by the oncotree API (https://oncotree.mskcc.org/api/tumorTypes).
+type OncotreeNode struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	MainType string `json:"mainType"`
+	Parent   string `json:"parent"`
+}
+
+// Oncotree is the oncotree hierarchy, keyed by upper cased code.
+type Oncotree struct {
+	nodes map[string]OncotreeNode
+}
+
+// NewOncotree builds an Oncotree from its tumor types.
+func NewOncotree(nodes []OncotreeNode) *Oncotree {
+	oncotree := &Oncotree{nodes: make(map[string]OncotreeNode, len(nodes))}
+	for _, n := range nodes {
+		oncotree.nodes[strings.ToUpper(n.Code)] = n
+	}
+	return oncotree
+}
+
+// LoadOncotree reads the JSON returned by the oncotree API tumorTypes endpoint.
+func LoadOncotree(r io.Reader) (*Oncotree, error) {
+	var nodes []OncotreeNode
+	if err := json.NewDecoder(r).Decode(&nodes); err != nil {
+		return nil, fmt.Errorf("Error reading oncotree: %s", err)
+	}
+	return NewOncotree(nodes), nil
+}
+
+func (o *Oncotree) Contains(code string) bool {
+	_, exists := o.nodes[strings.ToUpper(code)]
+	return exists
+}
+
+// Ancestors returns the tumor type of code followed by each of its parents up to the root.
+func (o *Oncotree) Ancestors(code string) []OncotreeNode {
+	var ancestors []OncotreeNode
+	visited := make(map[string]bool)
+	code = strings.ToUpper(code)
+	for node, exists := o.nodes[code]; exists && !visited[code]; node, exists = o.nodes[code] {
+		visited[code] = true
+		ancestors = append(ancestors, node)
+		code = strings.ToUpper(node.Parent)
+	}
+	return ancestors
+}
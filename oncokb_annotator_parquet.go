@@ -0,0 +1,228 @@
+package tempo_databricks_gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// ParquetEventRow is the schema of the Parquet export, one row per annotated event.  Columns are only ever added
+// to the end of it, never renamed, retyped or removed, so tables built from earlier exports keep loading.
+//
+// The export is partitioned Hive style by OncoKB data version and run date,
+//
+//	<dir>/oncokb_data_version=v4.22/run_date=2025-04-02/part-<id>.parquet
+//
+// and, as Spark and Delta expect, the partition columns are only in the paths and not in the files.  Messages that
+// were not annotated go to the oncokb_data_version=__HIVE_DEFAULT_PARTITION__ partition.  To load the export:
+//
+//	CONVERT TO DELTA parquet.`<dir>` PARTITIONED BY (oncokb_data_version STRING, run_date DATE)
+type ParquetEventRow struct {
+	// sample-level fields of the TempoMessage, repeated on each of its events
+	SampleID        string `parquet:"sample_id"`
+	NormalSampleID  string `parquet:"normal_sample_id"`
+	PipelineVersion string `parquet:"pipeline_version"`
+	OncotreeCode    string `parquet:"oncotree_code"`
+	// EventIndex is the position of the event in the message, which with SampleID identifies the event
+	EventIndex int32 `parquet:"event_index"`
+
+	HugoSymbol            string `parquet:"hugo_symbol"`
+	EntrezGeneID          string `parquet:"entrez_gene_id"`
+	NcbiBuild             string `parquet:"ncbi_build"`
+	StartPosition         string `parquet:"start_position"`
+	EndPosition           string `parquet:"end_position"`
+	VariantClassification string `parquet:"variant_classification"`
+	HgvspShort            string `parquet:"hgvsp_short"`
+
+	// the OncoKB fields, named after the OncoKBMAFColumns
+	Annotated               string `parquet:"oncokb_annotated"`
+	GeneInOncoKB            string `parquet:"oncokb_gene_in_oncokb"`
+	VariantInOncoKB         string `parquet:"oncokb_variant_in_oncokb"`
+	MutationEffect          string `parquet:"oncokb_mutation_effect"`
+	MutationEffectCitations string `parquet:"oncokb_mutation_effect_citations"`
+	Oncogenic               string `parquet:"oncokb_oncogenic"`
+	Level1                  string `parquet:"oncokb_level_1"`
+	Level2                  string `parquet:"oncokb_level_2"`
+	Level3A                 string `parquet:"oncokb_level_3a"`
+	Level3B                 string `parquet:"oncokb_level_3b"`
+	Level4                  string `parquet:"oncokb_level_4"`
+	LevelR1                 string `parquet:"oncokb_level_r1"`
+	LevelR2                 string `parquet:"oncokb_level_r2"`
+	HighestLevel            string `parquet:"oncokb_highest_level"`
+	HighestSensitiveLevel   string `parquet:"oncokb_highest_sensitive_level"`
+	HighestResistanceLevel  string `parquet:"oncokb_highest_resistance_level"`
+	TxCitations             string `parquet:"oncokb_tx_citations"`
+	LevelDx1                string `parquet:"oncokb_level_dx1"`
+	LevelDx2                string `parquet:"oncokb_level_dx2"`
+	LevelDx3                string `parquet:"oncokb_level_dx3"`
+	HighestDxLevel          string `parquet:"oncokb_highest_dx_level"`
+	DxCitations             string `parquet:"oncokb_dx_citations"`
+	LevelPx1                string `parquet:"oncokb_level_px1"`
+	LevelPx2                string `parquet:"oncokb_level_px2"`
+	LevelPx3                string `parquet:"oncokb_level_px3"`
+	HighestPxLevel          string `parquet:"oncokb_highest_px_level"`
+	PxCitations             string `parquet:"oncokb_px_citations"`
+}
+
+const (
+	hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+	parquetRowGroupSize  = 100000
+)
+
+// GetParquetEventRows flattens a TempoMessage into a row per event.
+func GetParquetEventRows(message *tt.TempoMessage) []ParquetEventRow {
+	rows := make([]ParquetEventRow, len(message.Events))
+	for i, e := range message.Events {
+		rows[i] = ParquetEventRow{
+			SampleID:                message.CmoSampleId,
+			NormalSampleID:          message.NormalCmoSampleId,
+			PipelineVersion:         message.PipelineVersion,
+			OncotreeCode:            message.OncotreeCode,
+			EventIndex:              int32(i),
+			HugoSymbol:              e.HugoSymbol,
+			EntrezGeneID:            e.EntrezGeneId,
+			NcbiBuild:               e.NcbiBuild,
+			StartPosition:           e.StartPosition,
+			EndPosition:             e.EndPosition,
+			VariantClassification:   e.VariantClassification,
+			HgvspShort:              e.HgvspShort,
+			Annotated:               e.OncokbAnnotated,
+			GeneInOncoKB:            e.OncokbKnownGene,
+			VariantInOncoKB:         e.OncokbKnownVariant,
+			MutationEffect:          e.OncokbMutationEffect,
+			MutationEffectCitations: e.OncokbMutationEffectCitations,
+			Oncogenic:               e.OncokbOncogenic,
+			Level1:                  e.OncokbLevel1,
+			Level2:                  e.OncokbLevel2,
+			Level3A:                 e.OncokbLevel3A,
+			Level3B:                 e.OncokbLevel3B,
+			Level4:                  e.OncokbLevel4,
+			LevelR1:                 e.OncokbLevelR1,
+			LevelR2:                 e.OncokbLevelR2,
+			HighestLevel:            e.OncokbHighestLevel,
+			HighestSensitiveLevel:   e.OncokbHighestSensitivityLevel,
+			HighestResistanceLevel:  e.OncokbHighestResistanceLevel,
+			TxCitations:             e.OncokbTxCitations,
+			LevelDx1:                e.OncokbLevelDx1,
+			LevelDx2:                e.OncokbLevelDx2,
+			LevelDx3:                e.OncokbLevelDx3,
+			HighestDxLevel:          e.OncokbHighestDxLevel,
+			DxCitations:             e.OncokbDxCitations,
+			LevelPx1:                e.OncokbLevelPx1,
+			LevelPx2:                e.OncokbLevelPx2,
+			LevelPx3:                e.OncokbLevelPx3,
+			HighestPxLevel:          e.OncokbHighestPxLevel,
+			PxCitations:             e.OncokbPxCitations,
+		}
+	}
+	return rows
+}
+
+// ParquetWriter exports annotated TempoMessages to a directory of Parquet files partitioned by OncoKB data version
+// and run date, see ParquetEventRow.  A writer adds one file to each partition it writes to, so several writers
+// can export to the same directory.  Files are written under a hidden name, which Spark and Databricks skip, and
+// Close renames them once they are finished, so readers never see a partial file.
+type ParquetWriter struct {
+	dir     string
+	runDate string
+	id      string
+	files   map[string]*parquetPartitionFile
+}
+
+type parquetPartitionFile struct {
+	f      *os.File
+	writer *parquet.GenericWriter[ParquetEventRow]
+	path   string
+}
+
+// NewParquetWriter exports to dir, putting the rows in the run_date partition of runDate.
+func NewParquetWriter(dir string, runDate time.Time) (*ParquetWriter, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("Error creating Parquet file id: %v", err)
+	}
+	return &ParquetWriter{
+		dir:     dir,
+		runDate: runDate.Format(time.DateOnly),
+		id:      hex.EncodeToString(id),
+		files:   make(map[string]*parquetPartitionFile),
+	}, nil
+}
+
+// Write adds the events of a message to the partition of its data version.
+func (p *ParquetWriter) Write(message *tt.TempoMessage) error {
+	if len(message.Events) == 0 {
+		return nil
+	}
+	version := message.OncokbDataVersion
+	if version == "" {
+		version = hiveDefaultPartition
+	}
+	file, exists := p.files[version]
+	if !exists {
+		partition := filepath.Join(p.dir, "oncokb_data_version="+url.PathEscape(version), "run_date="+p.runDate)
+		if err := os.MkdirAll(partition, 0o755); err != nil {
+			return fmt.Errorf("Error creating Parquet partition: %v", err)
+		}
+		path := filepath.Join(partition, "part-"+p.id+".parquet")
+		f, err := os.Create(getParquetTempPath(path))
+		if err != nil {
+			return fmt.Errorf("Error creating Parquet file: %v", err)
+		}
+		file = &parquetPartitionFile{
+			f:    f,
+			path: path,
+			writer: parquet.NewGenericWriter[ParquetEventRow](f,
+				parquet.Compression(&parquet.Snappy),
+				parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		}
+		p.files[version] = file
+	}
+	if _, err := file.writer.Write(GetParquetEventRows(message)); err != nil {
+		return fmt.Errorf("Error writing Parquet file: %v", err)
+	}
+	return nil
+}
+
+// Close finishes every file the writer started and moves it into its partition.  A file that cannot be finished is
+// removed rather than left partial.
+func (p *ParquetWriter) Close() error {
+	var firstErr error
+	for _, file := range p.files {
+		if err := file.close(); err != nil {
+			os.Remove(file.f.Name())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	p.files = nil
+	return firstErr
+}
+
+func (file *parquetPartitionFile) close() error {
+	if err := file.writer.Close(); err != nil {
+		file.f.Close()
+		return fmt.Errorf("Error finishing Parquet file: %v", err)
+	}
+	if err := file.f.Close(); err != nil {
+		return fmt.Errorf("Error closing Parquet file: %v", err)
+	}
+	if err := os.Rename(file.f.Name(), file.path); err != nil {
+		return fmt.Errorf("Error moving Parquet file into its partition: %v", err)
+	}
+	return nil
+}
+
+// getParquetTempPath returns the name a Parquet file is written under until it is finished, readers skip files
+// starting with a dot.
+func getParquetTempPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
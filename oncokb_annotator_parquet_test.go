@@ -0,0 +1,102 @@
+package tempo_databricks_gateway
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestParquetWriter(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewParquetWriter(dir, time.Date(2025, 4, 2, 15, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to create Parquet writer: %v", err)
+	}
+	messages := []*tt.TempoMessage{
+		{CmoSampleId: "S1", OncotreeCode: "IDC", OncokbDataVersion: "v4.22", Events: []*tt.Event{
+			{HugoSymbol: "BRCA2", HgvspShort: "p.H52Qfs*16", OncokbAnnotated: "true", OncokbOncogenic: "Likely Oncogenic"},
+			{HugoSymbol: "TP53", HgvspShort: "p.R273H", OncokbAnnotated: "true", OncokbHighestLevel: "LEVEL_1"},
+		}},
+		{CmoSampleId: "S2", OncotreeCode: "CCRCC", OncokbDataVersion: "v4.22", Events: []*tt.Event{{HugoSymbol: "CHEK2", HgvspShort: "p.S428F"}}},
+		{CmoSampleId: "S3", OncotreeCode: "LUAD", Events: []*tt.Event{{HugoSymbol: "EGFR"}}},
+		{CmoSampleId: "S4", OncotreeCode: "LUAD", OncokbDataVersion: "v4.22"},
+	}
+	for _, m := range messages {
+		if err := writer.Write(m); err != nil {
+			t.Fatalf("Failed to write %s: %v", m.CmoSampleId, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "part-*")); len(files) != 0 {
+		t.Errorf("expected no visible Parquet files before Close but got %v", files)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close Parquet writer: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*", ".*")); len(files) != 0 {
+		t.Errorf("expected Close to move every file into its partition but got %v", files)
+	}
+
+	partitions := map[string][]string{
+		"oncokb_data_version=v4.22/run_date=2025-04-02":                      {"S1", "S1", "S2"},
+		"oncokb_data_version=__HIVE_DEFAULT_PARTITION__/run_date=2025-04-02": {"S3"},
+	}
+	for partition, samples := range partitions {
+		files, err := filepath.Glob(filepath.Join(dir, partition, "part-*.parquet"))
+		if err != nil || len(files) != 1 {
+			t.Fatalf("expected a file in %s but got %v (%v)", partition, files, err)
+		}
+		rows, err := parquet.ReadFile[ParquetEventRow](files[0])
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", files[0], err)
+		}
+		if len(rows) != len(samples) {
+			t.Fatalf("%s: expected %d rows but got %d", partition, len(samples), len(rows))
+		}
+		for i, row := range rows {
+			if row.SampleID != samples[i] {
+				t.Errorf("%s: expected sample %s in row %d but got %s", partition, samples[i], i, row.SampleID)
+			}
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != len(partitions) {
+		t.Errorf("expected %d data versions but got %v (%v)", len(partitions), entries, err)
+	}
+
+	rows, _ := parquet.ReadFile[ParquetEventRow](mustGlob(t, filepath.Join(dir, "oncokb_data_version=v4.22/*/*.parquet")))
+	if rows[1].EventIndex != 1 || rows[1].HugoSymbol != "TP53" || rows[1].HighestLevel != "LEVEL_1" || rows[1].OncotreeCode != "IDC" {
+		t.Errorf("expected the TP53 event of S1 but got %+v", rows[1])
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	// the export schema may only grow at the end, so tables built from earlier exports keep loading
+	want := []string{"sample_id", "normal_sample_id", "pipeline_version", "oncotree_code", "event_index",
+		"hugo_symbol", "entrez_gene_id", "ncbi_build", "start_position", "end_position", "variant_classification", "hgvsp_short"}
+	for _, column := range OncoKBMAFColumns {
+		want = append(want, "oncokb_"+strings.ToLower(column))
+	}
+	fields := parquet.SchemaOf(ParquetEventRow{}).Fields()
+	if len(fields) < len(want) {
+		t.Fatalf("expected at least %d columns but got %d", len(want), len(fields))
+	}
+	for i, name := range want {
+		if fields[i].Name() != name {
+			t.Errorf("expected column %d to be %s but got %s", i, name, fields[i].Name())
+		}
+	}
+}
+
+func mustGlob(t *testing.T, pattern string) string {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a file matching %s but got %v (%v)", pattern, files, err)
+	}
+	return files[0]
+}
@@ -0,0 +1,213 @@
+package tempo_databricks_gateway
+
+import (
+	"strconv"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// oncoKBRequest is implemented by the request types of the OncoKB annotate endpoints.
+// query returns the fields of the request the way OncoKB echoes them back in OncoKBResponse.Query.
+type oncoKBRequest interface {
+	query() Query
+}
+
+func (r OncoKBMutationRequest) query() Query {
+	return Query{
+		Alteration:      r.Alteration,
+		Consequence:     r.Consequence,
+		EntrezGeneID:    r.Gene.EntrezGeneID,
+		HugoSymbol:      r.Gene.HugoSymbol,
+		ID:              r.ID,
+		ProteinEnd:      r.ProteinEnd,
+		ProteinStart:    r.ProteinStart,
+		ReferenceGenome: r.ReferenceGenome,
+		TumorType:       r.TumorType,
+	}
+}
+
+func (r OncoKBCopyNumberAlterationRequest) query() Query {
+	return Query{
+		Alteration:      getCopyNumberAlterationName(r.CopyNameAlterationType),
+		AlterationType:  "COPY_NUMBER_ALTERATION",
+		EntrezGeneID:    r.Gene.EntrezGeneID,
+		HugoSymbol:      r.Gene.HugoSymbol,
+		ID:              r.ID,
+		ReferenceGenome: r.ReferenceGenome,
+		TumorType:       r.TumorType,
+	}
+}
+
+func (r OncoKBStructuralVariantRequest) query() Query {
+	alteration := r.GeneA.HugoSymbol + "-" + r.GeneB.HugoSymbol
+	if r.FunctionalFusion {
+		alteration += " Fusion"
+	}
+	return Query{
+		Alteration:      alteration,
+		AlterationType:  "STRUCTURAL_VARIANT",
+		EntrezGeneID:    r.GeneA.EntrezGeneID,
+		HugoSymbol:      r.GeneA.HugoSymbol,
+		ID:              r.ID,
+		ReferenceGenome: r.ReferenceGenome,
+		SvType:          r.StructuralVariantType,
+		TumorType:       r.TumorType,
+	}
+}
+
+func toOncoKBRequests[T oncoKBRequest](requests []T) []oncoKBRequest {
+	toReturn := make([]oncoKBRequest, len(requests))
+	for i, r := range requests {
+		toReturn[i] = r
+	}
+	return toReturn
+}
+
+// getAnnotateURL finds the URL of another OncoKB annotate endpoint from the mutation annotate URL,
+// e.g. https://www.oncokb.org/api/v1/annotate/copyNumberAlterations from
+// https://www.oncokb.org/api/v1/annotate/mutations/byProteinChange.
+func getAnnotateURL(oncokbURL, endpoint string) string {
+	ind := strings.Index(oncokbURL, "/annotate/")
+	if ind < 0 {
+		return ""
+	}
+	return oncokbURL[:ind] + "/annotate/" + endpoint
+}
+
+// getInfoURL returns the OncoKB /info endpoint of the API an annotate endpoint belongs to.
+func getInfoURL(oncokbURL string) string {
+	ind := strings.Index(oncokbURL, "/annotate/")
+	if ind < 0 {
+		return ""
+	}
+	return oncokbURL[:ind] + "/info"
+}
+
+// eventType is the kind of alteration an event describes, it decides which OncoKB endpoint annotates the event.
+type eventType int
+
+const (
+	mutationEvent eventType = iota
+	copyNumberAlterationEvent
+	structuralVariantEvent
+)
+
+// getEventType returns the type of ev from its VariantClassification.  Every classification names one event type:
+// Deletion is a copy number deletion and Fusion a mutation consequence, the structural variants of those types are
+// written SV_Deletion and SV_Fusion.  Classifications that are not copy number alterations or structural variants
+// are mutations.
+func getEventType(ev *tt.Event) eventType {
+	vc := strings.ToLower(ev.VariantClassification)
+	if _, ok := variantClassToCopyNumberAlteration[vc]; ok {
+		return copyNumberAlterationEvent
+	}
+	if _, ok := variantClassToStructuralVariant[vc]; ok {
+		return structuralVariantEvent
+	}
+	return mutationEvent
+}
+
+var variantClassToCopyNumberAlteration = map[string]string{
+	"amplification": "AMPLIFICATION",
+	"amp":           "AMPLIFICATION",
+	"2":             "AMPLIFICATION",
+	"deletion":      "DELETION",
+	"homdel":        "DELETION",
+	"del":           "DELETION",
+	"-2":            "DELETION",
+	"gain":          "GAIN",
+	"1":             "GAIN",
+	"loss":          "LOSS",
+	"hetloss":       "LOSS",
+	"-1":            "LOSS",
+}
+
+func getCopyNumberAlterationName(copyNumberAlterationType string) string {
+	if len(copyNumberAlterationType) == 0 {
+		return ""
+	}
+	return copyNumberAlterationType[:1] + strings.ToLower(copyNumberAlterationType[1:])
+}
+
+// getOncoKBCopyNumberAlterationRequests builds a request for every event whose VariantClassification is a
+// copy number alteration, as named or as a discrete copy number (2, 1, -1, -2).  Other events are skipped.
+func getOncoKBCopyNumberAlterationRequests(message *tt.TempoMessage) ([]OncoKBCopyNumberAlterationRequest, error) {
+	var requests []OncoKBCopyNumberAlterationRequest
+	for lc, ev := range message.Events {
+		cnaType, ok := variantClassToCopyNumberAlteration[strings.ToLower(ev.VariantClassification)]
+		if !ok {
+			continue
+		}
+		requests = append(requests, OncoKBCopyNumberAlterationRequest{
+			CopyNameAlterationType: cnaType,
+			Gene:                   getEventGene(ev.HugoSymbol, ev.EntrezGeneId),
+			ID:                     strconv.Itoa(lc),
+			ReferenceGenome:        ev.NcbiBuild,
+			TumorType:              message.OncotreeCode,
+		})
+	}
+	return requests, nil
+}
+
+var variantClassToStructuralVariant = map[string]string{
+	"sv_fusion":        "FUSION",
+	"sv_translocation": "TRANSLOCATION",
+	"translocation":    "TRANSLOCATION",
+	"sv_inversion":     "INVERSION",
+	"inversion":        "INVERSION",
+	"sv_duplication":   "DUPLICATION",
+	"duplication":      "DUPLICATION",
+	"sv_insertion":     "INSERTION",
+	"insertion":        "INSERTION",
+	"sv_deletion":      "DELETION",
+	"sv":               "UNKNOWN",
+}
+
+// getOncoKBStructuralVariantRequests builds a request for every event whose VariantClassification is a
+// structural variant type.  The partner genes come from HugoSymbol, as GENEA::GENEB, and an intragenic variant
+// only names one gene.  Other events are skipped.
+func getOncoKBStructuralVariantRequests(message *tt.TempoMessage) ([]OncoKBStructuralVariantRequest, error) {
+	var requests []OncoKBStructuralVariantRequest
+	for lc, ev := range message.Events {
+		svType, ok := variantClassToStructuralVariant[strings.ToLower(ev.VariantClassification)]
+		if !ok {
+			continue
+		}
+		geneA, geneB := getStructuralVariantGenes(ev.HugoSymbol)
+		request := OncoKBStructuralVariantRequest{
+			FunctionalFusion:      svType == "FUSION",
+			GeneA:                 Gene{HugoSymbol: geneA},
+			GeneB:                 Gene{HugoSymbol: geneB},
+			ID:                    strconv.Itoa(lc),
+			ReferenceGenome:       ev.NcbiBuild,
+			StructuralVariantType: svType,
+			TumorType:             message.OncotreeCode,
+		}
+		if geneA == geneB {
+			request.GeneA = getEventGene(ev.HugoSymbol, ev.EntrezGeneId)
+			request.GeneB = request.GeneA
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// getStructuralVariantGenes splits the partners of a structural variant on "::", which unlike "-" cannot appear
+// in a gene name such as NKX2-1 or HLA-A.
+func getStructuralVariantGenes(hugoSymbol string) (string, string) {
+	if geneA, geneB, ok := strings.Cut(hugoSymbol, "::"); ok {
+		return geneA, geneB
+	}
+	return hugoSymbol, hugoSymbol
+}
+
+// getEventGene prefers the hugo symbol and only falls back to the entrez gene id when it is missing,
+// like the mutation requests.
+func getEventGene(hugoSymbol, entrezGeneID string) Gene {
+	var gID int
+	if len(hugoSymbol) == 0 {
+		gID, _ = strconv.Atoi(entrezGeneID) // this should be an integer in protobuf def
+	}
+	return Gene{EntrezGeneID: gID, HugoSymbol: hugoSymbol}
+}
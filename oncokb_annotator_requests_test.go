@@ -0,0 +1,84 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestAnnotateCopyNumberAlterationsAndStructuralVariants(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Errorf("Failed to decode OncoKB request: %v", err)
+		}
+		var resp []OncoKBResponse
+		for _, req := range requests {
+			oncogenic := ""
+			switch r.URL.Path {
+			case "/api/v1/annotate/copyNumberAlterations":
+				oncogenic = req["copyNameAlterationType"].(string)
+			case "/api/v1/annotate/mutations/byProteinChange":
+				oncogenic = req["alteration"].(string)
+			case "/api/v1/annotate/structuralVariants":
+				oncogenic = req["structuralVariantType"].(string) + " " + req["geneA"].(map[string]any)["hugoSymbol"].(string) + " " + req["geneB"].(map[string]any)["hugoSymbol"].(string)
+			}
+			resp = append(resp, OncoKBResponse{Query: Query{ID: req["id"].(string)}, Oncogenic: oncogenic, DataVersion: "v4.22"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/api/v1/annotate/mutations/byProteinChange")
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	tm := &tt.TempoMessage{
+		OncotreeCode: "LUAD",
+		Events: []*tt.Event{
+			&tt.Event{HugoSymbol: "ERBB2", VariantClassification: "Amplification"},
+			&tt.Event{HugoSymbol: "EML4::ALK", VariantClassification: "SV_Fusion"},
+			&tt.Event{HugoSymbol: "CDKN2A", VariantClassification: "-2"},
+			&tt.Event{HugoSymbol: "PTEN", VariantClassification: "Deletion"},
+			&tt.Event{HugoSymbol: "NKX2-1::HLA-A", VariantClassification: "SV_Deletion"},
+			&tt.Event{HugoSymbol: "KRAS", VariantClassification: "Missense_Mutation", HgvspShort: "p.G12D"},
+		},
+	}
+	ctx := context.Background()
+	// the copy number alterations and structural variants of the message are skipped rather than rejected
+	if err := o.AnnotateMutations(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate mutations: %v", err)
+	}
+	if err := o.AnnotateCopyNumberAlterations(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate copy number alterations: %v", err)
+	}
+	if err := o.AnnotateStructuralVariants(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate structural variants: %v", err)
+	}
+	expected := []string{"AMPLIFICATION", "FUSION EML4 ALK", "DELETION", "DELETION", "DELETION NKX2-1 HLA-A", "G12D"}
+	for i, e := range tm.Events {
+		if e.OncokbOncogenic != expected[i] {
+			t.Errorf("event %d: expected %q but got %q", i, expected[i], e.OncokbOncogenic)
+		}
+	}
+}
+
+func TestEventTypes(t *testing.T) {
+	for vc := range variantClassToCopyNumberAlteration {
+		if _, ok := variantClassToStructuralVariant[vc]; ok {
+			t.Errorf("%q is both a copy number alteration and a structural variant", vc)
+		}
+		if _, ok := variantClassToConsequence[vc]; ok {
+			t.Errorf("%q is both a copy number alteration and a mutation", vc)
+		}
+	}
+	for vc := range variantClassToStructuralVariant {
+		if _, ok := variantClassToConsequence[vc]; ok {
+			t.Errorf("%q is both a structural variant and a mutation", vc)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	protobufContentType = "application/x-protobuf"
+	jsonContentType     = "application/json"
+
+	defaultMaxRequestSize = 32 * 1024 * 1024
+	idleClientTimeout     = 10 * time.Minute
+	// maxClientBuckets bounds the memory of the request limits, clients past it share one bucket until others go idle
+	maxClientBuckets = 10000
+)
+
+// AnnotationServer serves OncoKB annotation of TempoMessages over HTTP, so clients do not need an OncoKB token:
+//
+//	POST /annotate  annotates a TempoMessage sent as protojson or protobuf, and returns it in the same encoding
+//	GET  /healthz   reports the server is up
+//	GET  /readyz    reports the server is ready to annotate
+//
+// /annotate runs the mutation annotation unless the types query parameter lists others, like types=mutations,cna,sv.
+type AnnotationServer struct {
+	annotator      Annotator
+	limiter        *clientLimiter
+	readinessCheck func(context.Context) error
+	maxRequestSize int64
+	logger         *slog.Logger
+	notReady       atomic.Bool
+	mux            *http.ServeMux
+}
+
+// ServerOption configures an AnnotationServer.
+type ServerOption func(*AnnotationServer)
+
+// WithClientRateLimit limits each client to rate requests per second on average, with bursts of up to burst requests.
+// Clients over the limit get a 429.  A client is the subject of its verified TLS certificate, or else its remote
+// address, never something the request claims about itself.
+func WithClientRateLimit(rate float64, burst int) ServerOption {
+	return func(s *AnnotationServer) {
+		s.limiter = newClientLimiter(rate, burst, time.Now)
+	}
+}
+
+// WithReadinessCheck adds a check to /readyz, like making sure OncoKB can be reached.
+func WithReadinessCheck(check func(context.Context) error) ServerOption {
+	return func(s *AnnotationServer) {
+		s.readinessCheck = check
+	}
+}
+
+// WithMaxRequestSize bounds the size of the messages /annotate accepts, 32MB by default.
+func WithMaxRequestSize(size int64) ServerOption {
+	return func(s *AnnotationServer) {
+		s.maxRequestSize = size
+	}
+}
+
+// WithServerLogger logs every /annotate request to logger, with its correlation ID, client, sample, status and
+// duration.
+func WithServerLogger(logger *slog.Logger) ServerOption {
+	return func(s *AnnotationServer) {
+		s.logger = logger
+	}
+}
+
+// NewAnnotationServer returns a server annotating with annotator.
+func NewAnnotationServer(annotator Annotator, opts ...ServerOption) *AnnotationServer {
+	s := &AnnotationServer{annotator: annotator, maxRequestSize: defaultMaxRequestSize, logger: discardLogger, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger == nil {
+		s.logger = discardLogger
+	}
+	s.mux.HandleFunc("POST /annotate", s.handleAnnotate)
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+	return s
+}
+
+// SetReady marks the server ready or not, a server shutting down should stop being ready before it stops serving.
+func (s *AnnotationServer) SetReady(ready bool) {
+	s.notReady.Store(!ready)
+}
+
+func (s *AnnotationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *AnnotationServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.notReady.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	if s.readinessCheck != nil {
+		if err := s.readinessCheck(r.Context()); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *AnnotationServer) handleAnnotate(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(CorrelationIDHeader)
+	if id == "" {
+		id = newCorrelationID()
+	}
+	w.Header().Set(CorrelationIDHeader, id)
+	ctx := WithCorrelationID(r.Context(), id)
+
+	start := time.Now()
+	client := getClientID(r)
+	message := &tt.TempoMessage{}
+	status := http.StatusOK
+	var errMessage string
+	fail := func(code int, err string) {
+		status, errMessage = code, err
+		writeError(w, code, err)
+	}
+	defer func() {
+		attrs := []any{
+			slog.String("correlation_id", id),
+			slog.String("client", client),
+			slog.String("sample_id", message.CmoSampleId),
+			slog.Int("event_count", len(message.Events)),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+		}
+		level := slog.LevelInfo
+		if errMessage != "" {
+			attrs = append(attrs, slog.String("error", errMessage))
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelWarn
+			}
+		}
+		s.logger.Log(ctx, level, "annotation request", attrs...)
+	}()
+
+	if s.limiter != nil {
+		if wait := s.limiter.reserve(client); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			fail(http.StatusTooManyRequests, "Error: request limit exceeded")
+			return
+		}
+	}
+
+	types := r.URL.Query().Get("types")
+	if types == "" {
+		types = "mutations"
+	}
+	annotate, err := ParseAnnotationTypes(types)
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	isProtobuf := false
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		isProtobuf = mediaType == protobufContentType
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxRequestSize))
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		fail(code, "Error reading request: "+err.Error())
+		return
+	}
+	if isProtobuf {
+		err = proto.Unmarshal(body, message)
+	} else {
+		err = protojson.Unmarshal(body, message)
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, "Error decoding TempoMessage: "+err.Error())
+		return
+	}
+
+	for _, a := range annotate {
+		if err := a(s.annotator, ctx, message); err != nil {
+			fail(getErrorStatus(err), err.Error())
+			return
+		}
+	}
+
+	var resp []byte
+	if isProtobuf {
+		w.Header().Set("Content-Type", protobufContentType)
+		resp, err = proto.Marshal(message)
+	} else {
+		w.Header().Set("Content-Type", jsonContentType)
+		resp, err = protojson.Marshal(message)
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, "Error encoding TempoMessage: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// getErrorStatus maps an annotation error to a response status.  Problems with the events of the message are the
+// client's, problems reaching or with OncoKB are a bad gateway, and anything else is the server's.
+func getErrorStatus(err error) int {
+	var apiErr *OncoKBAPIError
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests:
+		return http.StatusServiceUnavailable
+	case errors.As(err, &apiErr) || isRetryable(err):
+		return http.StatusBadGateway
+	}
+	var unmatched *UnmatchedResponseError
+	var missing *MissingResponseError
+	var duplicate *DuplicateResponseError
+	var skew *VersionSkewError
+	if errors.As(err, &unmatched) || errors.As(err, &missing) || errors.As(err, &duplicate) || errors.As(err, &skew) {
+		return http.StatusBadGateway
+	}
+	var invalid *InvalidMessageError
+	if errors.As(err, &invalid) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// getClientID returns who made the request, for its request limits.
+func getClientID(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// clientLimiter is a token bucket per client.  Buckets of clients idle for longer than idleClientTimeout are dropped.
+// Once there are maxClients buckets, new clients share the overflow bucket.
+type clientLimiter struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	maxClients int
+	buckets    map[string]*tokenBucket
+	now        func() time.Time
+	lastSweep  time.Time
+}
+
+// overflowClient is the client of the bucket shared by the clients past maxClients, client IDs are never empty.
+const overflowClient = ""
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newClientLimiter(rate float64, burst int, now func() time.Time) *clientLimiter {
+	return &clientLimiter{rate: rate, burst: float64(max(burst, 1)), maxClients: maxClientBuckets,
+		buckets: make(map[string]*tokenBucket), now: now, lastSweep: now()}
+}
+
+// reserve takes a token from the bucket of client, returning zero if it had one or how long until it will.
+func (l *clientLimiter) reserve(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > idleClientTimeout {
+		for c, b := range l.buckets {
+			if now.Sub(b.last) > idleClientTimeout {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, exists := l.buckets[client]
+	if !exists && len(l.buckets) >= l.maxClients {
+		client = overflowClient
+		b, exists = l.buckets[client]
+	}
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	if l.rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,220 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAnnotationServer(t *testing.T) {
+	annotator := &fakeAnnotator{}
+	server := httptest.NewServer(NewAnnotationServer(annotator))
+	defer server.Close()
+
+	message := &tt.TempoMessage{CmoSampleId: "S1", Events: []*tt.Event{{HugoSymbol: "BRAF", HgvspShort: "p.V600E"}}}
+	body, _ := proto.Marshal(message)
+	resp, err := http.Post(server.URL+"/annotate", protobufContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post protobuf: %v", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != protobufContentType {
+		t.Fatalf("expected a 200 protobuf response but got %d %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
+	}
+	annotated := &tt.TempoMessage{}
+	if err := proto.Unmarshal(respBody, annotated); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if annotated.Events[0].OncokbAnnotated != "true" {
+		t.Errorf("expected the returned event to be annotated but got %v", annotated.Events[0])
+	}
+
+	body, _ = protojson.Marshal(message)
+	resp, err = http.Post(server.URL+"/annotate?types=mutations,cna", jsonContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post JSON: %v", err)
+	}
+	respBody, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := protojson.Unmarshal(respBody, annotated); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a 200 protojson response but got %d: %s", resp.StatusCode, respBody)
+	}
+	if annotator.calls != 3 {
+		t.Errorf("expected the mutation and copy number annotations to run but got %d calls", annotator.calls)
+	}
+
+	for _, test := range []struct {
+		url    string
+		body   string
+		status int
+	}{
+		{"/annotate", "{not json", http.StatusBadRequest},
+		{"/annotate?types=expression", "{}", http.StatusBadRequest},
+	} {
+		resp, err := http.Post(server.URL+test.url, jsonContentType, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("Failed to post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %q: expected %d but got %d", test.url, test.body, test.status, resp.StatusCode)
+		}
+	}
+
+	annotator.err = &OncoKBAPIError{StatusCode: http.StatusInternalServerError}
+	annotator.failures = annotator.calls + 1
+	resp, err = http.Post(server.URL+"/annotate", jsonContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected an OncoKB error to be a bad gateway but got %d", resp.StatusCode)
+	}
+
+	for _, test := range []struct {
+		err    error
+		status int
+	}{
+		{&InvalidMessageError{Err: errors.New("An unknown variant classification has been encountered: Exotic")}, http.StatusBadRequest},
+		{errors.New("unexpected"), http.StatusInternalServerError},
+	} {
+		annotator.err = test.err
+		annotator.failures = annotator.calls + 1
+		resp, err = http.Post(server.URL+"/annotate", jsonContentType, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%v: expected %d but got %d", test.err, test.status, resp.StatusCode)
+		}
+	}
+
+	small := httptest.NewServer(NewAnnotationServer(annotator, WithMaxRequestSize(8)))
+	defer small.Close()
+	resp, err = http.Post(small.URL+"/annotate", jsonContentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a message over the size limit to be too large but got %d", resp.StatusCode)
+	}
+}
+
+func TestAnnotationServerLog(t *testing.T) {
+	var log bytes.Buffer
+	annotator := &fakeAnnotator{}
+	server := NewAnnotationServer(annotator, WithServerLogger(slog.New(slog.NewJSONHandler(&log, nil))))
+	annotate := func(body string) map[string]any {
+		log.Reset()
+		r := httptest.NewRequest(http.MethodPost, "/annotate", strings.NewReader(body))
+		r.Header.Set(CorrelationIDHeader, "c-1")
+		server.ServeHTTP(httptest.NewRecorder(), r)
+		var entry map[string]any
+		if err := json.Unmarshal(log.Bytes(), &entry); err != nil {
+			t.Fatalf("expected a log entry for the request but got %q: %v", log.String(), err)
+		}
+		return entry
+	}
+
+	entry := annotate(`{"cmoSampleId": "S1", "events": [{"hugoSymbol": "BRAF"}]}`)
+	if entry["msg"] != "annotation request" || entry["correlation_id"] != "c-1" || entry["sample_id"] != "S1" ||
+		entry["event_count"] != 1.0 || entry["status"] != 200.0 || entry["level"] != "INFO" || entry["error"] != nil {
+		t.Errorf("expected the request to be logged but got %v", entry)
+	}
+
+	annotator.err = errors.New("unexpected")
+	annotator.failures = annotator.calls + 1
+	entry = annotate(`{"cmoSampleId": "S2"}`)
+	if entry["status"] != 500.0 || entry["level"] != "WARN" || entry["error"] != "unexpected" {
+		t.Errorf("expected the failed request to be logged as a warning but got %v", entry)
+	}
+}
+
+func TestAnnotationServerReadiness(t *testing.T) {
+	var checkErr error
+	s := NewAnnotationServer(&fakeAnnotator{}, WithReadinessCheck(func(context.Context) error { return checkErr }))
+	getStatus := func(path string) int {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if getStatus("/healthz") != http.StatusOK || getStatus("/readyz") != http.StatusOK {
+		t.Errorf("expected the server to be healthy and ready")
+	}
+	checkErr = errors.New("OncoKB is unreachable")
+	if getStatus("/readyz") != http.StatusServiceUnavailable {
+		t.Errorf("expected a failing readiness check to make the server not ready")
+	}
+	checkErr = nil
+	s.SetReady(false)
+	if getStatus("/readyz") != http.StatusServiceUnavailable || getStatus("/healthz") != http.StatusOK {
+		t.Errorf("expected a server shutting down to be healthy but not ready")
+	}
+}
+
+func TestClientLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newClientLimiter(1, 2, func() time.Time { return now })
+	if limiter.reserve("a") != 0 || limiter.reserve("a") != 0 {
+		t.Errorf("expected a client to be allowed its burst")
+	}
+	if wait := limiter.reserve("a"); wait != time.Second {
+		t.Errorf("expected to wait a second after the burst but got %v", wait)
+	}
+	if limiter.reserve("b") != 0 {
+		t.Errorf("expected clients to be limited separately")
+	}
+	now = now.Add(time.Second)
+	if limiter.reserve("a") != 0 {
+		t.Errorf("expected a token after a second")
+	}
+	now = now.Add(time.Hour)
+	limiter.reserve("c")
+	if _, exists := limiter.buckets["a"]; exists {
+		t.Errorf("expected idle clients to be dropped")
+	}
+	limiter.maxClients = 2
+	limiter.reserve("d")
+	if limiter.reserve("e") != 0 || limiter.reserve("f") != 0 || limiter.reserve("g") == 0 {
+		t.Errorf("expected the clients past the limit to share a bucket")
+	}
+	if len(limiter.buckets) != 3 {
+		t.Errorf("expected two client buckets and the overflow bucket but got %d", len(limiter.buckets))
+	}
+
+	server := httptest.NewServer(NewAnnotationServer(&fakeAnnotator{}, WithClientRateLimit(0.001, 1)))
+	defer server.Close()
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/annotate", strings.NewReader("{}"))
+		// a client cannot get its own limit by naming itself
+		req.Header.Set("X-Client-ID", "team-"+strconv.Itoa(i))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: expected %d but got %d", i+1, want, resp.StatusCode)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Errorf("expected a Retry-After header")
+		}
+	}
+}
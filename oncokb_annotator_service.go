@@ -4,55 +4,518 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
 )
 
 type OncoKBAnnotatorService struct {
-	pat       string
-	oncokbURL string
+	tokens               TokenProvider
+	oncokbURL            string
+	infoURL              string
+	cnaURL               string
+	svURL                string
+	byProteinChange      bool
+	responder            func(context.Context, string, []oncoKBRequest) ([]OncoKBResponse, error)
+	citationFormatter    CitationFormatter
+	dedupCitations       bool
+	detailedImplications bool
+	oncotree             *Oncotree
+	batchSize            int
+	versionSkewPolicy    VersionSkewPolicy
+	dataVersion          *dataVersionTracker
+	cache                AnnotationCache
+	httpClient           *http.Client
+	concurrency          int
+	requestLimiter       *clientLimiter
+	consequences         map[string][]string
+	logger               *slog.Logger
 }
 
-func NewOncoKBAnnotatorService(token, oncokbURL string) (*OncoKBAnnotatorService, error) {
-	if len(token) == 0 || len(oncokbURL) == 0 {
-		return nil, fmt.Errorf("Both token: %q and oncokbURL: %q need to be valid", token, oncokbURL)
+// Option configures optional behavior of an OncoKBAnnotatorService.
+type Option func(*OncoKBAnnotatorService)
+
+// WithCitationFormatter sets how citations are written to the *_CITATIONS event fields.
+// The default is PythonCitationFormatter.
+func WithCitationFormatter(formatter CitationFormatter) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.citationFormatter = formatter
+	}
+}
+
+// WithCitationDeduplication only writes a citation to the first of the Tx, Dx and Px citation fields it appears in.
+// By default citations are only deduplicated within a field, like MafAnnotator.py.
+func WithCitationDeduplication() Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.dedupCitations = true
+	}
+}
+
+// WithDetailedImplications writes each LEVEL_Dx* and LEVEL_Px* event field as a JSON array of Implication,
+// keeping the tumor type name, main type, alterations and description, instead of a comma separated list of tumor types.
+func WithDetailedImplications() Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.detailedImplications = true
+	}
+}
+
+// WithOncotreeFiltering drops diagnostic and prognostic implications whose tumor type is neither
+// the sample's OncotreeCode nor one of its ancestors in oncotree.
+func WithOncotreeFiltering(oncotree *Oncotree) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.oncotree = oncotree
+	}
+}
+
+// WithBatchSize limits the number of events sent to OncoKB in a single request.
+// By default all the events of a message are sent together.
+func WithBatchSize(batchSize int) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.batchSize = batchSize
+	}
+}
+
+// WithVersionSkewPolicy sets what happens when OncoKB responses disagree on the data version.
+// The default is VersionSkewFail.
+func WithVersionSkewPolicy(policy VersionSkewPolicy) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.versionSkewPolicy = policy
+	}
+}
+
+// WithDataVersionTTL sets how long the data version OncoKB last returned is trusted for cached responses, 10 minutes by
+// default.  After that the version is checked with OncoKB's /info before the cache is used again, so a new OncoKB data
+// release is noticed even when every query is a cache hit.
+func WithDataVersionTTL(ttl time.Duration) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.dataVersion.ttl = ttl
+	}
+}
+
+// WithCache answers repeated queries from cache instead of asking OncoKB again.
+// Only responses for the data version OncoKB is currently returning are used.
+func WithCache(cache AnnotationCache) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.cache = cache
+	}
+}
+
+// WithHTTPClient sends the OncoKB requests with client, e.g. to record or replay OncoKB traffic with oncokbtest.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.httpClient = client
 	}
-	return &OncoKBAnnotatorService{pat: token, oncokbURL: oncokbURL}, nil
+}
+
+// WithConcurrency sends up to n batches of a message to OncoKB at once.  By default batches are sent one at a time.
+func WithConcurrency(n int) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.concurrency = n
+	}
+}
+
+// WithRequestRate limits the requests sent to OncoKB to rate per second on average, with bursts of up to burst
+// requests, waiting for the limit rather than failing.  Every call of the service shares the limit.
+func WithRequestRate(rate float64, burst int) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.requestLimiter = newClientLimiter(rate, burst, time.Now)
+	}
+}
+
+// WithConsequenceOverrides maps variant classifications, matched without regard to case, to the OncoKB consequences
+// they are queried with, in addition to or instead of the MafAnnotator.py mapping.
+func WithConsequenceOverrides(overrides map[string][]string) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.consequences = make(map[string][]string, len(overrides))
+		for variantClass, consequences := range overrides {
+			o.consequences[strings.ToLower(variantClass)] = consequences
+		}
+	}
+}
+
+// WithLogger logs the building, sending, retrying and decoding of OncoKB requests to logger, with the correlation ID,
+// sample, batch, event count, HTTP status and latency of the request.  Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *OncoKBAnnotatorService) {
+		o.logger = logger
+	}
+}
+
+// NewOncoKBAnnotatorService returns a service annotating with the OncoKB API at oncokbURL, authenticating with the
+// token of tokens.  The token is fetched for each request, never at construction, so it can be rotated.
+func NewOncoKBAnnotatorService(tokens TokenProvider, oncokbURL string, opts ...Option) (*OncoKBAnnotatorService, error) {
+	if tokens == nil || len(oncokbURL) == 0 {
+		return nil, fmt.Errorf("Both a token provider and oncokbURL: %q need to be valid", oncokbURL)
+	}
+	o := &OncoKBAnnotatorService{
+		tokens:            tokens,
+		oncokbURL:         oncokbURL,
+		cnaURL:            getAnnotateURL(oncokbURL, "copyNumberAlterations"),
+		svURL:             getAnnotateURL(oncokbURL, "structuralVariants"),
+		infoURL:           getInfoURL(oncokbURL),
+		byProteinChange:   strings.Contains(oncokbURL, "byProteinChange"),
+		citationFormatter: PythonCitationFormatter{},
+		dataVersion:       newDataVersionTracker(),
+		logger:            discardLogger,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o, nil
 }
 
 func (o OncoKBAnnotatorService) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
 	// we need to strip p. from change
-	jsonData, err := getOncoKBRequestJSON(strings.Contains(o.oncokbURL, "byProteinChange"), message)
+	requests, err := getOncoKBRequests(o.byProteinChange, o.consequences, message)
+	if err != nil {
+		return o.requestBuildError(ctx, message, err)
+	}
+	return o.annotate(ctx, o.oncokbURL, message, toOncoKBRequests(requests))
+}
+
+// AnnotateCopyNumberAlterations annotates events whose VariantClassification is a copy number alteration,
+// such as Amplification or Deletion, using the OncoKB copyNumberAlterations endpoint.
+func (o OncoKBAnnotatorService) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	if len(o.cnaURL) == 0 && o.responder == nil {
+		return fmt.Errorf("Cannot find the OncoKB copy number alteration endpoint from %q", o.oncokbURL)
+	}
+	requests, err := getOncoKBCopyNumberAlterationRequests(message)
+	if err != nil {
+		return o.requestBuildError(ctx, message, err)
+	}
+	return o.annotate(ctx, o.cnaURL, message, toOncoKBRequests(requests))
+}
+
+// AnnotateStructuralVariants annotates events whose HugoSymbol names the two partners of a structural variant,
+// such as EML4::ALK, using the OncoKB structuralVariants endpoint.
+func (o OncoKBAnnotatorService) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	if len(o.svURL) == 0 && o.responder == nil {
+		return fmt.Errorf("Cannot find the OncoKB structural variant endpoint from %q", o.oncokbURL)
+	}
+	requests, err := getOncoKBStructuralVariantRequests(message)
+	if err != nil {
+		return o.requestBuildError(ctx, message, err)
+	}
+	return o.annotate(ctx, o.svURL, message, toOncoKBRequests(requests))
+}
+
+// requestBuildError logs and returns the error of building the OncoKB requests of message.
+func (o OncoKBAnnotatorService) requestBuildError(ctx context.Context, message *tt.TempoMessage, err error) error {
+	ctx, _ = ensureCorrelationID(ctx)
+	ctx = withRequestLog(ctx, requestLog{sampleID: message.CmoSampleId})
+	o.log().WarnContext(ctx, "oncokb request build failed", append(getRequestAttrs(ctx),
+		slog.Int("event_count", len(message.Events)), slog.String("error", err.Error()))...)
+	return &InvalidMessageError{Err: err}
+}
+
+func (o OncoKBAnnotatorService) annotate(ctx context.Context, url string, message *tt.TempoMessage, requests []oncoKBRequest) error {
+	ctx, _ = ensureCorrelationID(ctx)
+	ctx = withRequestLog(ctx, requestLog{sampleID: message.CmoSampleId})
+	o.log().DebugContext(ctx, "oncokb request built", append(getRequestAttrs(ctx),
+		slog.Int("event_count", len(requests)), slog.String("url", url))...)
+	oncoKBResponse, err := o.getConsistentResponses(ctx, url, requests)
+	if err != nil {
+		return err
+	}
+
+	setOncoKBDataVersion(message, oncoKBResponse)
+	return o.mapResponseToEvents(message.Events, requests, oncoKBResponse)
+}
+
+// getCachedResponses answers the requests it can from the cache, for the data version of the run,
+// and gets the rest from OncoKB, adding their responses to the cache.
+func (o OncoKBAnnotatorService) getCachedResponses(ctx context.Context, url string, requests []oncoKBRequest) ([]OncoKBResponse, error) {
+	if o.cache == nil {
+		return o.getResponses(ctx, url, requests)
+	}
+	version, pinned, current := o.dataVersion.get()
+	if !current {
+		var err error
+		if version, pinned, err = o.revalidateDataVersion(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var oncoKBResponse []OncoKBResponse
+	var misses []oncoKBRequest
+	missKeys := make(map[string]string)
+	for _, req := range requests {
+		q := req.query()
+		key := getQueryKey(q)
+		if pinned {
+			if resp, hit := o.cache.Get(version.DataVersion, key); hit {
+				resp.Query.ID = q.ID
+				oncoKBResponse = append(oncoKBResponse, resp)
+				continue
+			}
+		}
+		misses = append(misses, req)
+		missKeys[q.ID] = key
+	}
+	if len(misses) == 0 {
+		return oncoKBResponse, nil
+	}
+	missResponse, err := o.getResponses(ctx, url, misses)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range missResponse {
+		if key, exists := missKeys[r.Query.ID]; exists {
+			o.cache.Put(r.DataVersion, key, r)
+		}
+	}
+	return append(oncoKBResponse, missResponse...), nil
+}
+
+// revalidateDataVersion asks OncoKB's /info for its current data version, pins it, and returns it.  When OncoKB cannot
+// be asked the version is not pinned, and the requests all go to OncoKB, whose responses pin it instead.
+func (o OncoKBAnnotatorService) revalidateDataVersion(ctx context.Context) (OncoKBDataVersion, bool, error) {
+	if len(o.infoURL) == 0 || o.responder != nil {
+		return OncoKBDataVersion{}, false, nil
+	}
+	info, err := o.getInfo(ctx)
+	if err != nil {
+		o.log().WarnContext(ctx, "oncokb data version check failed", slog.String("correlation_id", CorrelationID(ctx)),
+			slog.String("error", err.Error()))
+		return OncoKBDataVersion{}, false, nil
+	}
+	version := OncoKBDataVersion{DataVersion: info.DataVersion.Version}
+	if err := o.dataVersion.check(version, o.versionSkewPolicy == VersionSkewReannotate); err != nil {
+		return OncoKBDataVersion{}, false, err
+	}
+	version, pinned, _ := o.dataVersion.get()
+	return version, pinned, nil
+}
+
+// CheckReady asks OncoKB's /info for its data version, so it fails when OncoKB cannot be reached or rejects the token.
+// The data version is checked against the one of the run, as revalidateDataVersion does.
+func (o OncoKBAnnotatorService) CheckReady(ctx context.Context) error {
+	if len(o.infoURL) == 0 || o.responder != nil {
+		return nil
+	}
+	info, err := o.getInfo(ctx)
+	var apiErr *OncoKBAPIError
+	if invalidator, ok := o.tokens.(TokenInvalidator); ok && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		// the token may have been rotated since the provider fetched it
+		invalidator.InvalidateToken()
+		info, err = o.getInfo(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return o.dataVersion.check(OncoKBDataVersion{DataVersion: info.DataVersion.Version}, o.versionSkewPolicy == VersionSkewReannotate)
+}
+
+// oncoKBInfo is the part of the OncoKB /info response the service uses.
+type oncoKBInfo struct {
+	DataVersion struct {
+		Version string `json:"version"`
+		Date    string `json:"date"`
+	} `json:"dataVersion"`
+}
+
+func (o OncoKBAnnotatorService) getInfo(ctx context.Context) (oncoKBInfo, error) {
+	var info oncoKBInfo
+	if err := o.waitForRequestLimit(ctx); err != nil {
+		return info, err
+	}
+	token, err := o.tokens.Token(ctx)
+	if err != nil {
+		return info, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.infoURL, nil)
 	if err != nil {
-		return fmt.Errorf("Error creating OncoKB request body %s", err)
+		return info, fmt.Errorf("Error creating http request: %s", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, o.oncokbURL, bytes.NewBuffer(jsonData))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if id := CorrelationID(ctx); id != "" {
+		req.Header.Set(CorrelationIDHeader, id)
+	}
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Error creating http request: %s", err)
+		return info, fmt.Errorf("Error getting OncoKB info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, &OncoKBAPIError{StatusCode: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("Error reading OncoKB info: %v", err)
+	}
+	if len(info.DataVersion.Version) == 0 {
+		return info, fmt.Errorf("Error reading OncoKB info: no data version")
+	}
+	return info, nil
+}
+
+// getResponses posts the requests to OncoKB, batchSize requests at a time and up to concurrency batches at once.
+func (o OncoKBAnnotatorService) getResponses(ctx context.Context, url string, requests []oncoKBRequest) ([]OncoKBResponse, error) {
+	batchSize := o.batchSize
+	if batchSize <= 0 {
+		batchSize = len(requests)
+	}
+	respond := o.postRequests
+	if o.responder != nil {
+		respond = o.responder
+	}
+	var batches [][]oncoKBRequest
+	for start := 0; start < len(requests); start += batchSize {
+		batches = append(batches, requests[start:min(start+batchSize, len(requests))])
+	}
+	batchResponses := make([][]OncoKBResponse, len(batches))
+	if o.concurrency <= 1 || len(batches) <= 1 {
+		for i, batch := range batches {
+			batchResponse, err := respond(withBatchIndex(ctx, i), url, batch)
+			if err != nil {
+				return nil, err
+			}
+			batchResponses[i] = batchResponse
+		}
+	} else {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sem := make(chan struct{}, o.concurrency)
+		errs := make([]error, len(batches))
+		var wg sync.WaitGroup
+		for i, batch := range batches {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				if batchResponses[i], errs[i] = respond(withBatchIndex(ctx, i), url, batch); errs[i] != nil {
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+		// the first batch to fail in order, rather than the cancellation it caused in later batches
+		for _, err := range errs {
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return nil, err
+			}
+		}
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	var oncoKBResponse []OncoKBResponse
+	for _, batchResponse := range batchResponses {
+		oncoKBResponse = append(oncoKBResponse, batchResponse...)
+	}
+	return oncoKBResponse, nil
+}
+
+func (o OncoKBAnnotatorService) postRequests(ctx context.Context, url string, requests []oncoKBRequest) ([]OncoKBResponse, error) {
+	jsonData, err := json.Marshal(requests)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating OncoKB request body %s", err)
+	}
+	resp, err := o.post(ctx, url, jsonData, len(requests))
+	var apiErr *OncoKBAPIError
+	if invalidator, ok := o.tokens.(TokenInvalidator); ok && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		// the token may have been rotated since the provider fetched it
+		o.log().WarnContext(ctx, "oncokb request retried", append(getRequestAttrs(ctx),
+			slog.Int("event_count", len(requests)), slog.Int("status", apiErr.StatusCode), slog.String("reason", "token rejected"))...)
+		invalidator.InvalidateToken()
+		resp, err = o.post(ctx, url, jsonData, len(requests))
+	}
+	return resp, err
+}
+
+// post sends one batch of eventCount requests to OncoKB, logging the exchange.
+func (o OncoKBAnnotatorService) post(ctx context.Context, url string, jsonData []byte, eventCount int) ([]OncoKBResponse, error) {
+	if err := o.waitForRequestLimit(ctx); err != nil {
+		return nil, err
+	}
+	token, err := o.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating http request: %s", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.pat))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	if id := CorrelationID(ctx); id != "" {
+		req.Header.Set(CorrelationIDHeader, id)
+	}
 
-	client := &http.Client{}
+	client := o.httpClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	attrs := append(getRequestAttrs(ctx), slog.Int("event_count", eventCount))
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Error creating http client: %s", err)
+		o.log().WarnContext(ctx, "oncokb request failed", append(attrs,
+			slog.Duration("latency", time.Since(start)), slog.String("error", err.Error()))...)
+		return nil, fmt.Errorf("Error creating http client: %w", err)
 	}
+	attrs = append(attrs, slog.Int("status", resp.StatusCode), slog.Duration("latency", time.Since(start)))
+	level := slog.LevelDebug
+	if resp.StatusCode != http.StatusOK {
+		level = slog.LevelWarn
+	}
+	o.log().Log(ctx, level, "oncokb request sent", attrs...)
 
 	oncoKBResponse, err := getOncoKBResponse(resp)
+	var apiErr *OncoKBAPIError
+	if errors.As(err, &apiErr) {
+		apiErr.Message = redactToken(apiErr.Message, token)
+		return nil, err
+	}
 	if err != nil {
-		return err
+		o.log().ErrorContext(ctx, "oncokb response decode failed", append(attrs, slog.String("error", err.Error()))...)
+		return nil, err
 	}
+	dataVersion := ""
+	if len(oncoKBResponse) > 0 {
+		dataVersion = oncoKBResponse[0].DataVersion
+	}
+	o.log().DebugContext(ctx, "oncokb response decoded", append(attrs,
+		slog.Int("response_count", len(oncoKBResponse)), slog.String("oncokb_data_version", dataVersion))...)
+	return oncoKBResponse, nil
+}
 
-	setOncoKBDataVersion(message, oncoKBResponse)
-	mapResponseToEvents(message.Events, oncoKBResponse)
+// log returns the logger of the service, which logs nothing for a service not made by NewOncoKBAnnotatorService.
+func (o OncoKBAnnotatorService) log() *slog.Logger {
+	if o.logger == nil {
+		return discardLogger
+	}
+	return o.logger
+}
 
-	return nil
+// waitForRequestLimit blocks until the request rate allows another OncoKB request.
+func (o OncoKBAnnotatorService) waitForRequestLimit(ctx context.Context) error {
+	if o.requestLimiter == nil {
+		return nil
+	}
+	for {
+		wait := o.requestLimiter.reserve("")
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
 var variantClassToConsequence = map[string][]string{
@@ -98,18 +561,26 @@ var variantClassToConsequence = map[string][]string{
 	"viii deletion":           []string{"any"},
 }
 
-func getOncoKBRequestJSON(byProteinChangeURL bool, message *tt.TempoMessage) ([]byte, error) {
+func getOncoKBRequests(byProteinChangeURL bool, consequenceOverrides map[string][]string, message *tt.TempoMessage) ([]OncoKBMutationRequest, error) {
 	var oncoKBMutations []OncoKBMutationRequest
 	var proteinStart, proteinEnd int
 	var err error
 	for lc, ev := range message.Events {
+		// copy number alterations and structural variants are left to their own endpoints
+		if getEventType(ev) != mutationEvent {
+			continue
+		}
 		var gID int
 		if len(ev.HugoSymbol) == 0 {
 			gID, _ = strconv.Atoi(ev.EntrezGeneId) // this should be an integer in protobuf def
 		}
 		eIndex := strconv.Itoa(lc)
 		var consequence string
-		if consList, ok := variantClassToConsequence[strings.ToLower(ev.VariantClassification)]; !ok {
+		consList, ok := consequenceOverrides[strings.ToLower(ev.VariantClassification)]
+		if !ok {
+			consList, ok = variantClassToConsequence[strings.ToLower(ev.VariantClassification)]
+		}
+		if !ok {
 			return nil, fmt.Errorf("An unknown variant classification has been encountered: %s", ev.VariantClassification)
 		} else {
 			consequence = strings.Join(consList, "+")
@@ -147,11 +618,7 @@ func getOncoKBRequestJSON(byProteinChangeURL bool, message *tt.TempoMessage) ([]
 		}
 		oncoKBMutations = append(oncoKBMutations, request)
 	}
-	jsonData, err := json.Marshal(oncoKBMutations)
-	if err != nil {
-		return nil, err
-	}
-	return jsonData, nil
+	return oncoKBMutations, nil
 }
 
 func getOncoKBResponse(resp *http.Response) ([]OncoKBResponse, error) {
@@ -161,9 +628,9 @@ func getOncoKBResponse(resp *http.Response) ([]OncoKBResponse, error) {
 	if resp.StatusCode != http.StatusOK {
 		errResp, err := unMarshal[OncoKBErrorResponse](string(body))
 		if err != nil {
-			return nil, fmt.Errorf("Error making OncoKB API request: %d", resp.StatusCode)
+			return nil, &OncoKBAPIError{StatusCode: resp.StatusCode}
 		}
-		return nil, fmt.Errorf("Error making OncoKB API request: %s", errResp.Message)
+		return nil, &OncoKBAPIError{StatusCode: resp.StatusCode, Message: errResp.Message}
 	}
 
 	toReturn, err := unMarshal[[]OncoKBResponse](string(body))
@@ -174,40 +641,82 @@ func getOncoKBResponse(resp *http.Response) ([]OncoKBResponse, error) {
 }
 
 func setOncoKBDataVersion(message *tt.TempoMessage, resp []OncoKBResponse) {
+	// getConsistentResponses has made sure every response has the same data version
 	for _, r := range resp {
 		message.OncokbDataVersion = r.DataVersion
 		break
 	}
 }
 
-func mapResponseToEvents(events []*tt.Event, resp []OncoKBResponse) {
-
+// mapResponseToEvents correlates each response to the event of the request with the same ID and annotates it.
+// Responses that cannot be correlated are skipped and reported, along with requests that got no response,
+// as UnmatchedResponseError, DuplicateResponseError and MissingResponseError.
+func (o OncoKBAnnotatorService) mapResponseToEvents(events []*tt.Event, requests []oncoKBRequest, resp []OncoKBResponse) error {
+	var errs []error
+	requestEvents := make(map[string]*tt.Event, len(requests))
+	for _, r := range requests {
+		// request ids are the index of the event they were built from
+		ind, err := strconv.Atoi(r.query().ID)
+		if err == nil && ind >= 0 && ind < len(events) {
+			requestEvents[r.query().ID] = events[ind]
+		}
+	}
+	answered := make(map[string]bool, len(requests))
 	for _, r := range resp {
-		ind, _ := strconv.Atoi(r.Query.ID)
-		e := events[ind]
+		e, exists := requestEvents[r.Query.ID]
+		if !exists {
+			errs = append(errs, &UnmatchedResponseError{QueryID: r.Query.ID})
+			continue
+		}
+		if answered[r.Query.ID] {
+			errs = append(errs, &DuplicateResponseError{QueryID: r.Query.ID})
+			continue
+		}
+		answered[r.Query.ID] = true
+		var seen *citationSet
+		if o.dedupCitations {
+			seen = newCitationSet()
+		}
 		e.OncokbAnnotated = "true"
 		e.OncokbKnownGene = strconv.FormatBool(r.GeneExist)
 		e.OncokbKnownVariant = strconv.FormatBool(r.VariantExist)
 		e.OncokbMutationEffect = r.MutationEffect.KnownEffect
 		mutationEffectCitations := []Citations{r.MutationEffect.Citations}
-		e.OncokbMutationEffectCitations = getCitations[Citations](mutationEffectCitations)
+		e.OncokbMutationEffectCitations = getCitations(o.citationFormatter, nil, mutationEffectCitations)
 		e.OncokbOncogenic = r.Oncogenic
 		setTherapeuticLevels(e, r.Treatments)
-		e.OncokbTxCitations = getCitations[Treatments](r.Treatments)
+		e.OncokbTxCitations = getCitations(o.citationFormatter, seen, r.Treatments)
 		e.OncokbHighestLevel = getHighestTherapeuticLevel(r.Treatments)
 		e.OncokbHighestSensitivityLevel = r.HighestSensitiveLevel
 		e.OncokbHighestResistanceLevel = r.HighestResistanceLevel
-		setDiagnosticLevels(e, r.DiagnosticImplications)
-		e.OncokbDxCitations = getCitations[DiagnosticImplications](r.DiagnosticImplications)
+		diagnosticImplications := filterImplications(o.oncotree, r.Query.TumorType, r.DiagnosticImplications)
+		setDiagnosticLevels(e, diagnosticImplications, o.detailedImplications)
+		e.OncokbDxCitations = getCitations(o.citationFormatter, seen, diagnosticImplications)
 		e.OncokbHighestDxLevel = r.HighestDiagnosticImplicationLevel
-		setPrognosticLevels(e, r.PrognosticImplications)
-		e.OncokbPxCitations = getCitations[PrognosticImplications](r.PrognosticImplications)
+		prognosticImplications := filterImplications(o.oncotree, r.Query.TumorType, r.PrognosticImplications)
+		setPrognosticLevels(e, prognosticImplications, o.detailedImplications)
+		e.OncokbPxCitations = getCitations(o.citationFormatter, seen, prognosticImplications)
 		e.OncokbHighestPxLevel = r.HighestPrognosticImplicationLevel
+		if o.oncotree != nil {
+			// the highest levels OncoKB returns consider every tumor type, not just the ones we kept
+			e.OncokbHighestDxLevel = getHighestImplicationLevel(diagnosticLevels[:], diagnosticImplications)
+			e.OncokbHighestPxLevel = getHighestImplicationLevel(prognosticLevels[:], prognosticImplications)
+		}
+	}
+	for _, r := range requests {
+		q := r.query()
+		if !answered[q.ID] {
+			errs = append(errs, &MissingResponseError{QueryID: q.ID, HugoSymbol: q.HugoSymbol, Alteration: q.Alteration})
+		}
 	}
+	return errors.Join(errs...)
 }
 
 func setTherapeuticLevels(e *tt.Event, treatments []Treatments) {
-	// therapeutic levels [1,2,3A,3B,4,R1,R2]
+	// therapeutic levels [1,2,3A,3B,4,R1,R2], the drugs of a level are appended, so an event annotated before
+	// starts over
+	e.OncokbLevel1, e.OncokbLevel2, e.OncokbLevel3A, e.OncokbLevel3B, e.OncokbLevel4 = "", "", "", "", ""
+	e.OncokbLevelR1, e.OncokbLevelR2 = "", ""
 	for _, t := range treatments {
 		switch t.Level {
 		case "LEVEL_R1":
@@ -244,68 +753,6 @@ func getDrugs(existingDrugList string, newDrugs []Drugs) string {
 	return newDrugList
 }
 
-func getCitations[T any](items []T) string {
-	existingPmids := make(map[string]bool)
-	existingAbstracts := make(map[string]bool)
-	var sb strings.Builder
-	for _, item := range items {
-		var pmids, abstracts string
-		// this is ugly!
-		if c, ok := any(item).(Citations); ok {
-			pmids = getPmids(existingPmids, c.Pmids)
-			abstracts = getAbstracts(existingAbstracts, c.Abstracts)
-		} else if t, ok := any(item).(Treatments); ok {
-			pmids = getPmids(existingPmids, t.Pmids)
-			abstracts = getAbstracts(existingAbstracts, t.Abstracts)
-		} else if d, ok := any(item).(DiagnosticImplications); ok {
-			pmids = getPmids(existingPmids, d.Pmids)
-			abstracts = getAbstracts(existingAbstracts, d.Abstracts)
-		} else if p, ok := any(item).(PrognosticImplications); ok {
-			pmids = getPmids(existingPmids, p.Pmids)
-			abstracts = getAbstracts(existingAbstracts, p.Abstracts)
-		}
-		if sb.Len() > 0 && len(pmids) > 0 {
-			sb.WriteString(fmt.Sprintf(";%s", pmids))
-		} else if len(pmids) > 0 {
-			sb.WriteString(pmids)
-		}
-		if sb.Len() > 0 && len(abstracts) > 0 {
-			sb.WriteString(fmt.Sprintf(";%s", abstracts))
-		} else if len(abstracts) > 0 {
-			sb.WriteString(abstracts)
-		}
-	}
-	return sb.String()
-}
-
-func getPmids(existingPmids map[string]bool, pmids []string) string {
-	var sb strings.Builder
-	if len(pmids) > 0 {
-		for _, pmid := range pmids {
-			if _, exist := existingPmids[pmid]; !exist {
-				sb.WriteString(pmid)
-				sb.WriteString(";")
-				existingPmids[pmid] = true
-			}
-		}
-	}
-	return strings.TrimSuffix(sb.String(), ";")
-}
-
-func getAbstracts(existingAbstracts map[string]bool, abstracts []Abstracts) string {
-	var sb strings.Builder
-	if len(abstracts) > 0 {
-		for _, a := range abstracts {
-			abstract := fmt.Sprintf("%s(%s)", a.Abstract, a.Link)
-			if _, exist := existingAbstracts[abstract]; !exist {
-				sb.WriteString(fmt.Sprintf("%s;", abstract))
-				existingAbstracts[abstract] = true
-			}
-		}
-	}
-	return strings.TrimSuffix(sb.String(), ";")
-}
-
 var therapeuticLevels = [7]string{"LEVEL_R1", "LEVEL_1", "LEVEL_2", "LEVEL_3A", "LEVEL_3B", "LEVEL_4", "LEVEL_R2"}
 
 func getHighestTherapeuticLevel(treatments []Treatments) string {
@@ -320,50 +767,6 @@ func getHighestTherapeuticLevel(treatments []Treatments) string {
 	return ""
 }
 
-func setDiagnosticLevels(e *tt.Event, diagnosticImplications []DiagnosticImplications) {
-	// diagnostic levels [Dx1, Dx2, Dx3]
-	var sbDx1, sbDx2, sbDx3 strings.Builder
-	for _, d := range diagnosticImplications {
-		tumorType := d.TumorType.Code
-		if len(tumorType) == 0 {
-			tumorType = d.TumorType.MainType.Name
-		}
-		switch d.LevelOfEvidence {
-		case "LEVEL_Dx1":
-			sbDx1.WriteString(fmt.Sprintf("%s,", tumorType))
-		case "LEVEL_Dx2":
-			sbDx2.WriteString(fmt.Sprintf("%s,", tumorType))
-		case "LEVEL_Dx3":
-			sbDx3.WriteString(fmt.Sprintf("%s,", tumorType))
-		}
-	}
-	e.OncokbLevelDx1 = strings.TrimSuffix(sbDx1.String(), ",")
-	e.OncokbLevelDx2 = strings.TrimSuffix(sbDx2.String(), ",")
-	e.OncokbLevelDx3 = strings.TrimSuffix(sbDx3.String(), ",")
-}
-
-func setPrognosticLevels(e *tt.Event, prognosticImplications []PrognosticImplications) {
-	// prognostic levels [Px1, Px2, Px3]
-	var sbPx1, sbPx2, sbPx3 strings.Builder
-	for _, p := range prognosticImplications {
-		tumorType := p.TumorType.Code
-		if len(tumorType) == 0 {
-			tumorType = p.TumorType.MainType.Name
-		}
-		switch p.LevelOfEvidence {
-		case "LEVEL_Px1":
-			sbPx1.WriteString(fmt.Sprintf("%s,", tumorType))
-		case "LEVEL_Px2":
-			sbPx2.WriteString(fmt.Sprintf("%s,", tumorType))
-		case "LEVEL_Px3":
-			sbPx3.WriteString(fmt.Sprintf("%s,", tumorType))
-		}
-	}
-	e.OncokbLevelPx1 = strings.TrimSuffix(sbPx1.String(), ",")
-	e.OncokbLevelPx2 = strings.TrimSuffix(sbPx2.String(), ",")
-	e.OncokbLevelPx3 = strings.TrimSuffix(sbPx3.String(), ",")
-}
-
 func unMarshal[T any](msgData string) (T, error) {
 	var target T
 	if err := json.Unmarshal([]byte(msgData), &target); err != nil {
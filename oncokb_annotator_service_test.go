@@ -0,0 +1,142 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestMapResponseToEvents(t *testing.T) {
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), annotateURL)
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+
+	events := []*tt.Event{&tt.Event{HugoSymbol: "CHEK2"}, &tt.Event{HugoSymbol: "BRCA2"}, &tt.Event{HugoSymbol: "TP53"}}
+	requests := []OncoKBMutationRequest{
+		OncoKBMutationRequest{ID: "0", Gene: Gene{HugoSymbol: "CHEK2"}, Alteration: "S428F"},
+		OncoKBMutationRequest{ID: "1", Gene: Gene{HugoSymbol: "BRCA2"}, Alteration: "H52Qfs*16"},
+		OncoKBMutationRequest{ID: "2", Gene: Gene{HugoSymbol: "TP53"}, Alteration: "A63Lfs*60"},
+	}
+	resp := []OncoKBResponse{
+		OncoKBResponse{Query: Query{ID: "1"}, Oncogenic: "Likely Oncogenic"},
+		OncoKBResponse{Query: Query{ID: "0"}, Oncogenic: "Oncogenic"},
+		OncoKBResponse{Query: Query{ID: "0"}, Oncogenic: "Unknown"},
+		OncoKBResponse{Query: Query{ID: "7"}, Oncogenic: "Unknown"},
+		OncoKBResponse{Query: Query{ID: "bogus"}, Oncogenic: "Unknown"},
+	}
+
+	err = o.mapResponseToEvents(events, toOncoKBRequests(requests), resp)
+
+	if events[0].OncokbOncogenic != "Oncogenic" || events[1].OncokbOncogenic != "Likely Oncogenic" {
+		t.Errorf("responses were mapped to the wrong events: %q, %q", events[0].OncokbOncogenic, events[1].OncokbOncogenic)
+	}
+	if events[2].OncokbAnnotated != "" {
+		t.Errorf("event without a response should not be annotated")
+	}
+
+	var unmatched *UnmatchedResponseError
+	var duplicate *DuplicateResponseError
+	var missing *MissingResponseError
+	if !errors.As(err, &unmatched) {
+		t.Errorf("expected an UnmatchedResponseError in %v", err)
+	}
+	if !errors.As(err, &duplicate) || duplicate.QueryID != "0" {
+		t.Errorf("expected a DuplicateResponseError for query id 0 in %v", err)
+	}
+	if !errors.As(err, &missing) || missing.QueryID != "2" {
+		t.Errorf("expected a MissingResponseError for query id 2 in %v", err)
+	}
+}
+
+func TestConcurrentBatchesAndConsequenceOverrides(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		var requests []OncoKBMutationRequest
+		json.NewDecoder(r.Body).Decode(&requests)
+		var resp []OncoKBResponse
+		for _, req := range requests {
+			resp = append(resp, OncoKBResponse{Query: Query{ID: req.ID, Consequence: req.Consequence}, Oncogenic: req.Consequence, DataVersion: "v4.22"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithBatchSize(1), WithConcurrency(3),
+		WithConsequenceOverrides(map[string][]string{"Splice_Region": {"splice_donor_variant"}, "Fusion_Transcript": {"fusion"}}))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	tm := &tt.TempoMessage{OncotreeCode: "IDC"}
+	for _, vc := range []string{"Missense_Mutation", "Splice_Region", "Fusion_Transcript", "Nonsense_Mutation", "Missense_Mutation", "Silent"} {
+		tm.Events = append(tm.Events, &tt.Event{HugoSymbol: "TP53", HgvspShort: "p.R273H", VariantClassification: vc})
+	}
+	if err := o.AnnotateMutations(context.Background(), tm); err != nil {
+		t.Fatalf("Failed to annotate mutations: %v", err)
+	}
+	expected := []string{"missense_variant", "splice_donor_variant", "fusion", "stop_gained", "missense_variant", "silent"}
+	for i, e := range tm.Events {
+		if e.OncokbOncogenic != expected[i] {
+			t.Errorf("event %d: expected consequence %q but got %q", i, expected[i], e.OncokbOncogenic)
+		}
+	}
+	if n := maxInFlight.Load(); n < 2 || n > 3 {
+		t.Errorf("expected up to 3 batches at once but got %d", n)
+	}
+}
+
+func TestCheckReady(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/api/v1/info":
+			w.WriteHeader(http.StatusNotFound)
+		case !up.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"dataVersion": map[string]string{"version": "v4.22"}})
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	newAnnotator := func(token string) Annotator {
+		o, err := NewOncoKBAnnotatorService(StaticToken(token), server.URL+"/api/v1/annotate/mutations/byProteinChange")
+		if err != nil {
+			t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+		}
+		// the check reaches the service through the decorators the config wraps it in
+		return NewRetryingAnnotator(NewCachingAnnotator(o, 10, 0), 3, 0)
+	}
+	annotator := newAnnotator("token")
+	if err := CheckReady(ctx, annotator); err != nil {
+		t.Errorf("expected OncoKB to be ready but got %v", err)
+	}
+	up.Store(false)
+	var apiErr *OncoKBAPIError
+	if err := CheckReady(ctx, annotator); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected an unavailable OncoKB to be an error but got %v", err)
+	}
+	up.Store(true)
+	if err := CheckReady(ctx, newAnnotator("bad-token")); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a rejected token to be an error but got %v", err)
+	}
+	if err := CheckReady(ctx, &fakeAnnotator{}); err != nil {
+		t.Errorf("expected an annotator without a check to be ready but got %v", err)
+	}
+}
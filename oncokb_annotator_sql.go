@@ -0,0 +1,299 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// SQLDialect is the SQL flavor of the database a SQLSink writes to.
+type SQLDialect string
+
+const (
+	SQLDialectSQLite   SQLDialect = "sqlite"
+	SQLDialectPostgres SQLDialect = "postgres"
+
+	defaultSQLTable = "oncokb_annotated_events"
+)
+
+// SQLSink writes annotated events to a database/sql table, a row per sample, event and OncoKB data version.
+// The columns are those of sqlEventColumns, named like the ParquetEventRow columns, plus
+//
+//	event_id             identifies the event within the sample, see GetEventIDs
+//	oncokb_data_version  the OncoKB data version of the annotation
+//	updated_at           when the row was last written, RFC 3339 in UTC
+//
+// (sample_id, event_id, oncokb_data_version) is the primary key and rows are upserted on it, so writing a sample
+// again replaces its annotations for the same data version, while the annotations of earlier versions are kept.
+// The table is created and kept up to date by Migrate.
+type SQLSink struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	now     func() time.Time
+}
+
+// SQLSinkOption configures a SQLSink.
+type SQLSinkOption func(*SQLSink)
+
+// WithSQLDialect sets the SQL flavor of the database, SQLite by default.
+func WithSQLDialect(dialect SQLDialect) SQLSinkOption {
+	return func(s *SQLSink) {
+		s.dialect = dialect
+	}
+}
+
+// WithSQLTable sets the table the sink writes to, oncokb_annotated_events by default.
+func WithSQLTable(table string) SQLSinkOption {
+	return func(s *SQLSink) {
+		s.table = table
+	}
+}
+
+// NewSQLSink returns a sink writing to db.
+func NewSQLSink(db *sql.DB, opts ...SQLSinkOption) (*SQLSink, error) {
+	s := &SQLSink{db: db, dialect: SQLDialectSQLite, table: defaultSQLTable, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.dialect != SQLDialectSQLite && s.dialect != SQLDialectPostgres {
+		return nil, fmt.Errorf("Error: unknown SQL dialect %q, expected sqlite or postgres", s.dialect)
+	}
+	return s, nil
+}
+
+// sqlEventColumn is a column of the SQLSink table and the value it stores for an event of a message.
+type sqlEventColumn struct {
+	name  string
+	value func(m *tt.TempoMessage, e *tt.Event) string
+}
+
+// sqlEventColumns are the columns written by SQLSink, besides event_id, oncokb_data_version and updated_at.  They
+// must be the columns sqlMigrations leave the table with.
+var sqlEventColumns = []sqlEventColumn{
+	{"sample_id", func(m *tt.TempoMessage, e *tt.Event) string { return m.CmoSampleId }},
+	{"normal_sample_id", func(m *tt.TempoMessage, e *tt.Event) string { return m.NormalCmoSampleId }},
+	{"pipeline_version", func(m *tt.TempoMessage, e *tt.Event) string { return m.PipelineVersion }},
+	{"oncotree_code", func(m *tt.TempoMessage, e *tt.Event) string { return m.OncotreeCode }},
+	{"hugo_symbol", func(m *tt.TempoMessage, e *tt.Event) string { return e.HugoSymbol }},
+	{"entrez_gene_id", func(m *tt.TempoMessage, e *tt.Event) string { return e.EntrezGeneId }},
+	{"ncbi_build", func(m *tt.TempoMessage, e *tt.Event) string { return e.NcbiBuild }},
+	{"start_position", func(m *tt.TempoMessage, e *tt.Event) string { return e.StartPosition }},
+	{"end_position", func(m *tt.TempoMessage, e *tt.Event) string { return e.EndPosition }},
+	{"variant_classification", func(m *tt.TempoMessage, e *tt.Event) string { return e.VariantClassification }},
+	{"hgvsp_short", func(m *tt.TempoMessage, e *tt.Event) string { return e.HgvspShort }},
+	{"oncokb_annotated", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbAnnotated }},
+	{"oncokb_gene_in_oncokb", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbKnownGene }},
+	{"oncokb_variant_in_oncokb", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbKnownVariant }},
+	{"oncokb_mutation_effect", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbMutationEffect }},
+	{"oncokb_mutation_effect_citations", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbMutationEffectCitations }},
+	{"oncokb_oncogenic", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbOncogenic }},
+	{"oncokb_level_1", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevel1 }},
+	{"oncokb_level_2", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevel2 }},
+	{"oncokb_level_3a", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevel3A }},
+	{"oncokb_level_3b", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevel3B }},
+	{"oncokb_level_4", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevel4 }},
+	{"oncokb_level_r1", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelR1 }},
+	{"oncokb_level_r2", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelR2 }},
+	{"oncokb_highest_level", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbHighestLevel }},
+	{"oncokb_highest_sensitive_level", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbHighestSensitivityLevel }},
+	{"oncokb_highest_resistance_level", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbHighestResistanceLevel }},
+	{"oncokb_tx_citations", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbTxCitations }},
+	{"oncokb_level_dx1", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelDx1 }},
+	{"oncokb_level_dx2", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelDx2 }},
+	{"oncokb_level_dx3", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelDx3 }},
+	{"oncokb_highest_dx_level", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbHighestDxLevel }},
+	{"oncokb_dx_citations", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbDxCitations }},
+	{"oncokb_level_px1", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelPx1 }},
+	{"oncokb_level_px2", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelPx2 }},
+	{"oncokb_level_px3", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbLevelPx3 }},
+	{"oncokb_highest_px_level", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbHighestPxLevel }},
+	{"oncokb_px_citations", func(m *tt.TempoMessage, e *tt.Event) string { return e.OncokbPxCitations }},
+}
+
+// sqlMigrations evolve the table of a SQLSink, each is a list of statements with %[1]s standing for the table.
+// Migrations are only ever appended, one that has been released is never changed, so that Migrate can bring a
+// table from any earlier release up to date.  A column is added with a migration like
+//
+//	{"ALTER TABLE %[1]s ADD COLUMN oncokb_new_field TEXT NOT NULL DEFAULT ''"}
+//
+// and its sqlEventColumns entry.
+var sqlMigrations = [][]string{
+	// 1: the table, IF NOT EXISTS adopts the tables created before migrations were tracked
+	{`CREATE TABLE IF NOT EXISTS %[1]s (
+  sample_id TEXT NOT NULL,
+  normal_sample_id TEXT NOT NULL,
+  pipeline_version TEXT NOT NULL,
+  oncotree_code TEXT NOT NULL,
+  hugo_symbol TEXT NOT NULL,
+  entrez_gene_id TEXT NOT NULL,
+  ncbi_build TEXT NOT NULL,
+  start_position TEXT NOT NULL,
+  end_position TEXT NOT NULL,
+  variant_classification TEXT NOT NULL,
+  hgvsp_short TEXT NOT NULL,
+  oncokb_annotated TEXT NOT NULL,
+  oncokb_gene_in_oncokb TEXT NOT NULL,
+  oncokb_variant_in_oncokb TEXT NOT NULL,
+  oncokb_mutation_effect TEXT NOT NULL,
+  oncokb_mutation_effect_citations TEXT NOT NULL,
+  oncokb_oncogenic TEXT NOT NULL,
+  oncokb_level_1 TEXT NOT NULL,
+  oncokb_level_2 TEXT NOT NULL,
+  oncokb_level_3a TEXT NOT NULL,
+  oncokb_level_3b TEXT NOT NULL,
+  oncokb_level_4 TEXT NOT NULL,
+  oncokb_level_r1 TEXT NOT NULL,
+  oncokb_level_r2 TEXT NOT NULL,
+  oncokb_highest_level TEXT NOT NULL,
+  oncokb_highest_sensitive_level TEXT NOT NULL,
+  oncokb_highest_resistance_level TEXT NOT NULL,
+  oncokb_tx_citations TEXT NOT NULL,
+  oncokb_level_dx1 TEXT NOT NULL,
+  oncokb_level_dx2 TEXT NOT NULL,
+  oncokb_level_dx3 TEXT NOT NULL,
+  oncokb_highest_dx_level TEXT NOT NULL,
+  oncokb_dx_citations TEXT NOT NULL,
+  oncokb_level_px1 TEXT NOT NULL,
+  oncokb_level_px2 TEXT NOT NULL,
+  oncokb_level_px3 TEXT NOT NULL,
+  oncokb_highest_px_level TEXT NOT NULL,
+  oncokb_px_citations TEXT NOT NULL,
+  event_id TEXT NOT NULL,
+  oncokb_data_version TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (sample_id, event_id, oncokb_data_version)
+)`},
+}
+
+// Migrate creates the table of the sink, or brings it up to date with sqlMigrations.  The migrations applied to the
+// table are recorded in a <table>_migrations table, and each migration is applied in its own transaction.  Migrate
+// is meant to run once before the sink is written to, not concurrently with another Migrate of the same table.
+func (s *SQLSink) Migrate(ctx context.Context) error {
+	migrations := s.table + "_migrations"
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)", migrations)); err != nil {
+		return fmt.Errorf("Error creating table %s: %v", migrations, err)
+	}
+	var applied int
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", migrations)).Scan(&applied); err != nil {
+		return fmt.Errorf("Error reading the migrations of %s: %v", s.table, err)
+	}
+	if applied > len(sqlMigrations) {
+		return fmt.Errorf("Error: table %s is at migration %d, newer than this release's %d", s.table, applied, len(sqlMigrations))
+	}
+	for version := applied + 1; version <= len(sqlMigrations); version++ {
+		if err := s.migrate(ctx, version); err != nil {
+			return fmt.Errorf("Error applying migration %d to %s: %v", version, s.table, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLSink) migrate(ctx context.Context, version int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range sqlMigrations[version-1] {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(stmt, s.table)); err != nil {
+			return err
+		}
+	}
+	insert := fmt.Sprintf("INSERT INTO %s_migrations (version, applied_at) VALUES (%s, %s)", s.table, s.placeholder(1), s.placeholder(2))
+	if _, err := tx.ExecContext(ctx, insert, version, s.now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Write upserts the events of an annotated message in a single transaction.  Messages without an OncoKB data
+// version have not been annotated and are skipped.
+func (s *SQLSink) Write(ctx context.Context, message *tt.TempoMessage) error {
+	if message.OncokbDataVersion == "" || len(message.Events) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.upsertStatement())
+	if err != nil {
+		return fmt.Errorf("Error preparing upsert into %s: %v", s.table, err)
+	}
+	defer stmt.Close()
+
+	updatedAt := s.now().UTC().Format(time.RFC3339)
+	eventIDs := GetEventIDs(message.Events)
+	for i, e := range message.Events {
+		values := make([]any, 0, len(sqlEventColumns)+3)
+		for _, column := range sqlEventColumns {
+			values = append(values, column.value(message, e))
+		}
+		values = append(values, eventIDs[i], message.OncokbDataVersion, updatedAt)
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return fmt.Errorf("Error upserting event %d of sample %q: %v", i+1, message.CmoSampleId, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Error committing events of sample %q: %v", message.CmoSampleId, err)
+	}
+	return nil
+}
+
+func (s *SQLSink) upsertStatement() string {
+	var columns []string
+	for _, column := range sqlEventColumns {
+		columns = append(columns, column.name)
+	}
+	columns = append(columns, "event_id", "oncokb_data_version", "updated_at")
+	placeholders := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		placeholders[i] = s.placeholder(i + 1)
+		if column != "sample_id" && column != "event_id" && column != "oncokb_data_version" {
+			updates = append(updates, column+" = excluded."+column)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (sample_id, event_id, oncokb_data_version) DO UPDATE SET %s",
+		s.table, strings.Join(columns, ", "), strings.Join(placeholders, ", "), strings.Join(updates, ", "))
+}
+
+// placeholder returns the placeholder of the nth parameter of a statement.
+func (s *SQLSink) placeholder(n int) string {
+	if s.dialect == SQLDialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// GetEventIDs returns the identity of each event within its sample, the hex SHA-256 of its gene, build, positions,
+// variant classification and protein change.  Events do not carry their chromosome or alleles, so events that agree
+// on all of these, like two substitutions at the same position without a protein change, would share an ID.  The
+// second and later of them also hash how many came before them, keeping their IDs distinct and stable as long as
+// the events of the sample keep their order.
+func GetEventIDs(events []*tt.Event) []string {
+	ids := make([]string, len(events))
+	seen := make(map[string]int, len(events))
+	for i, e := range events {
+		key := strings.Join([]string{e.HugoSymbol, e.EntrezGeneId, e.NcbiBuild, e.StartPosition, e.EndPosition,
+			e.VariantClassification, e.HgvspShort}, "\x1f")
+		n := seen[key]
+		seen[key]++
+		if n > 0 {
+			key += "\x1f" + strconv.Itoa(n)
+		}
+		sum := sha256.Sum256([]byte(key))
+		ids[i] = hex.EncodeToString(sum[:])
+	}
+	return ids
+}
@@ -0,0 +1,159 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLSink(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "annotations.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	sink, err := NewSQLSink(db)
+	if err != nil {
+		t.Fatalf("Failed to create SQL sink: %v", err)
+	}
+	if err := sink.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate table: %v", err)
+	}
+	if err := sink.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate an up to date table: %v", err)
+	}
+
+	getMessage := func(version, oncogenic string) *tt.TempoMessage {
+		return &tt.TempoMessage{CmoSampleId: "S1", OncotreeCode: "IDC", OncokbDataVersion: version, Events: []*tt.Event{
+			{HugoSymbol: "BRCA2", HgvspShort: "p.H52Qfs*16", OncokbAnnotated: "true", OncokbOncogenic: oncogenic},
+			{HugoSymbol: "TP53", HgvspShort: "p.R273H", OncokbAnnotated: "true", OncokbOncogenic: "Oncogenic"},
+		}}
+	}
+	writes := []*tt.TempoMessage{
+		getMessage("v4.21", "Likely Oncogenic"),
+		getMessage("v4.22", "Inconclusive"),
+		// re-running a sample replaces the annotations of the same version
+		getMessage("v4.22", "Oncogenic"),
+		// messages that were not annotated are not stored
+		{CmoSampleId: "S2", Events: []*tt.Event{{HugoSymbol: "EGFR"}}},
+	}
+	for _, m := range writes {
+		if err := sink.Write(ctx, m); err != nil {
+			t.Fatalf("Failed to write %s: %v", m.CmoSampleId, err)
+		}
+	}
+
+	rows, err := db.Query("SELECT oncokb_data_version, hugo_symbol, oncokb_oncogenic FROM oncokb_annotated_events " +
+		"WHERE sample_id = 'S1' ORDER BY oncokb_data_version, hugo_symbol")
+	if err != nil {
+		t.Fatalf("Failed to query events: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var version, gene, oncogenic string
+		if err := rows.Scan(&version, &gene, &oncogenic); err != nil {
+			t.Fatalf("Failed to scan event: %v", err)
+		}
+		got = append(got, version+" "+gene+" "+oncogenic)
+	}
+	want := []string{"v4.21 BRCA2 Likely Oncogenic", "v4.21 TP53 Oncogenic", "v4.22 BRCA2 Oncogenic", "v4.22 TP53 Oncogenic"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected events\n%s\nbut got\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM oncokb_annotated_events").Scan(&count); err != nil || count != len(want) {
+		t.Errorf("expected %d rows but got %d (%v)", len(want), count, err)
+	}
+}
+
+func TestSQLSinkMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "annotations.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	sink, err := NewSQLSink(db)
+	if err != nil {
+		t.Fatalf("Failed to create SQL sink: %v", err)
+	}
+
+	// a table created before migrations were tracked is adopted by the first migration
+	if _, err := db.Exec(fmt.Sprintf(sqlMigrations[0][0], defaultSQLTable)); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := sink.Migrate(ctx); err != nil {
+		t.Fatalf("Failed to migrate an untracked table: %v", err)
+	}
+	var columns []string
+	for _, column := range sqlEventColumns {
+		columns = append(columns, column.name)
+	}
+	if _, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), defaultSQLTable)); err != nil {
+		t.Errorf("expected the migrated table to have every column written but got %v", err)
+	}
+
+	// a later release adds a column to the tables of earlier ones
+	defer func(migrations [][]string) { sqlMigrations = migrations }(sqlMigrations)
+	sqlMigrations = append(sqlMigrations[:len(sqlMigrations):len(sqlMigrations)],
+		[]string{"ALTER TABLE %[1]s ADD COLUMN oncokb_new_field TEXT NOT NULL DEFAULT ''"})
+	for i := 0; i < 2; i++ {
+		if err := sink.Migrate(ctx); err != nil {
+			t.Fatalf("Failed to apply a new migration: %v", err)
+		}
+	}
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM oncokb_annotated_events_migrations").Scan(&version); err != nil || version != 2 {
+		t.Errorf("expected the table to be at migration 2 but got %d (%v)", version, err)
+	}
+	if _, err := db.Exec("SELECT oncokb_new_field FROM oncokb_annotated_events"); err != nil {
+		t.Errorf("expected the new column to be added but got %v", err)
+	}
+
+	// an older release does not write to a table it does not know the schema of
+	sqlMigrations = sqlMigrations[:1]
+	if err := sink.Migrate(ctx); err == nil {
+		t.Errorf("expected a table migrated by a newer release to be an error")
+	}
+}
+
+func TestGetEventIDs(t *testing.T) {
+	// two substitutions at the same splice site differ only in alleles, which events do not carry
+	splice := func() *tt.Event {
+		return &tt.Event{HugoSymbol: "MET", NcbiBuild: "GRCh37", StartPosition: "116412044", EndPosition: "116412044",
+			VariantClassification: "Splice_Site"}
+	}
+	ids := GetEventIDs([]*tt.Event{splice(), {HugoSymbol: "TP53", HgvspShort: "p.R273H"}, splice()})
+	if ids[0] == ids[2] {
+		t.Errorf("expected events at the same position to have distinct IDs but got %v", ids)
+	}
+	if again := GetEventIDs([]*tt.Event{splice()}); again[0] != ids[0] {
+		t.Errorf("expected the first of identical events to keep its ID but got %s and %s", ids[0], again[0])
+	}
+}
+
+func TestSQLSinkUpsertStatement(t *testing.T) {
+	sink, err := NewSQLSink(nil, WithSQLDialect(SQLDialectPostgres), WithSQLTable("annotations"))
+	if err != nil {
+		t.Fatalf("Failed to create SQL sink: %v", err)
+	}
+	stmt := sink.upsertStatement()
+	if !strings.HasPrefix(stmt, "INSERT INTO annotations (sample_id, ") || !strings.Contains(stmt, "VALUES ($1, $2, ") {
+		t.Errorf("expected a Postgres insert into annotations but got %s", stmt)
+	}
+	if strings.Contains(stmt, " sample_id = excluded") || !strings.Contains(stmt, "oncokb_oncogenic = excluded.oncokb_oncogenic") {
+		t.Errorf("expected the annotation columns but not the key to be updated but got %s", stmt)
+	}
+	if _, err := NewSQLSink(nil, WithSQLDialect("mysql")); err == nil {
+		t.Errorf("expected an error for an unknown dialect")
+	}
+}
@@ -0,0 +1,128 @@
+package tempo_databricks_gateway
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// MessageFormat is a serialization of a stream of TempoMessages.
+type MessageFormat string
+
+const (
+	// MessageFormatNDJSON is one protojson encoded TempoMessage per line.
+	MessageFormatNDJSON MessageFormat = "ndjson"
+	// MessageFormatProto is varint length-delimited binary TempoMessages, as written by protodelim.
+	MessageFormatProto MessageFormat = "proto"
+)
+
+// maxMessageSize bounds the size of a single TempoMessage read from a stream.
+const maxMessageSize = 64 * 1024 * 1024
+
+// ParseMessageFormat returns the MessageFormat named by s.
+func ParseMessageFormat(s string) (MessageFormat, error) {
+	switch f := MessageFormat(strings.ToLower(s)); f {
+	case MessageFormatNDJSON, MessageFormatProto:
+		return f, nil
+	case "json", "jsonl":
+		return MessageFormatNDJSON, nil
+	case "protobuf", "pb":
+		return MessageFormatProto, nil
+	}
+	return "", fmt.Errorf("Error: unknown message format %q, expected ndjson or proto", s)
+}
+
+// MessageReader streams TempoMessages.
+type MessageReader struct {
+	format  MessageFormat
+	reader  *bufio.Reader
+	scanner *bufio.Scanner
+	count   int
+}
+
+// NewMessageReader reads a stream of TempoMessages in the given format.
+func NewMessageReader(r io.Reader, format MessageFormat) *MessageReader {
+	m := &MessageReader{format: format}
+	if format == MessageFormatNDJSON {
+		m.scanner = bufio.NewScanner(r)
+		m.scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	} else {
+		m.reader = bufio.NewReader(r)
+	}
+	return m
+}
+
+// Read returns the next message of the stream, or io.EOF when there are no more.  Blank NDJSON lines are skipped.
+func (m *MessageReader) Read() (*tt.TempoMessage, error) {
+	message := &tt.TempoMessage{}
+	if m.format == MessageFormatProto {
+		err := protodelim.UnmarshalOptions{MaxSize: maxMessageSize}.UnmarshalFrom(m.reader, message)
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading message %d: %v", m.count+1, err)
+		}
+		m.count++
+		return message, nil
+	}
+	for m.scanner.Scan() {
+		line := strings.TrimSpace(m.scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := protojson.Unmarshal([]byte(line), message); err != nil {
+			return nil, fmt.Errorf("Error reading message %d: %v", m.count+1, err)
+		}
+		m.count++
+		return message, nil
+	}
+	if err := m.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading message %d: %v", m.count+1, err)
+	}
+	return nil, io.EOF
+}
+
+// MessageWriter writes a stream of TempoMessages.
+type MessageWriter struct {
+	format MessageFormat
+	w      *bufio.Writer
+}
+
+// NewMessageWriter writes a stream of TempoMessages in the given format.
+func NewMessageWriter(w io.Writer, format MessageFormat) *MessageWriter {
+	return &MessageWriter{format: format, w: bufio.NewWriter(w)}
+}
+
+// Write writes a message to the stream.
+func (m *MessageWriter) Write(message *tt.TempoMessage) error {
+	if m.format == MessageFormatProto {
+		if _, err := protodelim.MarshalTo(m.w, message); err != nil {
+			return fmt.Errorf("Error writing message: %v", err)
+		}
+		return nil
+	}
+	b, err := protojson.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("Error writing message: %v", err)
+	}
+	m.w.Write(b)
+	if err := m.w.WriteByte('\n'); err != nil {
+		return fmt.Errorf("Error writing message: %v", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered messages to the underlying writer.
+func (m *MessageWriter) Flush() error {
+	if err := m.w.Flush(); err != nil {
+		return fmt.Errorf("Error writing message: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMessageStreams(t *testing.T) {
+	messages := []*tt.TempoMessage{
+		{CmoSampleId: "S1", OncotreeCode: "IDC", Events: []*tt.Event{{HugoSymbol: "BRCA2", HgvspShort: "p.H52Qfs*16"}}},
+		{CmoSampleId: "S2", OncotreeCode: "CCRCC", Events: []*tt.Event{{HugoSymbol: "CHEK2", HgvspShort: "p.S428F"}}},
+	}
+	for _, format := range []MessageFormat{MessageFormatNDJSON, MessageFormatProto} {
+		var buf bytes.Buffer
+		writer := NewMessageWriter(&buf, format)
+		for _, m := range messages {
+			if err := writer.Write(m); err != nil {
+				t.Fatalf("%s: Failed to write message: %v", format, err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: Failed to flush messages: %v", format, err)
+		}
+		if format == MessageFormatNDJSON && strings.Count(buf.String(), "\n") != len(messages) {
+			t.Errorf("%s: expected a line per message but got %q", format, buf.String())
+		}
+
+		reader := NewMessageReader(&buf, format)
+		for i, want := range messages {
+			got, err := reader.Read()
+			if err != nil {
+				t.Fatalf("%s: Failed to read message %d: %v", format, i+1, err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("%s: expected %v but got %v", format, want, got)
+			}
+		}
+		if _, err := reader.Read(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF but got %v", format, err)
+		}
+	}
+}
+
+func TestMessageReaderErrors(t *testing.T) {
+	reader := NewMessageReader(strings.NewReader("{\"cmoSampleId\": \"S1\"}\n\n{not json}\n"), MessageFormatNDJSON)
+	if _, err := reader.Read(); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	if _, err := reader.Read(); err == nil || !strings.Contains(err.Error(), "message 2") {
+		t.Errorf("expected an error reading message 2 but got %v", err)
+	}
+	if _, err := ParseMessageFormat("xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,104 @@
+package tempo_databricks_gateway
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// SampleSummary rolls the per event OncoKB annotations of a TempoMessage up to the sample,
+// like the sample-level columns ClinicalDataAnnotator.py adds to a cBioPortal clinical sample file.
+type SampleSummary struct {
+	SampleID               string
+	OncotreeCode           string
+	HighestLevel           string
+	HighestSensitiveLevel  string
+	HighestResistanceLevel string
+	HighestDxLevel         string
+	HighestPxLevel         string
+	ActionableGenes        []string
+	OncogenicMutations     []string
+	ResistanceMutations    []string
+}
+
+var sensitiveLevels = [5]string{"LEVEL_1", "LEVEL_2", "LEVEL_3A", "LEVEL_3B", "LEVEL_4"}
+var resistanceLevels = [2]string{"LEVEL_R1", "LEVEL_R2"}
+var diagnosticLevels = [3]string{"LEVEL_Dx1", "LEVEL_Dx2", "LEVEL_Dx3"}
+var prognosticLevels = [3]string{"LEVEL_Px1", "LEVEL_Px2", "LEVEL_Px3"}
+
+// SummarizeSample aggregates the events of a message that has been through AnnotateMutations.
+func SummarizeSample(message *tt.TempoMessage) SampleSummary {
+	summary := SampleSummary{
+		SampleID:     message.CmoSampleId,
+		OncotreeCode: message.OncotreeCode,
+	}
+	summary.AddEvents(message.Events...)
+	return summary
+}
+
+// AddEvents folds more annotated events of the sample into the summary, so a sample can be summarized in chunks.
+func (s *SampleSummary) AddEvents(events ...*tt.Event) {
+	for _, e := range events {
+		s.HighestLevel = getHigherLevel(therapeuticLevels[:], s.HighestLevel, e.OncokbHighestLevel)
+		s.HighestSensitiveLevel = getHigherLevel(sensitiveLevels[:], s.HighestSensitiveLevel, e.OncokbHighestSensitivityLevel)
+		s.HighestResistanceLevel = getHigherLevel(resistanceLevels[:], s.HighestResistanceLevel, e.OncokbHighestResistanceLevel)
+		s.HighestDxLevel = getHigherLevel(diagnosticLevels[:], s.HighestDxLevel, e.OncokbHighestDxLevel)
+		s.HighestPxLevel = getHigherLevel(prognosticLevels[:], s.HighestPxLevel, e.OncokbHighestPxLevel)
+		if isOncogenic(e.OncokbOncogenic) {
+			s.OncogenicMutations = append(s.OncogenicMutations, getEventName(e))
+		}
+		if len(e.OncokbHighestResistanceLevel) > 0 {
+			s.ResistanceMutations = append(s.ResistanceMutations, getEventName(e))
+		}
+		if len(e.OncokbHighestLevel) > 0 && len(e.HugoSymbol) > 0 {
+			s.ActionableGenes = insertSorted(s.ActionableGenes, e.HugoSymbol)
+		}
+	}
+}
+
+// getEventName names an event the way ClinicalDataAnnotator.py lists them, gene and alteration joined by an underscore.
+func getEventName(e *tt.Event) string {
+	alteration := strings.TrimPrefix(e.HgvspShort, "p.")
+	if len(alteration) == 0 {
+		alteration = e.VariantClassification
+	}
+	return e.HugoSymbol + "_" + alteration
+}
+
+// insertSorted adds value to a sorted slice of unique values.
+func insertSorted(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+	if i < len(values) && values[i] == value {
+		return values
+	}
+	return slices.Insert(values, i, value)
+}
+
+func isOncogenic(oncogenic string) bool {
+	return oncogenic == "Oncogenic" || oncogenic == "Likely Oncogenic"
+}
+
+// getHigherLevel returns whichever of the two levels comes first in levels, which is ordered highest to lowest.
+// Levels not in the list are never considered higher than a level that is.
+func getHigherLevel(levels []string, current, candidate string) string {
+	candidateRank := getLevelRank(levels, candidate)
+	if candidateRank < 0 {
+		return current
+	}
+	currentRank := getLevelRank(levels, current)
+	if currentRank < 0 || candidateRank < currentRank {
+		return candidate
+	}
+	return current
+}
+
+func getLevelRank(levels []string, level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,61 @@
+package tempo_databricks_gateway
+
+import (
+	"reflect"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestSummarizeSample(t *testing.T) {
+	tm := &tt.TempoMessage{
+		CmoSampleId:  "P-0083952-T01-IM7",
+		OncotreeCode: "OOVC",
+		Events: []*tt.Event{
+			&tt.Event{
+				HugoSymbol:                    "CDKN2A",
+				VariantClassification:         "Missense_Mutation",
+				OncokbOncogenic:               "Likely Oncogenic",
+				OncokbHighestLevel:            "LEVEL_4",
+				OncokbHighestSensitivityLevel: "LEVEL_4",
+			},
+			&tt.Event{
+				HugoSymbol:                    "BRCA2",
+				HgvspShort:                    "p.H52Qfs*16",
+				OncokbOncogenic:               "Oncogenic",
+				OncokbHighestLevel:            "LEVEL_R1",
+				OncokbHighestSensitivityLevel: "LEVEL_3A",
+				OncokbHighestResistanceLevel:  "LEVEL_R1",
+				OncokbHighestDxLevel:          "LEVEL_Dx2",
+			},
+			&tt.Event{
+				HugoSymbol:           "TP53",
+				HgvspShort:           "p.R248Q",
+				OncokbOncogenic:      "Likely Oncogenic",
+				OncokbHighestPxLevel: "LEVEL_Px3",
+			},
+			&tt.Event{
+				HugoSymbol:           "TP53",
+				OncokbOncogenic:      "Unknown",
+				OncokbHighestPxLevel: "LEVEL_Px1",
+			},
+		},
+	}
+
+	got := SummarizeSample(tm)
+	want := SampleSummary{
+		SampleID:               "P-0083952-T01-IM7",
+		OncotreeCode:           "OOVC",
+		HighestLevel:           "LEVEL_R1",
+		HighestSensitiveLevel:  "LEVEL_3A",
+		HighestResistanceLevel: "LEVEL_R1",
+		HighestDxLevel:         "LEVEL_Dx2",
+		HighestPxLevel:         "LEVEL_Px1",
+		ActionableGenes:        []string{"BRCA2", "CDKN2A"},
+		OncogenicMutations:     []string{"CDKN2A_Missense_Mutation", "BRCA2_H52Qfs*16", "TP53_R248Q"},
+		ResistanceMutations:    []string{"BRCA2_H52Qfs*16"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v but got %+v", want, got)
+	}
+}
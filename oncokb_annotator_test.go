@@ -0,0 +1,126 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// fakeAnnotator annotates every event as oncogenic, failing the first failures calls with err.
+type fakeAnnotator struct {
+	calls    int
+	events   int
+	failures int
+	err      error
+}
+
+func (f *fakeAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	for _, e := range message.Events {
+		f.events++
+		e.OncokbAnnotated = "true"
+		e.OncokbOncogenic = fmt.Sprintf("Oncogenic %s", e.HugoSymbol)
+	}
+	message.OncokbDataVersion = "v4.22"
+	return nil
+}
+
+func (f *fakeAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return f.AnnotateMutations(ctx, message)
+}
+
+func (f *fakeAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return f.AnnotateMutations(ctx, message)
+}
+
+func TestRetryingAnnotator(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAnnotator{failures: 2, err: &OncoKBAPIError{StatusCode: 503}}
+	if err := NewRetryingAnnotator(fake, 3, 0).AnnotateMutations(ctx, newVersionTestMessage()); err != nil {
+		t.Errorf("expected the third attempt to succeed but got %v", err)
+	}
+
+	fake = &fakeAnnotator{failures: 2, err: &OncoKBAPIError{StatusCode: 401}}
+	var apiErr *OncoKBAPIError
+	if err := NewRetryingAnnotator(fake, 3, 0).AnnotateMutations(ctx, newVersionTestMessage()); !errors.As(err, &apiErr) || fake.calls != 1 {
+		t.Errorf("expected a single attempt for a 401 but got %d attempts and %v", fake.calls, err)
+	}
+}
+
+func TestCachingAnnotator(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeAnnotator{}
+	var metrics AnnotatorMetrics
+	a := NewInstrumentedAnnotator(NewCachingAnnotator(fake, 10, 0), &metrics)
+
+	for i := 0; i < 3; i++ {
+		tm := newVersionTestMessage()
+		if err := a.AnnotateMutations(ctx, tm); err != nil {
+			t.Fatalf("Failed to annotate message: %v", err)
+		}
+		if tm.Events[1].OncokbOncogenic != "Oncogenic CHEK2" || tm.OncokbDataVersion != "v4.22" {
+			t.Errorf("message %d was not annotated from the cache: %q", i, tm.Events[1].OncokbOncogenic)
+		}
+	}
+	if fake.events != 2 {
+		t.Errorf("expected 2 events to reach the annotator but got %d", fake.events)
+	}
+	if metrics.Calls.Load() != 3 || metrics.Events.Load() != 6 || metrics.Errors.Load() != 0 {
+		t.Errorf("unexpected metrics: %d calls, %d events, %d errors", metrics.Calls.Load(), metrics.Events.Load(), metrics.Errors.Load())
+	}
+}
+
+// versionedAnnotator appends the drugs of its data version to LEVEL_1, as the OncoKB service appends the drugs of
+// each treatment.
+type versionedAnnotator struct {
+	version string
+}
+
+func (v *versionedAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	for _, e := range message.Events {
+		e.OncokbAnnotated = "true"
+		e.OncokbLevel1 = getDrugs(e.OncokbLevel1, []Drugs{{DrugName: "Drug " + v.version}})
+	}
+	message.OncokbDataVersion = v.version
+	return nil
+}
+
+func (v *versionedAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return v.AnnotateMutations(ctx, message)
+}
+
+func (v *versionedAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return v.AnnotateMutations(ctx, message)
+}
+
+func TestCachingAnnotatorDataVersionChange(t *testing.T) {
+	ctx := context.Background()
+	versioned := &versionedAnnotator{version: "v4.22"}
+	a := NewCachingAnnotator(versioned, 10, 0)
+	tm := newVersionTestMessage()
+	tm.Events = tm.Events[:1]
+	if err := a.AnnotateMutations(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+
+	// the first event comes from the cache of the old version, the second one from the new version
+	versioned.version = "v4.23"
+	tm = newVersionTestMessage()
+	if err := a.AnnotateMutations(ctx, tm); err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+	for i, e := range tm.Events {
+		if e.OncokbLevel1 != "Drug v4.23" {
+			t.Errorf("expected event %d to be annotated once with the new version but got %q", i, e.OncokbLevel1)
+		}
+	}
+	if tm.OncokbDataVersion != "v4.23" {
+		t.Errorf("expected data version v4.23 but got %q", tm.OncokbDataVersion)
+	}
+}
@@ -0,0 +1,239 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const redactedToken = "REDACTED"
+
+// TokenProvider supplies the OncoKB API token.  Token is called for every OncoKB request, so a provider can hand
+// out a new token after the old one is rotated.  Providers never include the token in their errors.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenInvalidator is implemented by providers that cache the token.  OncoKBAnnotatorService invalidates the token
+// when OncoKB rejects it, and retries the request once with the token the provider fetches next.
+type TokenInvalidator interface {
+	InvalidateToken()
+}
+
+// StaticToken is a fixed token, for tests and for tokens read by the caller.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	if len(t) == 0 {
+		return "", fmt.Errorf("Error: the OncoKB token is empty")
+	}
+	return string(t), nil
+}
+
+// EnvTokenProvider reads the token from an environment variable on every request.
+type EnvTokenProvider struct {
+	Name string
+}
+
+func (p EnvTokenProvider) Token(ctx context.Context) (string, error) {
+	token := strings.TrimSpace(os.Getenv(p.Name))
+	if len(token) == 0 {
+		return "", fmt.Errorf("Error: the OncoKB token environment variable %s is not set", p.Name)
+	}
+	return token, nil
+}
+
+// FileTokenProvider reads the token from a file, like a mounted Kubernetes or Docker secret.  It is read again
+// whenever it changes, so the secret can be rotated in place.  A file readable by group or others is logged as a
+// warning rather than refused, as secret mounts are often 0644 or 0444 inside a container only the annotator runs in.
+type FileTokenProvider struct {
+	path       string
+	logger     *slog.Logger
+	mu         sync.Mutex
+	token      string
+	modTime    time.Time
+	size       int64
+	warnedPerm os.FileMode
+}
+
+// FileTokenOption configures optional behavior of a FileTokenProvider.
+type FileTokenOption func(*FileTokenProvider)
+
+// WithFileTokenLogger logs a warning when the token file is readable by group or others.
+func WithFileTokenLogger(logger *slog.Logger) FileTokenOption {
+	return func(p *FileTokenProvider) {
+		p.logger = logger
+	}
+}
+
+// NewFileTokenProvider returns a provider of the token in the file at path.
+func NewFileTokenProvider(path string, opts ...FileTokenOption) *FileTokenProvider {
+	p := &FileTokenProvider{path: path, logger: discardLogger}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.logger == nil {
+		p.logger = discardLogger
+	}
+	return p
+}
+
+func (p *FileTokenProvider) Token(ctx context.Context) (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading OncoKB token file: %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// warned once per change of the permissions, not on every request
+	if perm := info.Mode().Perm(); perm&0o077 != 0 && perm != p.warnedPerm {
+		p.logger.Warn("oncokb token file is readable by group or others, it should be 0600 or 0400",
+			slog.String("path", p.path), slog.String("mode", fmt.Sprintf("%#o", perm)))
+		p.warnedPerm = perm
+	}
+	if len(p.token) > 0 && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.token, nil
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading OncoKB token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return "", fmt.Errorf("Error: OncoKB token file %q is empty", p.path)
+	}
+	p.token, p.modTime, p.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
+
+// InvalidateToken makes the next request read the file again.
+func (p *FileTokenProvider) InvalidateToken() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = ""
+}
+
+// ExecTokenProvider runs a command that prints the token on stdout, like a secret manager CLI:
+//
+//	NewExecTokenProvider(time.Hour, "vault", "kv", "get", "-field=token", "secret/oncokb")
+//
+// The token is kept for ttl and the command run again after that, or once OncoKB rejects the token.  Requests that
+// need a new token while the command runs wait for it rather than running it again.
+type ExecTokenProvider struct {
+	name    string
+	args    []string
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	running *execTokenRun
+}
+
+// execTokenRun is a run of the token command, done is closed once token and err are set.
+type execTokenRun struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// maxTokenCommandStderr bounds how much of the stderr of a failed token command goes into its error.
+const maxTokenCommandStderr = 1024
+
+// tokenCommandTimeout bounds a run of the token command, which no single request can cancel.
+const tokenCommandTimeout = time.Minute
+
+// NewExecTokenProvider returns a provider running name with args, keeping the token it prints for ttl.  The command
+// is run directly, not by a shell, so args are passed as they are.
+func NewExecTokenProvider(ttl time.Duration, name string, args ...string) *ExecTokenProvider {
+	return &ExecTokenProvider{name: name, args: args, ttl: ttl, now: time.Now}
+}
+
+func (p *ExecTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	if len(p.token) > 0 && p.now().Before(p.expires) {
+		token := p.token
+		p.mu.Unlock()
+		return token, nil
+	}
+	// the lock is not held while the command runs, which can take as long as a secret manager does, and the run
+	// does not stop with the request that started it, so the requests waiting for it still get its token
+	run := p.running
+	if run == nil {
+		run = &execTokenRun{done: make(chan struct{})}
+		p.running = run
+		go p.finish(context.WithoutCancel(ctx), run)
+	}
+	p.mu.Unlock()
+	select {
+	case <-run.done:
+		return run.token, run.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// finish runs the command for run, keeping its token for the next requests.
+func (p *ExecTokenProvider) finish(ctx context.Context, run *execTokenRun) {
+	ctx, cancel := context.WithTimeout(ctx, tokenCommandTimeout)
+	defer cancel()
+	run.token, run.err = p.run(ctx)
+	p.mu.Lock()
+	if run.err == nil {
+		p.token, p.expires = run.token, p.now().Add(p.ttl)
+	}
+	p.running = nil
+	p.mu.Unlock()
+	close(run.done)
+}
+
+func (p *ExecTokenProvider) run(ctx context.Context) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.name, p.args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	token := strings.TrimSpace(stdout.String())
+	if err != nil {
+		// stdout is left out in case it holds the token, and anything it printed is removed from stderr
+		message := strings.TrimSpace(redactToken(stderr.String(), token))
+		if len(message) > maxTokenCommandStderr {
+			// the message is cut at the start of a rune so it stays valid UTF-8
+			start := len(message) - maxTokenCommandStderr
+			for start < len(message) && !utf8.RuneStart(message[start]) {
+				start++
+			}
+			message = "..." + message[start:]
+		}
+		if message == "" {
+			return "", fmt.Errorf("Error running OncoKB token command %q: %v", p.name, err)
+		}
+		return "", fmt.Errorf("Error running OncoKB token command %q: %v: %s", p.name, err, message)
+	}
+	if len(token) == 0 {
+		return "", fmt.Errorf("Error: OncoKB token command %q printed no token", p.name)
+	}
+	return token, nil
+}
+
+// InvalidateToken makes the next request run the command again.
+func (p *ExecTokenProvider) InvalidateToken() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = ""
+}
+
+// redactToken removes token from s, for messages that may echo it back, like OncoKB errors.
+func redactToken(s, token string) string {
+	if len(token) == 0 {
+		return s
+	}
+	return strings.ReplaceAll(s, token, redactedToken)
+}
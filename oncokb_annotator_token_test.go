@@ -0,0 +1,171 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+func TestFileTokenProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("secret-1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	var log bytes.Buffer
+	tokens := NewFileTokenProvider(path, WithFileTokenLogger(slog.New(slog.NewTextHandler(&log, nil))))
+	// secret mounts are often world readable, which is warned about once rather than refused
+	for i := 0; i < 2; i++ {
+		if token, err := tokens.Token(ctx); err != nil || token != "secret-1" {
+			t.Errorf("expected secret-1 from a world readable token file but got %q (%v)", token, err)
+		}
+	}
+	if n := strings.Count(log.String(), "readable by group or others"); n != 1 || strings.Contains(log.String(), "secret-1") {
+		t.Errorf("expected a warning without the token but got %q", log.String())
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatalf("Failed to change token file permissions: %v", err)
+	}
+	if token, err := tokens.Token(ctx); err != nil || token != "secret-1" {
+		t.Errorf("expected secret-1 but got %q (%v)", token, err)
+	}
+
+	// the rotated token is read without a restart
+	if err := os.WriteFile(path, []byte("secret-22"), 0o600); err != nil {
+		t.Fatalf("Failed to rotate token file: %v", err)
+	}
+	if token, err := tokens.Token(ctx); err != nil || token != "secret-22" {
+		t.Errorf("expected secret-22 but got %q (%v)", token, err)
+	}
+}
+
+func TestExecTokenProvider(t *testing.T) {
+	ctx := context.Background()
+	tokens := NewExecTokenProvider(time.Hour, "echo", "secret-1")
+	if token, err := tokens.Token(ctx); err != nil || token != "secret-1" {
+		t.Errorf("expected secret-1 but got %q (%v)", token, err)
+	}
+	tokens = NewExecTokenProvider(time.Hour, "sh", "-c", "echo secret-1; echo 'permission denied for secret-1' >&2; exit 1")
+	if _, err := tokens.Token(ctx); err == nil || strings.Contains(err.Error(), "secret-1") || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected an error with stderr but without the token but got %v", err)
+	}
+
+	// requests waiting for a token share one run of the command
+	runs := filepath.Join(t.TempDir(), "runs")
+	tokens = NewExecTokenProvider(time.Hour, "sh", "-c", "echo run >> "+runs+"; sleep 0.2; echo secret-2")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := tokens.Token(ctx); err != nil || token != "secret-2" {
+				t.Errorf("expected secret-2 but got %q (%v)", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if data, err := os.ReadFile(runs); err != nil || strings.Count(string(data), "run") != 1 {
+		t.Errorf("expected the command to run once but got %q (%v)", data, err)
+	}
+
+	// a request that is cancelled while the command runs does not fail the requests waiting for the same run
+	tokens = NewExecTokenProvider(time.Hour, "sh", "-c", "sleep 0.2; echo secret-3")
+	cancelled, cancel := context.WithCancel(ctx)
+	started := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		close(started)
+		_, err := tokens.Token(cancelled)
+		errs <- err
+	}()
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if token, err := tokens.Token(ctx); err != nil || token != "secret-3" {
+		t.Errorf("expected secret-3 but got %q (%v)", token, err)
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the cancelled request to stop with its context but got %v", err)
+	}
+
+	// a long stderr is cut at a rune boundary
+	tokens = NewExecTokenProvider(time.Hour, "sh", "-c", "i=0; while [ $i -lt 400 ]; do printf '€'; i=$((i+1)); done >&2; exit 1")
+	if _, err := tokens.Token(ctx); err == nil || !utf8.ValidString(err.Error()) || !strings.Contains(err.Error(), "...€") {
+		t.Errorf("expected a valid UTF-8 error cut to the last runes of stderr but got %v", err)
+	}
+	if _, err := (EnvTokenProvider{Name: "ONCOKB_TEST_TOKEN_UNSET"}).Token(ctx); err == nil {
+		t.Errorf("expected an error for an unset environment variable")
+	}
+}
+
+// rotatingTokens hands out the current token until it is invalidated, as a secret manager would after a rotation.
+type rotatingTokens struct {
+	tokens []string
+	calls  int
+}
+
+func (r *rotatingTokens) Token(ctx context.Context) (string, error) {
+	r.calls++
+	return r.tokens[0], nil
+}
+
+func (r *rotatingTokens) InvalidateToken() {
+	r.tokens = r.tokens[1:]
+}
+
+func TestTokenRotation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth != "Bearer new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(OncoKBErrorResponse{Message: "Invalid token " + strings.TrimPrefix(auth, "Bearer ")})
+			return
+		}
+		var requests []map[string]any
+		json.NewDecoder(r.Body).Decode(&requests)
+		var resp []OncoKBResponse
+		for _, req := range requests {
+			resp = append(resp, OncoKBResponse{Query: Query{ID: req["id"].(string)}, Oncogenic: "Oncogenic", DataVersion: "v4.22"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	tokens := &rotatingTokens{tokens: []string{"old-token", "new-token"}}
+	o, err := NewOncoKBAnnotatorService(tokens, server.URL+"/byProteinChange")
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	tm := &tt.TempoMessage{OncotreeCode: "IDC", Events: []*tt.Event{{HugoSymbol: "TP53", HgvspShort: "p.R273H", VariantClassification: "Missense_Mutation"}}}
+	if err := o.AnnotateMutations(context.Background(), tm); err != nil {
+		t.Fatalf("Failed to annotate with the rotated token: %v", err)
+	}
+	if tokens.calls != 2 || tm.Events[0].OncokbOncogenic != "Oncogenic" {
+		t.Errorf("expected a retry with the rotated token but got %d calls and %q", tokens.calls, tm.Events[0].OncokbOncogenic)
+	}
+
+	o, err = NewOncoKBAnnotatorService(StaticToken("bad-token"), server.URL+"/byProteinChange")
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	err = o.AnnotateMutations(context.Background(), tm)
+	if err == nil || strings.Contains(err.Error(), "bad-token") || !strings.Contains(err.Error(), redactedToken) {
+		t.Errorf("expected an error with the token redacted but got %v", err)
+	}
+	if _, err := NewOncoKBAnnotatorService(nil, server.URL); err == nil {
+		t.Errorf("expected an error without a token provider")
+	}
+}
@@ -84,12 +84,13 @@ type TumorType struct {
 }
 
 type DiagnosticImplications struct {
-	Abstracts       []Abstracts `json:"abstracts"`
-	Alterations     []string    `json:"alterations"`
-	Description     string      `json:"description"`
-	LevelOfEvidence string      `json:"levelOfEvidence"`
-	Pmids           []string    `json:"pmids"`
-	TumorType       TumorType   `json:"tumorType"`
+	Abstracts          []Abstracts `json:"abstracts"`
+	Alterations        []string    `json:"alterations"`
+	Description        string      `json:"description"`
+	ExcludedTumorTypes []TumorType `json:"excludedTumorTypes"`
+	LevelOfEvidence    string      `json:"levelOfEvidence"`
+	Pmids              []string    `json:"pmids"`
+	TumorType          TumorType   `json:"tumorType"`
 }
 
 type Citations struct {
@@ -104,12 +105,13 @@ type MutationEffect struct {
 }
 
 type PrognosticImplications struct {
-	Abstracts       []Abstracts `json:"abstracts"`
-	Alterations     []string    `json:"alterations"`
-	Description     string      `json:"description"`
-	LevelOfEvidence string      `json:"levelOfEvidence"`
-	Pmids           []string    `json:"pmids"`
-	TumorType       TumorType   `json:"tumorType"`
+	Abstracts          []Abstracts `json:"abstracts"`
+	Alterations        []string    `json:"alterations"`
+	Description        string      `json:"description"`
+	ExcludedTumorTypes []TumorType `json:"excludedTumorTypes"`
+	LevelOfEvidence    string      `json:"levelOfEvidence"`
+	Pmids              []string    `json:"pmids"`
+	TumorType          TumorType   `json:"tumorType"`
 }
 
 type Query struct {
@@ -172,3 +174,23 @@ type Treatments struct {
 	LevelExcludedCancerTypes  []LevelExcludedCancerTypes `json:"levelExcludedCancerTypes"`
 	Pmids                     []string                   `json:"pmids"`
 }
+
+type OncoKBCopyNumberAlterationRequest struct {
+	CopyNameAlterationType string   `json:"copyNameAlterationType"`
+	EvidenceTypes          []string `json:"evidenceTypes,omitempty"`
+	Gene                   Gene     `json:"gene"`
+	ID                     string   `json:"id"`
+	ReferenceGenome        string   `json:"referenceGenome"`
+	TumorType              string   `json:"tumorType"`
+}
+
+type OncoKBStructuralVariantRequest struct {
+	EvidenceTypes         []string `json:"evidenceTypes,omitempty"`
+	FunctionalFusion      bool     `json:"functionalFusion"`
+	GeneA                 Gene     `json:"geneA"`
+	GeneB                 Gene     `json:"geneB"`
+	ID                    string   `json:"id"`
+	ReferenceGenome       string   `json:"referenceGenome"`
+	StructuralVariantType string   `json:"structuralVariantType"`
+	TumorType             string   `json:"tumorType"`
+}
@@ -0,0 +1,431 @@
+package tempo_databricks_gateway
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// annFields are the fields of a snpEff ANN annotation, which unlike VEP's CSQ are fixed by the ANN specification.
+var annFields = []string{"Allele", "Annotation", "Annotation_Impact", "Gene_Name", "Gene_ID", "Feature_Type",
+	"Feature_ID", "Transcript_BioType", "Rank", "HGVS.c", "HGVS.p"}
+
+// aminoAcids maps the three letter amino acid codes of HGVSp to the one letter codes of HGVSp_Short.
+var aminoAcids = map[string]string{
+	"Ala": "A", "Arg": "R", "Asn": "N", "Asp": "D", "Cys": "C", "Gln": "Q", "Glu": "E", "Gly": "G", "His": "H",
+	"Ile": "I", "Leu": "L", "Lys": "K", "Met": "M", "Phe": "F", "Pro": "P", "Ser": "S", "Thr": "T", "Trp": "W",
+	"Tyr": "Y", "Val": "V", "Sec": "U", "Pyl": "O", "Xaa": "X", "Ter": "*",
+}
+
+// consequenceToVariantClass maps the Sequence Ontology consequences of VEP and snpEff to MAF Variant_Classifications
+// the way vcf2maf does, in order of severity.  Frameshifts and inframe indels are refined by the alleles.
+var consequenceToVariantClass = []struct {
+	consequence  string
+	variantClass string
+}{
+	{"transcript_ablation", "Splice_Site"},
+	{"exon_loss_variant", "Splice_Site"},
+	{"splice_donor_variant", "Splice_Site"},
+	{"splice_acceptor_variant", "Splice_Site"},
+	{"stop_gained", "Nonsense_Mutation"},
+	{"frameshift_variant", "Frame_Shift_"},
+	{"stop_lost", "Nonstop_Mutation"},
+	{"start_lost", "Translation_Start_Site"},
+	{"initiator_codon_variant", "Translation_Start_Site"},
+	{"disruptive_inframe_insertion", "In_Frame_Ins"},
+	{"disruptive_inframe_deletion", "In_Frame_Del"},
+	{"inframe_insertion", "In_Frame_Ins"},
+	{"inframe_deletion", "In_Frame_Del"},
+	{"protein_altering_variant", "In_Frame_"},
+	{"missense_variant", "Missense_Mutation"},
+	{"coding_sequence_variant", "Missense_Mutation"},
+	{"conservative_missense_variant", "Missense_Mutation"},
+	{"rare_amino_acid_variant", "Missense_Mutation"},
+	{"transcript_amplification", "Intron"},
+	{"splice_region_variant", "Splice_Region"},
+	{"incomplete_terminal_codon_variant", "Silent"},
+	{"synonymous_variant", "Silent"},
+	{"stop_retained_variant", "Silent"},
+	{"start_retained_variant", "Silent"},
+	{"mature_miRNA_variant", "RNA"},
+	{"non_coding_exon_variant", "RNA"},
+	{"non_coding_transcript_exon_variant", "RNA"},
+	{"5_prime_UTR_variant", "5'UTR"},
+	{"3_prime_UTR_variant", "3'UTR"},
+	{"upstream_gene_variant", "5'Flank"},
+	{"downstream_gene_variant", "3'Flank"},
+	{"intron_variant", "Intron"},
+	{"intergenic_variant", "IGR"},
+}
+
+// VCFRecord is a data line of a VCF with an event for each of its ALT alleles, so multi-allelic records are annotated
+// per ALT.  Events are nil for ALT alleles that cannot be annotated, like * and symbolic alleles.
+type VCFRecord struct {
+	Fields []string
+	Alts   []string
+	Events []*tt.Event
+}
+
+// VCFReader streams the records of a VCF 4.x file, building events from the VEP CSQ or snpEff ANN INFO field.
+type VCFReader struct {
+	scanner   *bufio.Scanner
+	meta      []string
+	header    []string
+	csqFields []string
+	ncbiBuild string
+	line      int
+}
+
+// NewVCFReader reads the meta-information and header lines of a VCF.  The CSQ layout comes from its ##INFO line,
+// and the reference genome from ##reference when it names a GRCh37 or GRCh38 build.
+func NewVCFReader(r io.Reader) (*VCFReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMAFLineSize)
+	v := &VCFReader{scanner: scanner}
+	for v.header == nil && scanner.Scan() {
+		v.line++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "##"):
+			v.meta = append(v.meta, line)
+			v.readMeta(line)
+		case strings.HasPrefix(line, "#CHROM"):
+			v.header = strings.Split(line, "\t")
+		default:
+			return nil, fmt.Errorf("Error reading VCF line %d: expected the #CHROM header", v.line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading VCF: %v", err)
+	}
+	if len(v.header) < 8 {
+		return nil, fmt.Errorf("Error reading VCF: missing #CHROM header")
+	}
+	return v, nil
+}
+
+func (v *VCFReader) readMeta(line string) {
+	if strings.HasPrefix(line, "##INFO=<ID=CSQ,") {
+		if _, format, found := strings.Cut(line, "Format: "); found {
+			v.csqFields = strings.Split(strings.TrimSuffix(strings.TrimSuffix(format, ">"), "\""), "|")
+		}
+	}
+	if reference, found := strings.CutPrefix(line, "##reference="); found {
+		reference = strings.ToLower(reference)
+		switch {
+		case strings.Contains(reference, "grch38") || strings.Contains(reference, "hg38"):
+			v.ncbiBuild = "GRCh38"
+		case strings.Contains(reference, "grch37") || strings.Contains(reference, "hg19") || strings.Contains(reference, "b37"):
+			v.ncbiBuild = "GRCh37"
+		}
+	}
+}
+
+// Meta returns the ## meta-information lines of the VCF.
+func (v *VCFReader) Meta() []string {
+	return v.meta
+}
+
+// Header returns the columns of the #CHROM header line.
+func (v *VCFReader) Header() []string {
+	return v.header
+}
+
+// Samples returns the sample columns of the VCF.
+func (v *VCFReader) Samples() []string {
+	if len(v.header) <= 9 {
+		return nil
+	}
+	return v.header[9:]
+}
+
+// Read returns the next record of the VCF, or io.EOF when there are no more.
+func (v *VCFReader) Read() (*VCFRecord, error) {
+	for v.scanner.Scan() {
+		v.line++
+		line := strings.TrimSuffix(v.scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("Error reading VCF line %d: %d fields but at least 8 are required", v.line, len(fields))
+		}
+		pos, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Error reading VCF line %d: invalid POS %q", v.line, fields[1])
+		}
+		record := &VCFRecord{Fields: fields, Alts: strings.Split(fields[4], ",")}
+		annotations := v.getAnnotations(fields[7])
+		vepAlleles := getVEPAlleles(fields[3], record.Alts)
+		for i, alt := range record.Alts {
+			record.Events = append(record.Events, v.getEvent(pos, fields[3], alt, vepAlleles[i], annotations))
+		}
+		return record, nil
+	}
+	if err := v.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading VCF line %d: %v", v.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+// getAnnotations returns the CSQ annotations of an INFO column, or the ANN annotations when there are none,
+// as maps of field name to value.
+func (v *VCFReader) getAnnotations(info string) []map[string]string {
+	var csq, ann []map[string]string
+	for _, kv := range strings.Split(info, ";") {
+		key, value, _ := strings.Cut(kv, "=")
+		switch {
+		case key == "CSQ" && v.csqFields != nil:
+			csq = parseAnnotations(v.csqFields, value)
+		case key == "ANN":
+			ann = parseAnnotations(annFields, value)
+		}
+	}
+	if len(csq) > 0 {
+		return csq
+	}
+	return ann
+}
+
+func parseAnnotations(names []string, value string) []map[string]string {
+	var annotations []map[string]string
+	for _, a := range strings.Split(value, ",") {
+		values := strings.Split(a, "|")
+		annotation := make(map[string]string, len(names))
+		for i, name := range names {
+			if i < len(values) {
+				annotation[name] = values[i]
+			}
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations
+}
+
+// getEvent builds the event of one ALT allele from the annotation of that allele, preferring the canonical transcript.
+// CSQ annotations name the allele as VEP writes it, vepAllele, and ANN annotations name the ALT allele itself.
+func (v *VCFReader) getEvent(pos int, ref, alt, vepAllele string, annotations []map[string]string) *tt.Event {
+	if alt == "*" || alt == "." || strings.HasPrefix(alt, "<") || strings.ContainsAny(alt, "[]") {
+		return nil
+	}
+	var chosen map[string]string
+	for _, a := range annotations {
+		allele := vepAllele
+		if _, isANN := a["Annotation"]; isANN {
+			allele = alt
+		}
+		if a["Allele"] != allele {
+			continue
+		}
+		if chosen == nil || (a["CANONICAL"] == "YES" && chosen["CANONICAL"] != "YES") {
+			chosen = a
+		}
+	}
+	e := &tt.Event{
+		StartPosition: strconv.Itoa(pos),
+		EndPosition:   strconv.Itoa(pos + len(ref) - 1),
+		NcbiBuild:     v.ncbiBuild,
+	}
+	if chosen == nil {
+		return e
+	}
+	e.HugoSymbol = chosen["SYMBOL"]
+	hgvsp := chosen["HGVSp"]
+	consequence := chosen["Consequence"]
+	if _, isANN := chosen["Annotation"]; isANN {
+		e.HugoSymbol = chosen["Gene_Name"]
+		hgvsp = chosen["HGVS.p"]
+		consequence = chosen["Annotation"]
+	}
+	e.HgvspShort = getHgvspShort(hgvsp)
+	e.VariantClassification = getVariantClassification(consequence, ref, alt)
+	if id := chosen["ENTREZ"]; id != "" {
+		e.EntrezGeneId = id
+	}
+	return e
+}
+
+// getVEPAlleles returns the ALT alleles the way VEP writes them in CSQ.  VEP looks at all the alleles of a record
+// together: when one of them is an indel and REF and every ALT start with the same base, that padding base is removed
+// from all of them, leaving - for an allele that is then empty.  * alleles are left out of the comparison and as they
+// are.
+func getVEPAlleles(ref string, alts []string) []string {
+	indel, padded := false, len(ref) > 0
+	for _, alt := range alts {
+		if strings.HasPrefix(alt, "*") {
+			continue
+		}
+		indel = indel || len(alt) != len(ref)
+		padded = padded && len(alt) > 0 && alt[0] == ref[0]
+	}
+	vepAlleles := make([]string, len(alts))
+	for i, alt := range alts {
+		vepAlleles[i] = alt
+		if indel && padded && !strings.HasPrefix(alt, "*") {
+			if vepAlleles[i] = alt[1:]; vepAlleles[i] == "" {
+				vepAlleles[i] = "-"
+			}
+		}
+	}
+	return vepAlleles
+}
+
+// getHgvspShort converts an HGVSp like ENSP00000288602.6:p.Val600Glu to the HGVSp_Short p.V600E of a MAF.
+func getHgvspShort(hgvsp string) string {
+	if i := strings.LastIndex(hgvsp, ":"); i >= 0 {
+		hgvsp = hgvsp[i+1:]
+	}
+	hgvsp = strings.ReplaceAll(hgvsp, "%3D", "=")
+	if !strings.HasPrefix(hgvsp, "p.") {
+		return ""
+	}
+	var short strings.Builder
+	short.WriteString("p.")
+	for rest := hgvsp[2:]; len(rest) > 0; {
+		if len(rest) >= 3 {
+			if aa, ok := aminoAcids[rest[:3]]; ok {
+				short.WriteString(aa)
+				rest = rest[3:]
+				continue
+			}
+		}
+		short.WriteByte(rest[0])
+		rest = rest[1:]
+	}
+	return short.String()
+}
+
+// getVariantClassification returns the MAF Variant_Classification of the most severe of the & separated consequences.
+func getVariantClassification(consequences, ref, alt string) string {
+	terms := strings.Split(consequences, "&")
+	for _, c := range consequenceToVariantClass {
+		for _, term := range terms {
+			if term != c.consequence {
+				continue
+			}
+			switch c.variantClass {
+			case "Frame_Shift_", "In_Frame_":
+				if len(alt) > len(ref) {
+					return c.variantClass + "Ins"
+				}
+				if c.variantClass == "In_Frame_" && len(alt) == len(ref) {
+					return "Missense_Mutation"
+				}
+				return c.variantClass + "Del"
+			}
+			return c.variantClass
+		}
+	}
+	if len(consequences) == 0 {
+		return ""
+	}
+	return "Targeted_Region"
+}
+
+// vcfInfoEscaper percent-encodes the characters VCF 4.3 reserves in INFO values.
+var vcfInfoEscaper = strings.NewReplacer("%", "%25", ";", "%3B", "=", "%3D", ",", "%2C", " ", "%20", "\t", "%09")
+
+// getVCFInfoID returns the INFO key of an OncoKB MAF column, like ONCOKB_HIGHEST_LEVEL.
+func getVCFInfoID(column string) string {
+	return "ONCOKB_" + strings.ToUpper(column)
+}
+
+// VCFWriter writes VCF records with the OncoKB results of each ALT allele as Number=A INFO fields.
+type VCFWriter struct {
+	w *bufio.Writer
+}
+
+// vcfFileformat is the first VCF version to define the percent-encoding of INFO values used for the OncoKB fields.
+const vcfFileformat = "##fileformat=VCFv4.3"
+
+// NewVCFWriter writes the meta-information lines, with an ##INFO line for each OncoKB column, and the header.
+// ##INFO lines for OncoKB columns already in meta are replaced, and a file format older than VCFv4.3 is raised
+// to VCFv4.3.
+func NewVCFWriter(w io.Writer, meta, header []string) (*VCFWriter, error) {
+	v := &VCFWriter{w: bufio.NewWriter(w)}
+	if len(meta) == 0 || !strings.HasPrefix(meta[0], "##fileformat=") {
+		v.w.WriteString(vcfFileformat + "\n")
+	}
+	for _, line := range meta {
+		if strings.HasPrefix(line, "##INFO=<ID=ONCOKB_") {
+			continue
+		}
+		if strings.HasPrefix(line, "##fileformat=") && isOlderVCFFileformat(line) {
+			line = vcfFileformat
+		}
+		v.w.WriteString(line + "\n")
+	}
+	for _, c := range OncoKBMAFColumns {
+		fmt.Fprintf(v.w, "##INFO=<ID=%s,Number=A,Type=String,Description=\"OncoKB %s\">\n", getVCFInfoID(c), c)
+	}
+	if _, err := v.w.WriteString(strings.Join(header, "\t") + "\n"); err != nil {
+		return nil, fmt.Errorf("Error writing VCF: %v", err)
+	}
+	return v, nil
+}
+
+// isOlderVCFFileformat reports whether the ##fileformat line names a VCF version before 4.3.
+func isOlderVCFFileformat(line string) bool {
+	major, minor, _ := strings.Cut(strings.TrimPrefix(line, "##fileformat=VCFv"), ".")
+	majorVersion, err := strconv.Atoi(major)
+	if err != nil {
+		return true
+	}
+	minorVersion, _ := strconv.Atoi(minor)
+	return majorVersion < 4 || (majorVersion == 4 && minorVersion < 3)
+}
+
+// Write writes a record with its OncoKB INFO fields, replacing any it already had.  Columns that are empty for every
+// ALT allele are left out.
+func (v *VCFWriter) Write(record *VCFRecord) error {
+	var info []string
+	if record.Fields[7] != "." {
+		for _, kv := range strings.Split(record.Fields[7], ";") {
+			if !strings.HasPrefix(kv, "ONCOKB_") {
+				info = append(info, kv)
+			}
+		}
+	}
+	values := make([][]string, len(record.Events))
+	for i, e := range record.Events {
+		if e != nil {
+			values[i] = GetOncoKBMAFValues(e)
+		}
+	}
+	for c, column := range OncoKBMAFColumns {
+		perAlt := make([]string, len(record.Events))
+		empty := true
+		for i := range record.Events {
+			perAlt[i] = "."
+			if values[i] != nil && values[i][c] != "" {
+				perAlt[i] = vcfInfoEscaper.Replace(values[i][c])
+				empty = false
+			}
+		}
+		if !empty {
+			info = append(info, getVCFInfoID(column)+"="+strings.Join(perAlt, ","))
+		}
+	}
+	fields := append([]string(nil), record.Fields...)
+	fields[7] = "."
+	if len(info) > 0 {
+		fields[7] = strings.Join(info, ";")
+	}
+	if _, err := v.w.WriteString(strings.Join(fields, "\t") + "\n"); err != nil {
+		return fmt.Errorf("Error writing VCF: %v", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (v *VCFWriter) Flush() error {
+	if err := v.w.Flush(); err != nil {
+		return fmt.Errorf("Error writing VCF: %v", err)
+	}
+	return nil
+}
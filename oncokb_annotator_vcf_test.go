@@ -0,0 +1,151 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testVCF = "##fileformat=VCFv4.2\n" +
+	"##reference=file:///data/GRCh37/Homo_sapiens_assembly19.fasta\n" +
+	"##INFO=<ID=DP,Number=1,Type=Integer,Description=\"Depth\">\n" +
+	"##INFO=<ID=CSQ,Number=.,Type=String,Description=\"Consequence annotations from Ensembl VEP. Format: Allele|Consequence|SYMBOL|HGVSp|CANONICAL\">\n" +
+	"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tTUMOR\tNORMAL\n" +
+	"7\t140453136\t.\tA\tT,C\t.\tPASS\tDP=50;CSQ=T|missense_variant|BRAF|ENSP00000288602.6:p.Val600Glu|YES," +
+	"C|missense_variant|BRAF|ENSP00000419060.1:p.Val600Gly|,C|missense_variant|BRAF|ENSP00000288602.6:p.Val600Gly|YES\tGT\t0/1\t0/0\n" +
+	"13\t32893302\t.\tC\tCA,*\t.\tPASS\tCSQ=A|frameshift_variant|BRCA2|ENSP00000369497.3:p.His52GlnfsTer16|YES\tGT\t0/1\t0/0\n"
+
+func TestVCFReader(t *testing.T) {
+	reader, err := NewVCFReader(strings.NewReader(testVCF))
+	if err != nil {
+		t.Fatalf("Failed to create VCFReader: %v", err)
+	}
+	if samples := reader.Samples(); len(samples) != 2 || samples[0] != "TUMOR" {
+		t.Errorf("expected the TUMOR and NORMAL samples but got %q", samples)
+	}
+
+	braf, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Failed to read VCF: %v", err)
+	}
+	if len(braf.Events) != 2 {
+		t.Fatalf("expected an event per ALT allele but got %d", len(braf.Events))
+	}
+	expected := [][3]string{{"BRAF", "p.V600E", "Missense_Mutation"}, {"BRAF", "p.V600G", "Missense_Mutation"}}
+	for i, e := range braf.Events {
+		got := [3]string{e.HugoSymbol, e.HgvspShort, e.VariantClassification}
+		if got != expected[i] {
+			t.Errorf("ALT %d: expected %q but got %q", i, expected[i], got)
+		}
+		if e.NcbiBuild != "GRCh37" || e.StartPosition != "140453136" || e.EndPosition != "140453136" {
+			t.Errorf("ALT %d: unexpected position %+v", i, e)
+		}
+	}
+
+	brca2, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Failed to read VCF: %v", err)
+	}
+	if e := brca2.Events[0]; e.HgvspShort != "p.H52Qfs*16" || e.VariantClassification != "Frame_Shift_Ins" {
+		t.Errorf("expected the padded insertion to match its CSQ allele but got %+v", e)
+	}
+	if brca2.Events[1] != nil {
+		t.Errorf("expected no event for the * allele but got %+v", brca2.Events[1])
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestVCFReaderMultiAllelicIndel(t *testing.T) {
+	// VEP removes the base all the alleles share, so the CSQ alleles are - and C, while AC alone would be kept as it is
+	vcf := "##fileformat=VCFv4.2\n" +
+		"##INFO=<ID=CSQ,Number=.,Type=String,Description=\"Consequence annotations from Ensembl VEP. Format: Allele|Consequence|SYMBOL|HGVSp|CANONICAL\">\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n" +
+		"13\t32893302\t.\tAT\tA,AC\t.\tPASS\tCSQ=-|frameshift_variant|BRCA2|ENSP00000369497.3:p.His52GlnfsTer16|YES," +
+		"C|missense_variant|BRCA2|ENSP00000369497.3:p.His52Pro|YES\n" +
+		// without a shared first base nothing is removed, so the insertion keeps its padding base
+		"7\t140453136\t.\tA\tT,AC\t.\tPASS\tCSQ=T|missense_variant|BRAF|ENSP00000288602.6:p.Val600Glu|YES," +
+		"AC|frameshift_variant|BRAF|ENSP00000288602.6:p.Val600GlyfsTer8|YES\n"
+	reader, err := NewVCFReader(strings.NewReader(vcf))
+	if err != nil {
+		t.Fatalf("Failed to create VCFReader: %v", err)
+	}
+	expected := [][]string{{"p.H52Qfs*16", "p.H52P"}, {"p.V600E", "p.V600Gfs*8"}}
+	for _, hgvsps := range expected {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Failed to read VCF: %v", err)
+		}
+		for i, e := range record.Events {
+			if e.HgvspShort != hgvsps[i] {
+				t.Errorf("%s ALT %d: expected %s but got %q", record.Fields[4], i, hgvsps[i], e.HgvspShort)
+			}
+		}
+	}
+	if alleles := getVEPAlleles("C", []string{"CA", "*"}); alleles[0] != "A" || alleles[1] != "*" {
+		t.Errorf("expected the * allele to be left out of the comparison but got %q", alleles)
+	}
+}
+
+func TestVCFReaderANN(t *testing.T) {
+	vcf := "##fileformat=VCFv4.2\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n" +
+		"22\t29091207\t.\tG\tA\t.\tPASS\tANN=A|missense_variant|MODERATE|CHEK2|CHEK2|transcript|NM_007194.4|protein_coding|11/15|c.1283C>T|p.Ser428Phe\n"
+	reader, err := NewVCFReader(strings.NewReader(vcf))
+	if err != nil {
+		t.Fatalf("Failed to create VCFReader: %v", err)
+	}
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Failed to read VCF: %v", err)
+	}
+	if e := record.Events[0]; e.HugoSymbol != "CHEK2" || e.HgvspShort != "p.S428F" || e.VariantClassification != "Missense_Mutation" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestVCFWriter(t *testing.T) {
+	reader, err := NewVCFReader(strings.NewReader(testVCF))
+	if err != nil {
+		t.Fatalf("Failed to create VCFReader: %v", err)
+	}
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Failed to read VCF: %v", err)
+	}
+	record.Events[0].OncokbAnnotated = "true"
+	record.Events[0].OncokbOncogenic = "Oncogenic"
+	record.Events[0].OncokbTxCitations = "1;2, Abstract=A"
+	record.Fields[7] += ";ONCOKB_ONCOGENIC=stale"
+
+	var out bytes.Buffer
+	writer, err := NewVCFWriter(&out, reader.Meta(), reader.Header())
+	if err != nil {
+		t.Fatalf("Failed to create VCFWriter: %v", err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatalf("Failed to write VCF: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush VCF: %v", err)
+	}
+
+	if n := strings.Count(out.String(), "##INFO=<ID=ONCOKB_"); n != len(OncoKBMAFColumns) {
+		t.Errorf("expected an ##INFO line per OncoKB column but got %d", n)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if lines[0] != "##fileformat=VCFv4.3" {
+		t.Errorf("expected the percent-encoded VCF to be VCFv4.3 but got %q", lines[0])
+	}
+	info := strings.Split(lines[len(lines)-1], "\t")[7]
+	for _, want := range []string{"DP=50", "ONCOKB_ANNOTATED=True,.", "ONCOKB_ONCOGENIC=Oncogenic,.", "ONCOKB_TX_CITATIONS=1%3B2%2C%20Abstract%3DA,."} {
+		if !strings.Contains(info, want) {
+			t.Errorf("expected INFO to contain %q but got %q", want, info)
+		}
+	}
+	if strings.Contains(info, "stale") || strings.Contains(info, "ONCOKB_LEVEL_1") {
+		t.Errorf("expected stale and empty OncoKB fields to be left out but got %q", info)
+	}
+}
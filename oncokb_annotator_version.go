@@ -0,0 +1,144 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionSkewPolicy decides what happens when OncoKB responses disagree on DataVersion or LastUpdate,
+// which happens when OncoKB deploys new data in the middle of a run.
+type VersionSkewPolicy int
+
+const (
+	// VersionSkewFail returns a VersionSkewError when the responses for a message disagree with each other
+	// or with the data version of the messages annotated before it by the same OncoKBAnnotatorService.
+	VersionSkewFail VersionSkewPolicy = iota
+	// VersionSkewReannotate annotates a message again, once, when its own responses disagree with each other.
+	// A message whose responses all agree on a newer data version than earlier messages is accepted
+	// and the newer version is expected from then on, which is what a long running service needs.
+	VersionSkewReannotate
+)
+
+// defaultDataVersionTTL is how long the data version of a run is trusted for cache hits before OncoKB is asked again.
+const defaultDataVersionTTL = 10 * time.Minute
+
+// ParseVersionSkewPolicy returns the VersionSkewPolicy named by s, fail or reannotate.
+func ParseVersionSkewPolicy(s string) (VersionSkewPolicy, error) {
+	switch strings.ToLower(s) {
+	case "fail":
+		return VersionSkewFail, nil
+	case "reannotate":
+		return VersionSkewReannotate, nil
+	}
+	return VersionSkewFail, fmt.Errorf("Error: unknown version skew policy %q, expected fail or reannotate", s)
+}
+
+// OncoKBDataVersion identifies the OncoKB data a response was computed from.
+type OncoKBDataVersion struct {
+	DataVersion string
+	LastUpdate  string
+}
+
+func (v OncoKBDataVersion) String() string {
+	return fmt.Sprintf("%s (last update %s)", v.DataVersion, v.LastUpdate)
+}
+
+// VersionSkewError is returned when OncoKB responses come from more than one data version.
+type VersionSkewError struct {
+	Expected OncoKBDataVersion
+	Found    OncoKBDataVersion
+}
+
+func (e *VersionSkewError) Error() string {
+	return fmt.Sprintf("OncoKB data version changed during the run, expected %s but found %s", e.Expected, e.Found)
+}
+
+// dataVersionTracker remembers the data version of a run.  It is shared by all copies of an
+// OncoKBAnnotatorService, so it covers every batch and every concurrent call.  The version is trusted for ttl after
+// OncoKB last confirmed it, after that cached responses are not used until OncoKB confirms it again.
+type dataVersionTracker struct {
+	mu      sync.Mutex
+	version *OncoKBDataVersion
+	checked time.Time
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newDataVersionTracker() *dataVersionTracker {
+	return &dataVersionTracker{ttl: defaultDataVersionTTL, now: time.Now}
+}
+
+// check pins version if no version has been seen yet, otherwise it makes sure version is the pinned one.
+// When repin is set a different version replaces the pinned one instead of being an error.
+// A version without a LastUpdate, as OncoKB /info returns it, matches any LastUpdate of the same DataVersion.
+func (d *dataVersionTracker) check(version OncoKBDataVersion, repin bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.version != nil && !sameDataVersion(*d.version, version) && !repin {
+		return &VersionSkewError{Expected: *d.version, Found: version}
+	}
+	if d.version == nil || len(d.version.LastUpdate) == 0 || !sameDataVersion(*d.version, version) {
+		d.version = &version
+	}
+	d.checked = d.now()
+	return nil
+}
+
+func sameDataVersion(a, b OncoKBDataVersion) bool {
+	return a.DataVersion == b.DataVersion && (len(a.LastUpdate) == 0 || len(b.LastUpdate) == 0 || a.LastUpdate == b.LastUpdate)
+}
+
+// get returns the pinned version, if there is one, and whether OncoKB confirmed it within the ttl.
+func (d *dataVersionTracker) get() (OncoKBDataVersion, bool, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.version == nil {
+		return OncoKBDataVersion{}, false, false
+	}
+	return *d.version, true, d.now().Sub(d.checked) < d.ttl
+}
+
+// getResponsesVersion returns the data version all the responses agree on.
+func getResponsesVersion(resp []OncoKBResponse) (OncoKBDataVersion, bool, error) {
+	var version OncoKBDataVersion
+	for i, r := range resp {
+		rVersion := OncoKBDataVersion{DataVersion: r.DataVersion, LastUpdate: r.LastUpdate}
+		if i == 0 {
+			version = rVersion
+		} else if rVersion != version {
+			return version, true, &VersionSkewError{Expected: version, Found: rVersion}
+		}
+	}
+	return version, len(resp) > 0, nil
+}
+
+// getConsistentResponses gets the responses for requests and makes sure they all come from
+// the data version of the run, applying the VersionSkewPolicy when they do not.
+func (o OncoKBAnnotatorService) getConsistentResponses(ctx context.Context, url string, requests []oncoKBRequest) ([]OncoKBResponse, error) {
+	oncoKBResponse, err := o.getCachedResponses(ctx, url, requests)
+	if err != nil {
+		return nil, err
+	}
+	version, exists, err := getResponsesVersion(oncoKBResponse)
+	if err != nil && o.versionSkewPolicy == VersionSkewReannotate {
+		// skip the cache, the cached responses may be the ones from the old version
+		oncoKBResponse, err = o.getResponses(ctx, url, requests)
+		if err != nil {
+			return nil, err
+		}
+		version, exists, err = getResponsesVersion(oncoKBResponse)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return oncoKBResponse, nil
+	}
+	if err := o.dataVersion.check(version, o.versionSkewPolicy == VersionSkewReannotate); err != nil {
+		return nil, err
+	}
+	return oncoKBResponse, nil
+}
@@ -0,0 +1,113 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+)
+
+// newVersionServer answers each OncoKB request with the data version returned by nextVersion.
+func newVersionServer(t testing.TB, nextVersion func() string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []OncoKBMutationRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Errorf("Failed to decode OncoKB request: %v", err)
+		}
+		version := nextVersion()
+		var resp []OncoKBResponse
+		for _, req := range requests {
+			resp = append(resp, OncoKBResponse{Query: Query{ID: req.ID}, DataVersion: version, LastUpdate: "01/01/2025"})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func newVersionTestMessage() *tt.TempoMessage {
+	return &tt.TempoMessage{
+		OncotreeCode: "IDC",
+		Events: []*tt.Event{
+			&tt.Event{HugoSymbol: "BRCA2", HgvspShort: "p.H52Qfs*16", VariantClassification: "Frame_Shift_Ins"},
+			&tt.Event{HugoSymbol: "CHEK2", HgvspShort: "p.S428F", VariantClassification: "Missense_Mutation"},
+		},
+	}
+}
+
+func TestVersionSkewWithinMessage(t *testing.T) {
+	var mu sync.Mutex
+	versions := []string{"v4.22", "v4.23", "v4.22", "v4.23", "v4.23", "v4.23"}
+	server := newVersionServer(t, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		v := versions[0]
+		versions = versions[1:]
+		return v
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithBatchSize(1))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	var skew *VersionSkewError
+	if err := o.AnnotateMutations(ctx, newVersionTestMessage()); !errors.As(err, &skew) {
+		t.Fatalf("expected a VersionSkewError but got %v", err)
+	}
+
+	o, err = NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithBatchSize(1), WithVersionSkewPolicy(VersionSkewReannotate))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	tm := newVersionTestMessage()
+	if err := o.AnnotateMutations(ctx, tm); err != nil {
+		t.Fatalf("expected the message to be annotated again but got %v", err)
+	}
+	if tm.OncokbDataVersion != "v4.23" {
+		t.Errorf("expected data version %q but got %q", "v4.23", tm.OncokbDataVersion)
+	}
+}
+
+func TestVersionSkewAcrossMessages(t *testing.T) {
+	version := "v4.22"
+	server := newVersionServer(t, func() string { return version })
+	defer server.Close()
+
+	ctx := context.Background()
+	o, err := NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange")
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	if err := o.AnnotateMutations(ctx, newVersionTestMessage()); err != nil {
+		t.Fatalf("Failed to annotate message: %v", err)
+	}
+	version = "v4.23"
+	var skew *VersionSkewError
+	if err := o.AnnotateMutations(ctx, newVersionTestMessage()); !errors.As(err, &skew) {
+		t.Fatalf("expected a VersionSkewError but got %v", err)
+	}
+	if skew.Expected.DataVersion != "v4.22" || skew.Found.DataVersion != "v4.23" {
+		t.Errorf("unexpected versions in %v", skew)
+	}
+
+	// a long running service moves to a new data release instead of failing every message from then on
+	version = "v4.22"
+	o, err = NewOncoKBAnnotatorService(StaticToken("token"), server.URL+"/byProteinChange", WithVersionSkewPolicy(VersionSkewReannotate))
+	if err != nil {
+		t.Fatalf("Failed to create an OncoKBAnnotatorService: %v", err)
+	}
+	for _, version = range []string{"v4.22", "v4.23", "v4.23"} {
+		tm := newVersionTestMessage()
+		if err := o.AnnotateMutations(ctx, tm); err != nil || tm.OncokbDataVersion != version {
+			t.Errorf("expected the message to be annotated with %s but got %q (%v)", version, tm.OncokbDataVersion, err)
+		}
+	}
+	if policy, err := ParseVersionSkewPolicy("Reannotate"); err != nil || policy != VersionSkewReannotate {
+		t.Errorf("expected reannotate to be parsed but got %v (%v)", policy, err)
+	}
+}
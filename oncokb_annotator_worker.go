@@ -0,0 +1,344 @@
+package tempo_databricks_gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// BrokerMessage is a message of a topic.  Partition and Offset are its position in the topic, set by the broker.
+type BrokerMessage struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// Broker is the message queue a Worker consumes from and publishes to.  KafkaBroker implements it for Kafka, and
+// MemoryBroker is an in-process stand-in for tests.
+type Broker interface {
+	// Subscribe starts consuming topic as part of group, from the offset the group last committed.
+	Subscribe(ctx context.Context, topic, group string) (Subscription, error)
+	Publish(ctx context.Context, topic string, msg BrokerMessage) error
+}
+
+// Subscription delivers the messages of a topic in order.  Messages that are fetched but not committed
+// are delivered again to the next subscription of the group.
+type Subscription interface {
+	// Fetch blocks until the next message is available or ctx is done.
+	Fetch(ctx context.Context) (*BrokerMessage, error)
+	// Commit records that msg, and every message before it, has been processed.
+	Commit(ctx context.Context, msg *BrokerMessage) error
+	Close() error
+}
+
+const (
+	// ErrorHeader carries the reason a message was sent to the dead-letter topic.
+	ErrorHeader = "x-annotation-error"
+	// SourcePartitionHeader and SourceOffsetHeader carry the position of the input message a dead-lettered message
+	// came from.
+	SourcePartitionHeader = "x-source-partition"
+	SourceOffsetHeader    = "x-source-offset"
+)
+
+// Worker annotates the TempoMessages of an input topic and publishes them to an output topic.  The input offset is
+// only committed once a message has been published, so a worker that stops is picked up where it left off.
+// Messages that cannot be decoded, or whose events cannot be turned into OncoKB requests, are poison and go to the
+// dead-letter topic instead.  OncoKB outages are retried, and any other error, like a rejected token or a data version
+// skew, stops the worker without committing, as it would fail every message after it too.
+type Worker struct {
+	broker          Broker
+	annotator       Annotator
+	inputTopic      string
+	outputTopic     string
+	deadLetterTopic string
+	group           string
+	format          MessageFormat
+	attempts        int
+	backoff         time.Duration
+	logger          *slog.Logger
+	annotationTypes string
+	annotate        []AnnotateFunc
+}
+
+// WorkerOption configures a Worker.
+type WorkerOption func(*Worker)
+
+// WithDeadLetterTopic sets the topic poison messages are published to, the input topic with a .dlq suffix by default.
+func WithDeadLetterTopic(topic string) WorkerOption {
+	return func(w *Worker) {
+		w.deadLetterTopic = topic
+	}
+}
+
+// WithConsumerGroup sets the consumer group of the worker, oncokb-annotator by default.
+func WithConsumerGroup(group string) WorkerOption {
+	return func(w *Worker) {
+		w.group = group
+	}
+}
+
+// WithMessageFormat sets how the TempoMessages of the topics are serialized, binary protobuf by default.
+func WithMessageFormat(format MessageFormat) WorkerOption {
+	return func(w *Worker) {
+		w.format = format
+	}
+}
+
+// WithWorkerRetries retries OncoKB outages attempts times, waiting backoff, then twice as long, and so on, with
+// NewRetryingAnnotator.  1 does not retry, for an annotator that already does.
+func WithWorkerRetries(attempts int, backoff time.Duration) WorkerOption {
+	return func(w *Worker) {
+		w.attempts = max(attempts, 1)
+		w.backoff = backoff
+	}
+}
+
+// WithWorkerLogger logs the retried annotations and the dead-lettered messages to logger.
+func WithWorkerLogger(logger *slog.Logger) WorkerOption {
+	return func(w *Worker) {
+		w.logger = logger
+	}
+}
+
+// WithWorkerAnnotations sets the annotations run on each message, mutations, cna and sv, mutations by default.
+// NewWorker fails on any other type.
+func WithWorkerAnnotations(types ...string) WorkerOption {
+	return func(w *Worker) {
+		w.annotationTypes = strings.Join(types, ",")
+	}
+}
+
+// NewWorker returns a worker annotating the messages of inputTopic into outputTopic.
+func NewWorker(broker Broker, annotator Annotator, inputTopic, outputTopic string, opts ...WorkerOption) (*Worker, error) {
+	w := &Worker{
+		broker:          broker,
+		annotator:       annotator,
+		inputTopic:      inputTopic,
+		outputTopic:     outputTopic,
+		deadLetterTopic: inputTopic + ".dlq",
+		group:           "oncokb-annotator",
+		format:          MessageFormatProto,
+		attempts:        5,
+		backoff:         time.Second,
+		logger:          discardLogger,
+		annotationTypes: "mutations",
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.logger == nil {
+		w.logger = discardLogger
+	}
+	if w.attempts > 1 {
+		w.annotator = NewRetryingAnnotator(annotator, w.attempts, w.backoff, WithRetryLogger(w.logger))
+	}
+	annotate, err := ParseAnnotationTypes(w.annotationTypes)
+	if err != nil {
+		return nil, err
+	}
+	w.annotate = annotate
+	return w, nil
+}
+
+// Run processes messages until ctx is done, which returns nil, or a message cannot be processed or dead-lettered.
+func (w *Worker) Run(ctx context.Context) error {
+	sub, err := w.broker.Subscribe(ctx, w.inputTopic, w.group)
+	if err != nil {
+		return fmt.Errorf("Error subscribing to %s: %v", w.inputTopic, err)
+	}
+	defer sub.Close()
+	for {
+		msg, err := sub.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("Error fetching from %s: %v", w.inputTopic, err)
+		}
+		if err := w.process(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := sub.Commit(ctx, msg); err != nil {
+			return fmt.Errorf("Error committing %s partition %d offset %d: %v", w.inputTopic, msg.Partition, msg.Offset, err)
+		}
+	}
+}
+
+// process publishes msg annotated to the output topic, or to the dead-letter topic when it is poison.
+func (w *Worker) process(ctx context.Context, msg *BrokerMessage) error {
+	message := &tt.TempoMessage{}
+	if err := w.unmarshal(msg.Value, message); err != nil {
+		return w.deadLetter(ctx, msg, fmt.Errorf("Error decoding TempoMessage: %v", err))
+	}
+
+	if id := msg.Headers[CorrelationIDMetadataKey]; id != "" {
+		ctx = WithCorrelationID(ctx, id)
+	} else {
+		// every retry of the message is logged with the same correlation ID
+		ctx, _ = ensureCorrelationID(ctx)
+	}
+	if err := w.annotateMessage(ctx, message); err != nil {
+		var invalid *InvalidMessageError
+		if errors.As(err, &invalid) && ctx.Err() == nil {
+			return w.deadLetter(ctx, msg, err)
+		}
+		return fmt.Errorf("Error annotating %s partition %d offset %d: %w", w.inputTopic, msg.Partition, msg.Offset, err)
+	}
+
+	value, err := w.marshal(message)
+	if err != nil {
+		return w.deadLetter(ctx, msg, fmt.Errorf("Error encoding TempoMessage: %v", err))
+	}
+	out := BrokerMessage{Key: msg.Key, Value: value, Headers: msg.Headers}
+	if err := w.broker.Publish(ctx, w.outputTopic, out); err != nil {
+		return fmt.Errorf("Error publishing to %s: %v", w.outputTopic, err)
+	}
+	return nil
+}
+
+func (w *Worker) annotateMessage(ctx context.Context, message *tt.TempoMessage) error {
+	for _, a := range w.annotate {
+		if err := a(w.annotator, ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Worker) deadLetter(ctx context.Context, msg *BrokerMessage, reason error) error {
+	headers := make(map[string]string, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[ErrorHeader] = reason.Error()
+	headers[SourcePartitionHeader] = fmt.Sprint(msg.Partition)
+	headers[SourceOffsetHeader] = fmt.Sprint(msg.Offset)
+	if err := w.broker.Publish(ctx, w.deadLetterTopic, BrokerMessage{Key: msg.Key, Value: msg.Value, Headers: headers}); err != nil {
+		return fmt.Errorf("Error publishing to %s: %v", w.deadLetterTopic, err)
+	}
+	w.logger.WarnContext(ctx, "message dead-lettered",
+		slog.String("correlation_id", CorrelationID(ctx)),
+		slog.String("topic", w.inputTopic),
+		slog.Int("partition", msg.Partition),
+		slog.Int64("offset", msg.Offset),
+		slog.String("error", reason.Error()))
+	return nil
+}
+
+func (w *Worker) unmarshal(b []byte, message *tt.TempoMessage) error {
+	if w.format == MessageFormatNDJSON {
+		return protojson.Unmarshal(b, message)
+	}
+	return proto.Unmarshal(b, message)
+}
+
+func (w *Worker) marshal(message *tt.TempoMessage) ([]byte, error) {
+	if w.format == MessageFormatNDJSON {
+		return protojson.Marshal(message)
+	}
+	return proto.Marshal(message)
+}
+
+// ErrSubscriptionClosed is returned by Fetch on a closed MemoryBroker subscription.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// MemoryBroker is an in-process Broker for tests and local runs.  Each topic is a single ordered partition and
+// committed offsets are kept per consumer group, so a new subscription redelivers what was not committed.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	changed   chan struct{}
+	topics    map[string][]BrokerMessage
+	committed map[string]int64
+}
+
+// NewMemoryBroker returns an empty MemoryBroker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{changed: make(chan struct{}), topics: make(map[string][]BrokerMessage), committed: make(map[string]int64)}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, msg BrokerMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg.Topic = topic
+	msg.Offset = int64(len(b.topics[topic]))
+	b.topics[topic] = append(b.topics[topic], msg)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic, group string) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &memorySubscription{broker: b, topic: topic, group: group, next: b.committed[group+"/"+topic], closed: make(chan struct{})}, nil
+}
+
+// Messages returns the messages published to topic.
+func (b *MemoryBroker) Messages(topic string) []BrokerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BrokerMessage(nil), b.topics[topic]...)
+}
+
+// Committed returns the offset group will resume topic from.
+func (b *MemoryBroker) Committed(topic, group string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.committed[group+"/"+topic]
+}
+
+type memorySubscription struct {
+	broker    *MemoryBroker
+	topic     string
+	group     string
+	next      int64
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *memorySubscription) Fetch(ctx context.Context) (*BrokerMessage, error) {
+	for {
+		s.broker.mu.Lock()
+		messages, changed := s.broker.topics[s.topic], s.broker.changed
+		s.broker.mu.Unlock()
+		if s.next < int64(len(messages)) {
+			msg := messages[s.next]
+			s.next++
+			return &msg, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.closed:
+			return nil, ErrSubscriptionClosed
+		case <-changed:
+		}
+	}
+}
+
+func (s *memorySubscription) Commit(ctx context.Context, msg *BrokerMessage) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	key := s.group + "/" + s.topic
+	s.broker.committed[key] = max(s.broker.committed[key], msg.Offset+1)
+	return nil
+}
+
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
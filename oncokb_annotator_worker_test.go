@@ -0,0 +1,158 @@
+package tempo_databricks_gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	tt "github.mskcc.org/cdsi/cdsi-protobuf/tempo/generated/v1/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// funcAnnotator annotates mutations with a function.
+type funcAnnotator func(*tt.TempoMessage) error
+
+func (f funcAnnotator) AnnotateMutations(ctx context.Context, message *tt.TempoMessage) error {
+	return f(message)
+}
+
+func (f funcAnnotator) AnnotateCopyNumberAlterations(ctx context.Context, message *tt.TempoMessage) error {
+	return f(message)
+}
+
+func (f funcAnnotator) AnnotateStructuralVariants(ctx context.Context, message *tt.TempoMessage) error {
+	return f(message)
+}
+
+func publishTestMessage(t *testing.T, broker *MemoryBroker, topic string, message *tt.TempoMessage) {
+	value, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	broker.Publish(context.Background(), topic, BrokerMessage{Key: []byte(message.CmoSampleId), Value: value})
+}
+
+func newTestWorker(t *testing.T, broker Broker, annotator Annotator, opts ...WorkerOption) *Worker {
+	worker, err := NewWorker(broker, annotator, "tempo", "tempo.annotated", opts...)
+	if err != nil {
+		t.Fatalf("Failed to create worker: %v", err)
+	}
+	return worker
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorker(t *testing.T) {
+	broker := NewMemoryBroker()
+	annotator := funcAnnotator(func(message *tt.TempoMessage) error {
+		if message.CmoSampleId == "bad" {
+			return &InvalidMessageError{Err: errors.New("An unknown variant classification has been encountered: Exotic")}
+		}
+		for _, e := range message.Events {
+			e.OncokbAnnotated = "true"
+		}
+		return nil
+	})
+	publishTestMessage(t, broker, "tempo", &tt.TempoMessage{CmoSampleId: "S1", Events: []*tt.Event{{HugoSymbol: "BRAF"}}})
+	broker.Publish(context.Background(), "tempo", BrokerMessage{Value: []byte("not a protobuf \xff\xff")})
+	publishTestMessage(t, broker, "tempo", &tt.TempoMessage{CmoSampleId: "bad"})
+	publishTestMessage(t, broker, "tempo", &tt.TempoMessage{CmoSampleId: "S2", Events: []*tt.Event{{HugoSymbol: "KRAS"}}})
+
+	worker := newTestWorker(t, broker, annotator)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- worker.Run(ctx) }()
+	waitFor(t, func() bool { return broker.Committed("tempo", "oncokb-annotator") == 4 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected a cancelled worker to stop cleanly but got %v", err)
+	}
+
+	out := broker.Messages("tempo.annotated")
+	if len(out) != 2 {
+		t.Fatalf("expected 2 annotated messages but got %d", len(out))
+	}
+	for i, sample := range []string{"S1", "S2"} {
+		message := &tt.TempoMessage{}
+		if err := proto.Unmarshal(out[i].Value, message); err != nil {
+			t.Fatalf("Failed to unmarshal output: %v", err)
+		}
+		if message.CmoSampleId != sample || message.Events[0].OncokbAnnotated != "true" || string(out[i].Key) != sample {
+			t.Errorf("expected %s to be annotated but got %v", sample, message)
+		}
+	}
+
+	dlq := broker.Messages("tempo.dlq")
+	if len(dlq) != 2 {
+		t.Fatalf("expected 2 dead-lettered messages but got %d", len(dlq))
+	}
+	if dlq[0].Headers[SourceOffsetHeader] != "1" || dlq[1].Headers[SourceOffsetHeader] != "2" || dlq[1].Headers[ErrorHeader] == "" {
+		t.Errorf("expected the poison messages with their offsets and errors but got %+v", dlq)
+	}
+}
+
+func TestWorkerOutage(t *testing.T) {
+	broker := NewMemoryBroker()
+	publishTestMessage(t, broker, "tempo", &tt.TempoMessage{CmoSampleId: "S1"})
+
+	calls := 0
+	outage := funcAnnotator(func(message *tt.TempoMessage) error {
+		calls++
+		return &OncoKBAPIError{StatusCode: 503}
+	})
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, nil))
+	err := newTestWorker(t, broker, outage, WithWorkerRetries(3, time.Millisecond), WithWorkerLogger(logger)).Run(context.Background())
+	if err == nil || calls != 3 {
+		t.Fatalf("expected the worker to stop after 3 attempts but got %d attempts and %v", calls, err)
+	}
+	if n := strings.Count(log.String(), "annotation retried"); n != 2 {
+		t.Errorf("expected 2 retries to be logged but got %d in %q", n, log.String())
+	}
+	if broker.Committed("tempo", "oncokb-annotator") != 0 || len(broker.Messages("tempo.dlq")) != 0 {
+		t.Errorf("expected an outage to neither commit nor dead-letter the message")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	healthy := funcAnnotator(func(message *tt.TempoMessage) error { return nil })
+	go newTestWorker(t, broker, healthy).Run(ctx)
+	waitFor(t, func() bool { return len(broker.Messages("tempo.annotated")) == 1 })
+	waitFor(t, func() bool { return broker.Committed("tempo", "oncokb-annotator") == 1 })
+}
+
+func TestWorkerStops(t *testing.T) {
+	// errors that are not the message's fail every message after it too, so they stop the worker instead of
+	// dead-lettering the topic
+	for _, err := range []error{
+		&OncoKBAPIError{StatusCode: 401},
+		&VersionSkewError{Expected: OncoKBDataVersion{DataVersion: "v4.22"}, Found: OncoKBDataVersion{DataVersion: "v4.23"}},
+		errors.New("Error reading OncoKB responses"),
+	} {
+		broker := NewMemoryBroker()
+		publishTestMessage(t, broker, "tempo", &tt.TempoMessage{CmoSampleId: "S1"})
+		failing := funcAnnotator(func(message *tt.TempoMessage) error { return err })
+		if runErr := newTestWorker(t, broker, failing).Run(context.Background()); !errors.Is(runErr, err) {
+			t.Errorf("expected the worker to stop with %v but got %v", err, runErr)
+		}
+		if broker.Committed("tempo", "oncokb-annotator") != 0 || len(broker.Messages("tempo.dlq")) != 0 {
+			t.Errorf("expected %v to neither commit nor dead-letter the message", err)
+		}
+	}
+
+	if _, err := NewWorker(NewMemoryBroker(), funcAnnotator(nil), "tempo", "tempo.annotated", WithWorkerAnnotations("mutations", "expression")); err == nil {
+		t.Errorf("expected an unknown annotation type to be an error")
+	}
+}
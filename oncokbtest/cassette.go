@@ -0,0 +1,254 @@
+package oncokbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// Interaction is a recorded request to the OncoKB API and the response it got.  Headers are not recorded,
+// so the token sent in the Authorization header never ends up in a cassette.
+type Interaction struct {
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	RequestBody  json.RawMessage `json:"requestBody"`
+	Status       int             `json:"status"`
+	ResponseBody json.RawMessage `json:"responseBody"`
+}
+
+// Cassette is the OncoKB traffic recorded by a Recorder, in the order it happened.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read cassette %q: %v", path, err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(jsonData, &c); err != nil {
+		return nil, fmt.Errorf("Failed to parse cassette %q: %v", path, err)
+	}
+	return &c, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	return saveJSON(path, c)
+}
+
+// Fixtures splits the successful interactions into a Fixture per query, matching each query to the response
+// with the same id, so a Server can answer the queries in any combination.
+func (c *Cassette) Fixtures() ([]Fixture, error) {
+	var fixtures []Fixture
+	for _, i := range c.Interactions {
+		if i.Status != http.StatusOK {
+			continue
+		}
+		var queries, responses []json.RawMessage
+		if err := json.Unmarshal(i.RequestBody, &queries); err != nil {
+			return nil, fmt.Errorf("Recorded request to %s is not a JSON array: %v", i.Path, err)
+		}
+		if err := json.Unmarshal(i.ResponseBody, &responses); err != nil {
+			return nil, fmt.Errorf("Recorded response from %s is not a JSON array: %v", i.Path, err)
+		}
+		responsesByID := make(map[string]json.RawMessage, len(responses))
+		for _, r := range responses {
+			var resp struct {
+				Query struct {
+					ID string `json:"id"`
+				} `json:"query"`
+			}
+			if err := json.Unmarshal(r, &resp); err == nil {
+				responsesByID[resp.Query.ID] = r
+			}
+		}
+		for _, q := range queries {
+			var query struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(q, &query); err != nil {
+				return nil, err
+			}
+			if r, exists := responsesByID[query.ID]; exists {
+				fixtures = append(fixtures, Fixture{Path: i.Path, Request: q, Response: r})
+			}
+		}
+	}
+	return fixtures, nil
+}
+
+// SaveFixtures writes fixtures as the JSON array Server.LoadFixtures reads.
+func SaveFixtures(path string, fixtures []Fixture) error {
+	return saveJSON(path, fixtures)
+}
+
+func saveJSON(path string, v any) error {
+	jsonData, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(jsonData, '\n'), 0o644)
+}
+
+// Recorder is an http.RoundTripper that sends requests on to next and records every interaction.
+type Recorder struct {
+	next     http.RoundTripper
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder records the traffic sent through next, http.DefaultTransport when next is nil.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		if requestBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	token := strings.TrimSpace(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Method:       req.Method,
+		Path:         redact(req.URL.Path, token),
+		RequestBody:  toRawJSON(redact(string(requestBody), token)),
+		Status:       resp.StatusCode,
+		ResponseBody: toRawJSON(redact(string(responseBody), token)),
+	})
+	return resp, nil
+}
+
+// Cassette returns a copy of what has been recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+func redact(s, token string) string {
+	if len(token) == 0 {
+		return s
+	}
+	return strings.ReplaceAll(s, token, redacted)
+}
+
+// toRawJSON keeps bodies that are not JSON, like an html error page, as a JSON string.
+func toRawJSON(body string) json.RawMessage {
+	if json.Valid([]byte(body)) && len(strings.TrimSpace(body)) > 0 {
+		return json.RawMessage(body)
+	}
+	quoted, _ := json.Marshal(body)
+	return quoted
+}
+
+// Replayer is an http.RoundTripper that answers requests from a Cassette without any network traffic.
+// Requests are matched on method, path and JSON body.  Identical requests get their recorded responses
+// in the order they were recorded, the last one being repeated once they run out.
+type Replayer struct {
+	mu     sync.Mutex
+	byKey  map[string][]Interaction
+	served map[string]int
+}
+
+func NewReplayer(c *Cassette) (*Replayer, error) {
+	r := &Replayer{byKey: make(map[string][]Interaction), served: make(map[string]int)}
+	for _, i := range c.Interactions {
+		key, err := getInteractionKey(i.Method, i.Path, i.RequestBody)
+		if err != nil {
+			return nil, err
+		}
+		r.byKey[key] = append(r.byKey[key], i)
+	}
+	return r, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		if requestBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	key, err := getInteractionKey(req.Method, req.URL.Path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	recorded, exists := r.byKey[key]
+	served := r.served[key]
+	r.served[key]++
+	r.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("No recorded interaction for %s %s", req.Method, req.URL.Path)
+	}
+	i := recorded[min(served, len(recorded)-1)]
+
+	body := []byte(i.ResponseBody)
+	var text string
+	if json.Unmarshal(i.ResponseBody, &text) == nil {
+		// a body that was not JSON when it was recorded
+		body = []byte(text)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func getInteractionKey(method, path string, body []byte) (string, error) {
+	normalized := ""
+	if len(bytes.TrimSpace(body)) > 0 {
+		var v any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			// not JSON, match it as is
+			normalized = string(body)
+		} else {
+			normalizedJSON, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			normalized = string(normalizedJSON)
+		}
+	}
+	return method + " " + path + " " + normalized, nil
+}
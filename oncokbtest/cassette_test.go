@@ -0,0 +1,91 @@
+package oncokbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.FailNext(1, http.StatusServiceUnavailable, "down for maintenance")
+
+	recorder := NewRecorder(nil)
+	client := &http.Client{Transport: recorder}
+	body := `[{"id": "0", "alteration": "V600E", "gene": {"hugoSymbol": "BRAF"}, "referenceGenome": "GRCh37"}]`
+	send := func(client *http.Client) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, server.MutationsURL(), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer s3cr3t-token")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+	firstStatus, _ := send(client)
+	secondStatus, secondBody := send(client)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Cassette().Save(path); err != nil {
+		t.Fatalf("Failed to save cassette: %v", err)
+	}
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+	replayer, err := NewReplayer(cassette)
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %v", err)
+	}
+	server.Close()
+
+	replayClient := &http.Client{Transport: replayer}
+	if status, _ := send(replayClient); status != firstStatus {
+		t.Errorf("expected replayed status %d but got %d", firstStatus, status)
+	}
+	// saving the cassette reindents the recorded bodies, so compare them compacted
+	if status, body := send(replayClient); status != secondStatus || compact(t, body) != compact(t, secondBody) {
+		t.Errorf("expected replayed %d %q but got %d %q", secondStatus, secondBody, status, body)
+	}
+
+	fixtures, err := cassette.Fixtures()
+	if err != nil || len(fixtures) != 1 {
+		t.Errorf("expected one fixture from the successful interaction but got %d: %v", len(fixtures), err)
+	}
+}
+
+func TestRecorderRedactsToken(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	recorder := NewRecorder(nil)
+	req, _ := http.NewRequest(http.MethodPost, server.MutationsURL(), strings.NewReader(`[{"id": "0", "alteration": "s3cr3t-token", "gene": {"hugoSymbol": "BRAF"}}]`))
+	req.Header.Set("Authorization", "Bearer s3cr3t-token")
+	resp, err := (&http.Client{Transport: recorder}).Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	for _, i := range recorder.Cassette().Interactions {
+		if strings.Contains(string(i.RequestBody), "s3cr3t-token") || strings.Contains(string(i.ResponseBody), "s3cr3t-token") {
+			t.Errorf("token was not redacted from %+v", i)
+		}
+	}
+}
+
+func compact(t *testing.T, body string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(body)); err != nil {
+		t.Fatalf("Failed to compact %q: %v", body, err)
+	}
+	return buf.String()
+}
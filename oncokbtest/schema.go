@@ -0,0 +1,121 @@
+package oncokbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// the request bodies accepted by the OncoKB annotate endpoints, anything else is rejected
+
+type gene struct {
+	EntrezGeneID *int    `json:"entrezGeneId"`
+	HugoSymbol   *string `json:"hugoSymbol"`
+}
+
+type mutationRequest struct {
+	Alteration      string   `json:"alteration"`
+	Consequence     string   `json:"consequence"`
+	EvidenceTypes   []string `json:"evidenceTypes"`
+	Gene            gene     `json:"gene"`
+	ID              string   `json:"id"`
+	ProteinEnd      *int     `json:"proteinEnd"`
+	ProteinStart    *int     `json:"proteinStart"`
+	ReferenceGenome string   `json:"referenceGenome"`
+	TumorType       string   `json:"tumorType"`
+}
+
+type copyNumberAlterationRequest struct {
+	CopyNameAlterationType string   `json:"copyNameAlterationType"`
+	EvidenceTypes          []string `json:"evidenceTypes"`
+	Gene                   gene     `json:"gene"`
+	ID                     string   `json:"id"`
+	ReferenceGenome        string   `json:"referenceGenome"`
+	TumorType              string   `json:"tumorType"`
+}
+
+type structuralVariantRequest struct {
+	EvidenceTypes         []string `json:"evidenceTypes"`
+	FunctionalFusion      bool     `json:"functionalFusion"`
+	GeneA                 gene     `json:"geneA"`
+	GeneB                 gene     `json:"geneB"`
+	ID                    string   `json:"id"`
+	ReferenceGenome       string   `json:"referenceGenome"`
+	StructuralVariantType string   `json:"structuralVariantType"`
+	TumorType             string   `json:"tumorType"`
+}
+
+var referenceGenomes = map[string]bool{"": true, "GRCh37": true, "GRCh38": true}
+var copyNumberAlterationTypes = map[string]bool{"AMPLIFICATION": true, "DELETION": true, "GAIN": true, "LOSS": true}
+var structuralVariantTypes = map[string]bool{
+	"DELETION": true, "TRANSLOCATION": true, "DUPLICATION": true, "INSERTION": true,
+	"INVERSION": true, "FUSION": true, "UNKNOWN": true,
+}
+
+func decodeStrict(q json.RawMessage, target any) error {
+	decoder := json.NewDecoder(bytes.NewReader(q))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("JSON parse error: %v", err)
+	}
+	return nil
+}
+
+func validateGene(field string, g gene) error {
+	if (g.HugoSymbol == nil || len(*g.HugoSymbol) == 0) && (g.EntrezGeneID == nil || *g.EntrezGeneID <= 0) {
+		return fmt.Errorf("%s needs a hugoSymbol or an entrezGeneId", field)
+	}
+	return nil
+}
+
+func validateReferenceGenome(referenceGenome string) error {
+	if !referenceGenomes[referenceGenome] {
+		return fmt.Errorf("referenceGenome %q is not one of GRCh37, GRCh38", referenceGenome)
+	}
+	return nil
+}
+
+func validateMutationRequest(q json.RawMessage) error {
+	var req mutationRequest
+	if err := decodeStrict(q, &req); err != nil {
+		return err
+	}
+	if err := validateGene("gene", req.Gene); err != nil {
+		return err
+	}
+	if len(req.Alteration) == 0 {
+		return fmt.Errorf("alteration is required")
+	}
+	return validateReferenceGenome(req.ReferenceGenome)
+}
+
+func validateCopyNumberAlterationRequest(q json.RawMessage) error {
+	var req copyNumberAlterationRequest
+	if err := decodeStrict(q, &req); err != nil {
+		return err
+	}
+	if err := validateGene("gene", req.Gene); err != nil {
+		return err
+	}
+	if !copyNumberAlterationTypes[req.CopyNameAlterationType] {
+		return fmt.Errorf("copyNameAlterationType %q is not valid", req.CopyNameAlterationType)
+	}
+	return validateReferenceGenome(req.ReferenceGenome)
+}
+
+func validateStructuralVariantRequest(q json.RawMessage) error {
+	var req structuralVariantRequest
+	if err := decodeStrict(q, &req); err != nil {
+		return err
+	}
+	if err := validateGene("geneA", req.GeneA); err != nil {
+		return err
+	}
+	if err := validateGene("geneB", req.GeneB); err != nil {
+		return err
+	}
+	if !structuralVariantTypes[req.StructuralVariantType] {
+		return fmt.Errorf("structuralVariantType %q is not valid", req.StructuralVariantType)
+	}
+	return validateReferenceGenome(req.ReferenceGenome)
+}
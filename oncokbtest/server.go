@@ -0,0 +1,273 @@
+// Package oncokbtest provides a fake OncoKB API server for tests and local development.
+//
+// The server replays recorded responses, keyed by the content of each query in a request,
+// validates request bodies the way the OncoKB API does, and can simulate errors and latency.
+package oncokbtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MutationsByProteinChangePath = "/api/v1/annotate/mutations/byProteinChange"
+	CopyNumberAlterationsPath    = "/api/v1/annotate/copyNumberAlterations"
+	StructuralVariantsPath       = "/api/v1/annotate/structuralVariants"
+)
+
+// Fixture is a recorded response to a single query sent to an OncoKB annotate endpoint.
+type Fixture struct {
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+type failure struct {
+	status  int
+	message string
+}
+
+// Server is a fake OncoKB API.  Queries without a recorded response are answered the way OncoKB answers
+// a gene and alteration it knows nothing about, unless the server is strict.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]json.RawMessage
+	latency   time.Duration
+	failures  []failure
+	strict    bool
+	requests  int
+	unmatched int
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLatency delays every response by latency.
+func WithLatency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.latency = latency
+	}
+}
+
+// WithStrictMatching answers queries without a recorded response with a 404 instead of an unknown response.
+func WithStrictMatching() Option {
+	return func(s *Server) {
+		s.strict = true
+	}
+}
+
+// NewServer starts a Server, it should be closed when the test is done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{responses: make(map[string]json.RawMessage)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+MutationsByProteinChangePath, s.handle(validateMutationRequest))
+	mux.HandleFunc("POST "+CopyNumberAlterationsPath, s.handle(validateCopyNumberAlterationRequest))
+	mux.HandleFunc("POST "+StructuralVariantsPath, s.handle(validateStructuralVariantRequest))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// MutationsURL is the URL to give an OncoKBAnnotatorService.
+func (s *Server) MutationsURL() string {
+	return s.URL + MutationsByProteinChangePath
+}
+
+// AddFixture records the response to return for a query.
+func (s *Server) AddFixture(f Fixture) error {
+	key, err := getQueryKey(f.Path, f.Request)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[key] = f.Response
+	return nil
+}
+
+// LoadFixtures reads a JSON array of Fixture from path.
+func (s *Server) LoadFixtures(path string) error {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read fixtures %q: %v", path, err)
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(jsonData, &fixtures); err != nil {
+		return fmt.Errorf("Failed to parse fixtures %q: %v", path, err)
+	}
+	for _, f := range fixtures {
+		if err := s.AddFixture(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FailNext answers the next count requests with status and message, the way OncoKB reports errors.
+func (s *Server) FailNext(count, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, failure{status: status, message: message})
+	}
+}
+
+// Requests returns the number of requests received.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Unmatched returns the number of queries that had no recorded response.
+func (s *Server) Unmatched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unmatched
+}
+
+func (s *Server) handle(validate func(json.RawMessage) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		latency := s.latency
+		var fail *failure
+		if len(s.failures) > 0 {
+			fail = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fail != nil {
+			writeError(w, r, fail.status, fail.message)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") || len(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) == 0 {
+			writeError(w, r, http.StatusUnauthorized, "Full authentication is required to access this resource")
+			return
+		}
+
+		var queries []json.RawMessage
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&queries); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("JSON parse error: %v", err))
+			return
+		}
+		responses := make([]json.RawMessage, 0, len(queries))
+		for _, q := range queries {
+			if err := validate(q); err != nil {
+				writeError(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			resp, err := s.respond(r.URL.Path, q)
+			if err != nil {
+				writeError(w, r, http.StatusNotFound, err.Error())
+				return
+			}
+			responses = append(responses, resp)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responses)
+	}
+}
+
+// respond returns the recorded response for the query, with the query id set to the one asked for.
+func (s *Server) respond(path string, q json.RawMessage) (json.RawMessage, error) {
+	key, err := getQueryKey(path, q)
+	if err != nil {
+		return nil, err
+	}
+	var query map[string]any
+	if err := json.Unmarshal(q, &query); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	recorded, exists := s.responses[key]
+	if !exists {
+		s.unmatched++
+	}
+	s.mu.Unlock()
+
+	var resp map[string]any
+	if exists {
+		if err := json.Unmarshal(recorded, &resp); err != nil {
+			return nil, fmt.Errorf("Recorded response is not valid JSON: %v", err)
+		}
+	} else if s.strict {
+		return nil, fmt.Errorf("No recorded response for %s", key)
+	} else {
+		resp = getUnknownResponse(query)
+	}
+	respQuery, _ := resp["query"].(map[string]any)
+	if respQuery == nil {
+		respQuery = make(map[string]any)
+		resp["query"] = respQuery
+	}
+	respQuery["id"] = query["id"]
+	return json.Marshal(resp)
+}
+
+// getQueryKey normalizes a query, minus its id, so the same query always has the same key.
+func getQueryKey(path string, q json.RawMessage) (string, error) {
+	var query map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(q))
+	decoder.UseNumber()
+	if err := decoder.Decode(&query); err != nil {
+		return "", fmt.Errorf("Query is not a JSON object: %v", err)
+	}
+	delete(query, "id")
+	// encoding/json writes map keys in sorted order
+	normalized, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return path + " " + string(normalized), nil
+}
+
+func getUnknownResponse(query map[string]any) map[string]any {
+	q := make(map[string]any)
+	if gene, ok := query["gene"].(map[string]any); ok {
+		q["hugoSymbol"] = gene["hugoSymbol"]
+		q["entrezGeneId"] = gene["entrezGeneId"]
+	}
+	q["alteration"] = query["alteration"]
+	q["tumorType"] = query["tumorType"]
+	return map[string]any{
+		"query":          q,
+		"geneExist":      false,
+		"variantExist":   false,
+		"oncogenic":      "Unknown",
+		"mutationEffect": map[string]any{"knownEffect": "Unknown", "citations": map[string]any{"pmids": []string{}, "abstracts": []any{}}},
+		"treatments":     []any{},
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"title":   http.StatusText(status),
+		"status":  status,
+		"detail":  message,
+		"message": message,
+		"path":    r.URL.Path,
+	})
+}
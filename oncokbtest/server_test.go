@@ -0,0 +1,66 @@
+package oncokbtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func post(t testing.TB, url, body string) (int, []map[string]any) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer test-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to post request: %v", err)
+	}
+	defer resp.Body.Close()
+	var decoded []map[string]any
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	err := server.AddFixture(Fixture{
+		Path:     MutationsByProteinChangePath,
+		Request:  json.RawMessage(`{"gene": {"hugoSymbol": "BRAF", "entrezGeneId": 0}, "alteration": "V600E", "tumorType": "MEL", "id": "7"}`),
+		Response: json.RawMessage(`{"oncogenic": "Oncogenic", "query": {"id": "7", "hugoSymbol": "BRAF"}}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add fixture: %v", err)
+	}
+
+	status, resp := post(t, server.MutationsURL(), `[
+		{"id": "0", "alteration": "V600E", "tumorType": "MEL", "gene": {"entrezGeneId": 0, "hugoSymbol": "BRAF"}},
+		{"id": "1", "alteration": "G12D", "tumorType": "COAD", "gene": {"hugoSymbol": "KRAS"}}
+	]`)
+	if status != http.StatusOK || len(resp) != 2 {
+		t.Fatalf("expected 2 responses but got %d: %v", status, resp)
+	}
+	if resp[0]["oncogenic"] != "Oncogenic" || resp[0]["query"].(map[string]any)["id"] != "0" {
+		t.Errorf("expected the recorded response for query 0 but got %v", resp[0])
+	}
+	if resp[1]["oncogenic"] != "Unknown" || server.Unmatched() != 1 {
+		t.Errorf("expected an unknown response for query 1 but got %v", resp[1])
+	}
+
+	if status, _ := post(t, server.MutationsURL(), `[{"id": "0", "alteration": "V600E", "gene": {"hugoSymbol": "BRAF"}, "bogus": 1}]`); status != http.StatusBadRequest {
+		t.Errorf("expected an unknown field to be rejected but got %d", status)
+	}
+	if status, _ := post(t, server.MutationsURL(), `[{"id": "0", "alteration": "V600E", "gene": {}}]`); status != http.StatusBadRequest {
+		t.Errorf("expected a query without a gene to be rejected but got %d", status)
+	}
+
+	server.FailNext(1, http.StatusServiceUnavailable, "down for maintenance")
+	if status, _ := post(t, server.MutationsURL(), `[]`); status != http.StatusServiceUnavailable {
+		t.Errorf("expected a simulated 503 but got %d", status)
+	}
+	if status, _ := post(t, server.MutationsURL(), `[]`); status != http.StatusOK {
+		t.Errorf("expected the failure to only last one request but got %d", status)
+	}
+}